@@ -1,33 +1,37 @@
 import { HttpErrorResponse, HttpHandlerFn, HttpRequest } from '@angular/common/http';
 import { inject } from '@angular/core';
 import { Router } from '@angular/router';
-import { tap } from 'rxjs/operators';
+import { from } from 'rxjs';
+import { switchMap, tap } from 'rxjs/operators';
 import { AuthService } from '../services/auth.service';
 import { LoginService } from '../services/login.service';
 
 /**
  * Intercepts API request to handle user authorization against the backend.
  *
- * - Appends the JWT authorization token to every request if available
+ * - Appends the JWT authorization token to every request if available,
+ *   refreshing it first if it is about to expire
  * - Redirects to the login page when the server responded with "401
  *   Unauthorized" to any request.
  * - Redirects to the error page when the server responds with any other error
  *   code.
  */
 export function authInterceptor(req: HttpRequest<unknown>, next: HttpHandlerFn) {
-  if (!isApiRequest(req)) {
+  if (!isApiRequest(req) || isSessionRequest(req)) {
     return next(req);
   }
   const auth = inject(AuthService);
   const router = inject(Router);
   const login = inject(LoginService);
-  const token = auth.getToken();
-  if (token) {
-    req = req.clone({
-      headers: req.headers.set('Authorization', `Bearer ${token}`),
-    });
-  }
-  return next(req).pipe(
+  return from(auth.getFreshToken()).pipe(
+    switchMap((token) => {
+      if (token) {
+        req = req.clone({
+          headers: req.headers.set('Authorization', `Bearer ${token}`),
+        });
+      }
+      return next(req);
+    }),
     tap({
       error: (event) => {
         if (event instanceof HttpErrorResponse) {
@@ -51,3 +55,11 @@ export function authInterceptor(req: HttpRequest<unknown>, next: HttpHandlerFn)
 function isApiRequest(req: HttpRequest<unknown>): boolean {
   return req.url.startsWith('/api/');
 }
+
+/**
+ * Returns true for requests that refresh or end the session. These are sent
+ * without access token and their errors are handled by the auth service.
+ */
+function isSessionRequest(req: HttpRequest<unknown>): boolean {
+  return req.url === '/api/login/refresh' || req.url === '/api/logout';
+}
@@ -1,4 +1,4 @@
-import { HttpClient, HttpHeaders } from '@angular/common/http';
+import { HttpClient, HttpErrorResponse, HttpHeaders } from '@angular/common/http';
 import { Injectable, inject } from '@angular/core';
 import { Router } from '@angular/router';
 import { BehaviorSubject, Observable, firstValueFrom } from 'rxjs';
@@ -11,15 +11,20 @@ export interface Permissions {
 
 interface LoginInformation {
   token: string;
+  refreshToken: string;
   user: User;
 }
 
+/** Time before the expiry of the access token at which it is refreshed. */
+const REFRESH_AHEAD_MS = 60_000;
+
 /**
  * Provides methods to handle user authentication against the server.
  *
  * The user logs in with their LDAP username / password combination. They then
- * obtain a JWT form the server, which we then send with every request to the
- * server.
+ * obtain a short-lived JWT form the server, which we then send with every
+ * request to the server, and a refresh token, which we use to obtain a new JWT
+ * before the current one expires.
  */
 @Injectable({
   providedIn: 'root',
@@ -29,18 +34,36 @@ export class AuthService {
   private router = inject(Router);
 
   private loginInformation = new BehaviorSubject<LoginInformation | null>(null);
+  private refreshTimer?: number;
+  private refreshing?: Promise<void>;
 
   constructor() {
-    const json = localStorage.getItem('loginInformation');
-    if (json) {
-      this.loginInformation.next(JSON.parse(json));
-    }
+    this.loginInformation.next(readStoredLoginInformation());
+    this.scheduleRefresh();
+    // Other browser tabs share the session and refresh it as well.
+    window.addEventListener('storage', (event) => {
+      if (event.key === 'loginInformation') {
+        this.loginInformation.next(readStoredLoginInformation());
+        this.scheduleRefresh();
+      }
+    });
   }
 
   getToken(): string | null {
     return this.loginInformation.value?.token ?? null;
   }
 
+  /**
+   * Returns the access token, refreshing it first if it is about to expire.
+   */
+  async getFreshToken(): Promise<string | null> {
+    const token = this.getToken();
+    if (token && getExpiry(token) - Date.now() < REFRESH_AHEAD_MS / 2) {
+      await this.refresh();
+    }
+    return this.getToken();
+  }
+
   getCurrentLoginInformation(): LoginInformation | null {
     return this.loginInformation.value;
   }
@@ -61,23 +84,91 @@ export class AuthService {
     const headers = new HttpHeaders({
       Authorization: 'Basic ' + toBase64(`${username}:${password}`),
     });
-    const observable = this.httpClient.get<LoginInformation>('/api/login', { headers }).pipe(
-      tap((loginInformation) => {
-        this.loginInformation.next(loginInformation);
-        localStorage.setItem('loginInformation', JSON.stringify(loginInformation));
-      }),
-    );
+    const observable = this.httpClient
+      .get<LoginInformation>('/api/login', { headers })
+      .pipe(tap((loginInformation) => this.setLoginInformation(loginInformation)));
     await firstValueFrom(observable);
   }
 
   /**
-   * Removes saved login information and navigates to the login page.
+   * Ends the session on the server, removes saved login information and
+   * navigates to the login page.
    */
   logout(): void {
-    this.loginInformation.next(null);
-    localStorage.removeItem('loginInformation');
+    const refreshToken = this.loginInformation.value?.refreshToken;
+    if (refreshToken) {
+      this.httpClient.post('/api/logout', { refreshToken }).subscribe({ error: () => {} });
+    }
+    this.setLoginInformation(null);
     this.router.navigate(['login']);
   }
+
+  /**
+   * Obtains a new access token. Concurrent calls share the same request.
+   */
+  private refresh(): Promise<void> {
+    this.refreshing ??= this.doRefresh().finally(() => (this.refreshing = undefined));
+    return this.refreshing;
+  }
+
+  private async doRefresh(): Promise<void> {
+    const refreshToken = this.loginInformation.value?.refreshToken;
+    if (!refreshToken) {
+      return;
+    }
+    const observable = this.httpClient.post<LoginInformation>('/api/login/refresh', {
+      refreshToken,
+    });
+    try {
+      this.setLoginInformation(await firstValueFrom(observable));
+    } catch (error) {
+      // Another browser tab might have refreshed the session at the same time.
+      // Otherwise, the next request fails with "401 Unauthorized" and the user
+      // is asked to log in again.
+      const stored = readStoredLoginInformation();
+      if (stored && stored.refreshToken !== refreshToken) {
+        this.setLoginInformation(stored);
+      } else if (!(error instanceof HttpErrorResponse && error.status === 401)) {
+        this.scheduleRefresh();
+      }
+    }
+  }
+
+  private setLoginInformation(loginInformation: LoginInformation | null): void {
+    this.loginInformation.next(loginInformation);
+    if (loginInformation) {
+      localStorage.setItem('loginInformation', JSON.stringify(loginInformation));
+    } else {
+      localStorage.removeItem('loginInformation');
+    }
+    this.scheduleRefresh();
+  }
+
+  private scheduleRefresh(): void {
+    window.clearTimeout(this.refreshTimer);
+    const token = this.getToken();
+    if (token && this.loginInformation.value?.refreshToken) {
+      const delay = Math.max(getExpiry(token) - Date.now() - REFRESH_AHEAD_MS, 5_000);
+      this.refreshTimer = window.setTimeout(() => this.refresh(), delay);
+    }
+  }
+}
+
+function readStoredLoginInformation(): LoginInformation | null {
+  const json = localStorage.getItem('loginInformation');
+  return json ? JSON.parse(json) : null;
+}
+
+/**
+ * Returns the expiry of the given JWT in milliseconds since the epoch.
+ */
+function getExpiry(token: string): number {
+  try {
+    const payload = token.split('.')[1].replace(/-/g, '+').replace(/_/g, '/');
+    return JSON.parse(atob(payload)).exp * 1000;
+  } catch {
+    return 0;
+  }
 }
 
 /**
@@ -58,16 +58,20 @@
       .map(node => (
           table.cell(inset: (left: 1em * level))[
             #[
-              #set text(weight: "bold") if node.AppraisalDecision == "A"
+              #set text(weight: "bold") if node.AppraisalDecision in ("A", "B")
               #node.Title
             ]
             #if node.AppraisalNote != "" [
               #set text(size: 8pt)
               \ Bewertungsnotiz: #node.AppraisalNote
             ]
+            #if node.AppraisalPublicNote != "" [
+              #set text(size: 8pt)
+              \ Begründung: #node.AppraisalPublicNote
+            ]
           ],
           table.cell(inset: (right: 0pt))[
-            #set text(weight: "bold") if node.AppraisalDecision == "A"
+            #set text(weight: "bold") if node.AppraisalDecision in ("A", "B")
             #show "V": "K"
             #node.AppraisalDecision
           ],
@@ -60,6 +60,9 @@
   ] else [
     #columns.push((label: "Übernommen", key: "Archived"))
   ]
+  #if all.any(el => el.Sampled > 0) [
+    #columns.push((label: "davon Stichprobe", key: "Sampled"))
+  ]
   #columns.push((label: "Kassiert", key: "Discarded"))
   #if all.any(el => el.Missing > 0) [
     #columns.push((label: "Fehlend", key: "Missing"))
@@ -2,4 +2,4 @@
 
 set -e
 cd -- "$( dirname -- "${BASH_SOURCE[0]}" )/.."
-git clean -dxf data/archive data/message_store data/mongo data/transfer_dir data/webdav
\ No newline at end of file
+git clean -dxf data/archive data/message_store data/quarantine_store data/mongo data/transfer_dir data/webdav
\ No newline at end of file
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"lath/xman/internal/archive"
 	"lath/xman/internal/archive/dimag"
+	"lath/xman/internal/audit"
 	"lath/xman/internal/auth"
 	"lath/xman/internal/core"
 	"lath/xman/internal/db"
@@ -20,7 +22,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -40,48 +44,91 @@ func main() {
 	router.GET("api", getDefaultResponse)
 	router.GET("api/about", getAbout)
 	router.GET("api/login", auth.Login)
+	router.GET("api/login/methods", auth.LoginMethods)
+	router.GET("api/login/oidc", auth.StartOIDCLogin)
+	router.POST("api/login/oidc", auth.FinishOIDCLogin)
+	router.POST("api/login/refresh", auth.RefreshSession)
+	router.POST("api/logout", auth.Logout)
 	router.GET("api/updates", auth.AuthRequiredQueryParam(), getUpdates)
 	authorized := router.Group("/")
 	authorized.Use(auth.AuthRequired())
 	authorized.GET("api/config", getConfig)
-	authorized.GET("api/processes/my", getMyProcesses)
-	authorized.GET("api/process/:processId", getProcessData)
-	authorized.GET("api/message/:processId/:messageType", getMessage)
-	authorized.GET("api/root-records/:processId/:messageType", getRootRecords)
-	authorized.GET("api/all-record-objects-appraised/:processId", areAllRecordObjectsAppraised)
-	authorized.GET("api/primary-document", getPrimaryDocument)
-	authorized.GET("api/primary-documents-info/:processId", getPrimaryDocumentsInfo)
-	authorized.GET("api/primary-document-data/:processId/:filename", getPrimaryDocumentData)
-	authorized.GET("api/report/appraisal/:processId", getAppraisalReport)
-	authorized.GET("api/report/submission/:processId", getSubmissionReport)
 	authorized.GET("api/archive-collections", getCollections)
 	authorized.GET("api/user-info", getUserInformation)
 	authorized.POST("api/user-preferences", setUserPreferences)
-	authorized.GET("api/appraisals/:processId", getAppraisals)
-	authorized.POST("api/appraisal-decision", setAppraisalDecision)
-	authorized.POST("api/appraisal-note", setAppraisalNote)
-	authorized.POST("api/appraisals", setAppraisals)
-	authorized.PATCH("api/finalize-message-appraisal/:processId", finalizeMessageAppraisal)
-	authorized.GET("api/packaging/:processId", getPackaging)
-	authorized.POST("api/packaging", setPackagingChoice)
-	authorized.POST("api/packaging-stats/:processId", getPackagingStatsForOptions)
-	authorized.PATCH("api/archive-0503-message/:processId", archive0503Message)
-	authorized.PATCH("api/process-note/:processId", setProcessNote)
 	authorized.GET("api/task/:id", getTask)
+	authorized.POST("api/user-password", auth.LocalUsersRequired(), changePassword)
+	authorized.GET("api/absences", getAbsences)
+	authorized.PUT("api/absences", audit.Log(audit.ActionCreateAbsence), createAbsence)
+	authorized.DELETE("api/absences/:id", audit.Log(audit.ActionDeleteAbsence), deleteAbsence)
+	read := router.Group("/")
+	read.Use(
+		auth.PermissionRequired(auth.PermissionReadProcesses),
+		auth.ProcessAccessRequired(auth.PermissionReadProcesses),
+	)
+	read.GET("api/processes/my", getMyProcesses)
+	read.GET("api/processes", getProcesses)
+	read.GET("api/process/:processId", getProcessData)
+	read.GET("api/message/:processId/:messageType", getMessage)
+	read.GET("api/root-records/:processId/:messageType", getRootRecords)
+	read.GET("api/record-tree/:processId/:messageType", getRecordTreeRoots)
+	read.GET("api/record-tree/:processId/:messageType/:recordId/children", getRecordTreeChildren)
+	read.GET("api/record/:processId/:messageType/:recordId", getRecord)
+	read.GET("api/records/search", searchRecords)
+	read.GET("api/primary-documents/search", searchPrimaryDocuments)
+	read.GET("api/all-record-objects-appraised/:processId", areAllRecordObjectsAppraised)
+	read.GET("api/primary-document", audit.Log(audit.ActionDownloadPrimaryDocument), getPrimaryDocument)
+	read.GET("api/primary-documents-info/:processId", getPrimaryDocumentsInfo)
+	read.GET("api/primary-document-data/:processId/:filename", getPrimaryDocumentData)
+	read.GET("api/report/appraisal/:processId", getAppraisalReport)
+	read.GET("api/report/submission/:processId", getSubmissionReport)
+	read.GET("api/appraisals/:processId", getAppraisals)
+	read.GET("api/packaging/:processId", getPackaging)
+	read.POST("api/packaging-stats/:processId", getPackagingStatsForOptions)
+	read.GET("api/appraisal-statistics", getAppraisalStatistics)
+	edit := router.Group("/")
+	edit.Use(
+		auth.PermissionRequired(auth.PermissionEditProcesses),
+		auth.ProcessAccessRequired(auth.PermissionEditProcesses),
+	)
+	edit.POST("api/appraisal-decision", audit.Log(audit.ActionChangeAppraisal), setAppraisalDecision)
+	edit.POST("api/appraisal-note", audit.Log(audit.ActionChangeAppraisal), setAppraisalNote)
+	edit.POST("api/appraisal-public-note", audit.Log(audit.ActionChangeAppraisal), setAppraisalPublicNote)
+	edit.POST("api/appraisals", audit.Log(audit.ActionChangeAppraisal), setAppraisals)
+	edit.POST("api/appraisal-sampling", audit.Log(audit.ActionChangeAppraisal), applyAppraisalSampling)
+	edit.PATCH("api/finalize-message-appraisal/:processId", audit.Log(audit.ActionFinalizeAppraisal), finalizeMessageAppraisal)
+	edit.PATCH("api/reopen-appraisal/:processId", audit.Log(audit.ActionReopenAppraisal), reopenAppraisal)
+	edit.POST("api/packaging", setPackagingChoice)
+	edit.PATCH("api/archive-0503-message/:processId", audit.Log(audit.ActionStartArchiving), archive0503Message)
+	edit.PATCH("api/process-note/:processId", setProcessNote)
+	edit.PATCH("api/appraisal-level/:processId", setProcessAppraisalLevel)
+	resolve := router.Group("/")
+	resolve.Use(
+		auth.PermissionRequired(auth.PermissionResolveErrors),
+		auth.ProcessAccessRequired(auth.PermissionResolveErrors),
+	)
+	resolve.POST("api/message/:processId/:messageType/reimport", audit.Log(audit.ActionReimportMessage), reimportMessage)
+	resolve.DELETE("api/message/:processId/:messageType", audit.Log(audit.ActionDeleteMessage), deleteMessage)
+	resolve.GET("api/processing-errors", getProcessingErrors)
+	resolve.POST("api/processing-errors/resolve/:id", audit.Log(audit.ActionResolveError), resolveProcessingError)
+	resolve.POST("api/warning/:id/acknowledge", acknowledgeWarning)
+	resolve.GET("api/quarantine", getQuarantinedFiles)
+	resolve.GET("api/quarantine/:id", getQuarantinedFile)
+	resolve.GET("api/quarantine/:id/download", downloadQuarantinedFile)
+	resolve.POST("api/quarantine/:id/release", releaseQuarantinedFile)
+	resolve.DELETE("api/quarantine/:id", purgeQuarantinedFile)
 	admin := router.Group("/")
-	admin.Use(auth.AdminRequired())
-	admin.GET("api/processes", getProcesses)
-	admin.DELETE("api/process/:processId", deleteProcess)
-	admin.POST("api/message/:processId/:messageType/reimport", reimportMessage)
-	admin.DELETE("api/message/:processId/:messageType", deleteMessage)
-	admin.GET("api/processing-errors", getProcessingErrors)
-	admin.POST("api/processing-errors/resolve/:id", resolveProcessingError)
+	admin.Use(auth.PermissionRequired(auth.PermissionAdministrate))
+	admin.DELETE("api/process/:processId", audit.Log(audit.ActionDeleteProcess), deleteProcess)
 	admin.GET("api/admin-config", getAdminConfig)
 	admin.GET("api/users", users)
+	admin.POST("api/users/sync", syncUsers)
+	admin.GET("api/users/orphaned", getOrphanedUsers)
 	admin.GET("api/agencies", getAgencies)
-	admin.PUT("api/agency", putAgency)
-	admin.POST("api/agency", postAgency)
-	admin.DELETE("api/agency/:id", deleteAgency)
+	admin.PUT("api/agency", audit.Log(audit.ActionCreateAgency), putAgency)
+	admin.POST("api/agency", audit.Log(audit.ActionUpdateAgency), postAgency)
+	admin.DELETE("api/agency/:id", audit.Log(audit.ActionDeleteAgency), deleteAgency)
+	admin.POST("api/agency/:id/message", uploadMessage)
 	admin.PUT("api/archive-collection", putCollection)
 	admin.POST("api/archive-collection", postCollection)
 	admin.DELETE("api/archive-collection/:id", deleteCollection)
@@ -89,6 +136,20 @@ func main() {
 	admin.GET("api/tasks", getTasks)
 	admin.POST("api/task/action/:id", taskAction)
 	admin.GET("api/dimag-collection-ids", getCollectionDimagIDs)
+	admin.GET("api/access-denials", getAccessDenials)
+	admin.GET("api/audit-log", getAuditLog)
+	admin.GET("api/audit-log/export", exportAuditLog)
+	admin.GET("api/api-tokens", getAPITokens)
+	admin.POST("api/api-tokens", createAPIToken)
+	admin.DELETE("api/api-tokens/:id", revokeAPIToken)
+	admin.GET("api/local-users", auth.LocalUsersRequired(), getLocalUsers)
+	admin.POST("api/local-users", auth.LocalUsersRequired(), audit.Log(audit.ActionCreateLocalUser), createLocalUser)
+	admin.POST("api/local-users/:id", auth.LocalUsersRequired(), audit.Log(audit.ActionUpdateLocalUser), updateLocalUser)
+	admin.DELETE("api/local-users/:id", auth.LocalUsersRequired(), audit.Log(audit.ActionDeleteLocalUser), deleteLocalUser)
+	admin.POST("api/local-users/:id/password", auth.LocalUsersRequired(), audit.Log(audit.ActionResetPassword), resetLocalUserPassword)
+	admin.GET("api/sessions", getSessions)
+	admin.DELETE("api/sessions", revokeUserSessions)
+	admin.DELETE("api/sessions/:id", revokeSession)
 	router.Run()
 }
 
@@ -117,12 +178,15 @@ func migrateData() {
 	if err != nil {
 		log.Printf("agency migration failed: %v", err)
 	}
+	core.BackfillAppraisalStatistics()
+	err = db.MigrateRecordIndex(context.Background())
+	if err != nil {
+		log.Printf("record index migration failed: %v", err)
+	}
 }
 
 func testConfiguration() {
-	log.Println("Testing connection to LDAP server...")
 	auth.TestConnection()
-	log.Println("Connection to LDAP server successful")
 	if os.Getenv("SMTP_SERVER") != "" {
 		log.Println("Testing connection to SMTP server...")
 		err := mail.TestConnection()
@@ -221,8 +285,15 @@ func getConfig(c *gin.Context) {
 	})
 }
 
+// getProcessingErrors returns all processing errors or, for agency-scoped
+// administrators, the processing errors of their agencies.
 func getProcessingErrors(c *gin.Context) {
-	processingErrors := db.FindProcessingErrors(c)
+	var processingErrors []db.ProcessingError
+	if agencyIDs := auth.AccessibleAgencyIDs(c, auth.PermissionResolveErrors); agencyIDs == nil {
+		processingErrors = db.FindProcessingErrors(c)
+	} else {
+		processingErrors = db.FindProcessingErrorsForAgencies(c, agencyIDs)
+	}
 	c.JSON(http.StatusOK, processingErrors)
 }
 
@@ -237,16 +308,165 @@ func resolveProcessingError(c *gin.Context) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	var agencyID *primitive.ObjectID
+	if processingError.Agency != nil {
+		agencyID = &processingError.Agency.ID
+	}
+	if !auth.AuthorizeAgency(c, auth.PermissionResolveErrors, agencyID) {
+		return
+	}
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		panic(err)
 	}
+	if processingError.ProcessID != nil {
+		audit.SetProcessID(c, *processingError.ProcessID)
+	}
+	if agencyID != nil {
+		audit.SetAgencyID(c, *agencyID)
+	}
+	audit.AddTargetIDs(c, string(body))
 	userID := c.MustGet("userId").(string)
 	userName := auth.GetDisplayName(userID)
 	core.Resolve(processingError, db.ProcessingErrorResolution(body), userName)
 	c.Status(http.StatusAccepted)
 }
 
+// acknowledgeWarning marks the schema violations of a message as acknowledged,
+// which is required for archiving.
+func acknowledgeWarning(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	warning, found := db.FindWarning(c.Request.Context(), id)
+	if !found {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !auth.AuthorizeProcess(c, auth.PermissionResolveErrors, warning.ProcessID) {
+		return
+	}
+	if warning.SchemaViolationCount == 0 {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("warning has no schema violations"))
+		return
+	}
+	userID := c.MustGet("userId").(string)
+	db.UpdateWarningAcknowledged(warning, auth.GetDisplayName(userID))
+	c.Status(http.StatusAccepted)
+}
+
+// getQuarantinedFiles returns a page of quarantined transfer files, optionally
+// filtered by agency.
+func getQuarantinedFiles(c *gin.Context) {
+	offset, limit, err := paginationParams(c, 100)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	var agencyIDs []primitive.ObjectID
+	if idParam := c.Query("agencyId"); idParam != "" {
+		id, err := primitive.ObjectIDFromHex(idParam)
+		if err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		if !auth.AuthorizeAgency(c, auth.PermissionResolveErrors, &id) {
+			return
+		}
+		agencyIDs = []primitive.ObjectID{id}
+	} else {
+		agencyIDs = auth.AccessibleAgencyIDs(c, auth.PermissionResolveErrors)
+	}
+	files, total := db.FindQuarantinedFiles(c.Request.Context(), agencyIDs, offset, limit)
+	if files == nil {
+		files = make([]db.QuarantinedFile, 0)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total": total,
+		"files": files,
+	})
+}
+
+func getQuarantinedFile(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	f, found := db.FindQuarantinedFile(c.Request.Context(), id)
+	if !found {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !auth.AuthorizeAgency(c, auth.PermissionResolveErrors, &f.AgencyID) {
+		return
+	}
+	c.JSON(http.StatusOK, f)
+}
+
+func downloadQuarantinedFile(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	f, found := db.FindQuarantinedFile(c.Request.Context(), id)
+	if !found {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !auth.AuthorizeAgency(c, auth.PermissionResolveErrors, &f.AgencyID) {
+		return
+	}
+	c.FileAttachment(f.StorePath, filepath.Base(f.TransferPath))
+}
+
+// releaseQuarantinedFile moves a quarantined file back to the transfer
+// directory to have it imported again. Manually uploaded files are imported
+// again from the message store.
+func releaseQuarantinedFile(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	if f, found := db.FindQuarantinedFile(c.Request.Context(), id); found &&
+		!auth.AuthorizeAgency(c, auth.PermissionResolveErrors, &f.AgencyID) {
+		return
+	}
+	err = core.ReleaseQuarantinedFile(c.Request.Context(), id)
+	if err == core.ErrQuarantinedFileNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusConflict, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+func purgeQuarantinedFile(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	if f, found := db.FindQuarantinedFile(c.Request.Context(), id); found &&
+		!auth.AuthorizeAgency(c, auth.PermissionResolveErrors, &f.AgencyID) {
+		return
+	}
+	err = core.PurgeQuarantinedFile(c.Request.Context(), id)
+	if err == core.ErrQuarantinedFileNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		panic(err)
+	}
+	c.Status(http.StatusAccepted)
+}
+
 func getProcessData(c *gin.Context) {
 	processID := c.Param("processId")
 	process, found := db.FindProcess(c.Request.Context(), processID)
@@ -270,16 +490,107 @@ func getProcessData(c *gin.Context) {
 }
 
 func getMyProcesses(c *gin.Context) {
-	userID := c.MustGet("userId").(string)
-	processes := db.FindProcessesForUser(c.Request.Context(), userID)
-	c.JSON(http.StatusOK, processes)
+	query, err := processQueryParams(c)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	query.UserIDs = auth.AssignedAgencyUsers(c.Request.Context(), c.MustGet("userId").(string))
+	writeProcesses(c, query)
 }
 
+// getProcesses returns all processes or, for users whose permission to read
+// processes is limited to their agencies, the processes of their agencies.
 func getProcesses(c *gin.Context) {
-	processes := db.FindProcesses(c)
+	query, err := processQueryParams(c)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	query.AgencyIDs = auth.AccessibleAgencyIDs(c, auth.PermissionReadProcesses)
+	writeProcesses(c, query)
+}
+
+// writeProcesses responds with the processes matching the given query.
+//
+// The total number of matching processes is returned in the header
+// X-Total-Count, so the response body stays a plain list of processes.
+func writeProcesses(c *gin.Context, query db.ProcessQuery) {
+	processes, total := db.QueryProcesses(c.Request.Context(), query)
+	if processes == nil {
+		processes = make([]db.SubmissionProcess, 0)
+	}
+	c.Header("X-Total-Count", strconv.Itoa(total))
 	c.JSON(http.StatusOK, processes)
 }
 
+// processQueryParams reads filter, sort, and pagination options for process
+// lists from the query parameters.
+//
+// Without limit, all matching processes are returned.
+func processQueryParams(c *gin.Context) (q db.ProcessQuery, err error) {
+	q.Offset, q.Limit, err = paginationParams(c, 0)
+	if err != nil {
+		return q, err
+	}
+	if idParam := c.Query("agencyId"); idParam != "" {
+		id, err := primitive.ObjectIDFromHex(idParam)
+		if err != nil {
+			return q, err
+		}
+		q.AgencyID = &id
+	}
+	q.Step = db.ProcessStepType(c.Query("step"))
+	q.StepState = db.StepState(c.Query("stepState"))
+	switch q.Step {
+	case "", db.ProcessStepReceive0501, db.ProcessStepAppraisal, db.ProcessStepReceive0505,
+		db.ProcessStepReceive0503, db.ProcessStepFormatVerification, db.ProcessStepArchiving,
+		db.ProcessStepTextExtraction:
+	default:
+		return q, fmt.Errorf("invalid process step: %s", q.Step)
+	}
+	switch q.StepState {
+	case "", db.StepStateComplete, db.StepStateIncomplete, db.StepStateRunning, db.StepStateError:
+	default:
+		return q, fmt.Errorf("invalid step state: %s", q.StepState)
+	}
+	if hasErrorsParam := c.Query("hasErrors"); hasErrorsParam != "" {
+		hasErrors, err := strconv.ParseBool(hasErrorsParam)
+		if err != nil {
+			return q, err
+		}
+		q.HasUnresolvedErrors = &hasErrors
+	}
+	if fromParam := c.Query("from"); fromParam != "" {
+		q.CreatedFrom, err = time.ParseInLocation(time.DateOnly, fromParam, time.Local)
+		if err != nil {
+			return q, err
+		}
+	}
+	if toParam := c.Query("to"); toParam != "" {
+		to, err := time.ParseInLocation(time.DateOnly, toParam, time.Local)
+		if err != nil {
+			return q, err
+		}
+		q.CreatedTo = to.AddDate(0, 0, 1)
+	}
+	q.Text = strings.TrimSpace(c.Query("q"))
+	q.Sort = db.ProcessSortField(c.Query("sort"))
+	switch q.Sort {
+	case "", db.ProcessSortCreatedAt, db.ProcessSortAgency, db.ProcessSortState:
+	default:
+		return q, fmt.Errorf("invalid sort field: %s", q.Sort)
+	}
+	switch order := c.Query("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		return q, fmt.Errorf("invalid sort order: %s", order)
+	}
+	return q, nil
+}
+
 func deleteProcess(c *gin.Context) {
 	processID := c.Param("processId")
 	if found := core.DeleteProcess(processID); found {
@@ -327,6 +638,178 @@ func getRootRecords(c *gin.Context) {
 	c.JSON(http.StatusOK, rootRecords)
 }
 
+// getRecordTreeRoots returns the root records of a message without their
+// sub-records. Each record includes the number of its direct children.
+//
+// Results are paginated with the query parameters offset and limit.
+func getRecordTreeRoots(c *gin.Context) {
+	processID := c.Param("processId")
+	messageType := c.Param("messageType")
+	offset, limit, err := paginationParams(c, 100)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	records, total := core.RecordTreeRoots(
+		c.Request.Context(), processID, db.MessageType(messageType), offset, limit,
+	)
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"records": records,
+	})
+}
+
+// getRecordTreeChildren returns the direct children of a record without their
+// sub-records. Each record includes the number of its direct children.
+//
+// Results are paginated with the query parameters offset and limit.
+func getRecordTreeChildren(c *gin.Context) {
+	processID := c.Param("processId")
+	messageType := c.Param("messageType")
+	recordID := c.Param("recordId")
+	offset, limit, err := paginationParams(c, 100)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	records, total, err := core.RecordTreeChildren(
+		c.Request.Context(), processID, db.MessageType(messageType), recordID, offset, limit,
+	)
+	if err != nil {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"records": records,
+	})
+}
+
+// getRecord returns the metadata of a single record without its sub-records.
+func getRecord(c *gin.Context) {
+	processID := c.Param("processId")
+	messageType := c.Param("messageType")
+	recordID := c.Param("recordId")
+	record, found := core.FindRecord(c.Request.Context(), processID, db.MessageType(messageType), recordID)
+	if !found {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
+
+// searchRecords searches records of all processes of agencies the user is
+// assigned to.
+//
+// Results are paginated with the query parameters offset and limit.
+func searchRecords(c *gin.Context) {
+	accessibleAgencyIDs := auth.AccessibleAgencyIDs(c, auth.PermissionReadProcesses)
+	var agencyID *primitive.ObjectID
+	if idParam := c.Query("agencyId"); idParam != "" {
+		id, err := primitive.ObjectIDFromHex(idParam)
+		if err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		agencyID = &id
+	}
+	filter := db.RecordSearchFilter{
+		MessageType:          db.MessageType(c.Query("messageType")),
+		RecordType:           db.RecordType(c.Query("recordType")),
+		Subject:              c.Query("subject"),
+		RecordNumber:         c.Query("recordNumber"),
+		FilePlanNumber:       c.Query("filePlanNumber"),
+		LifetimeFrom:         c.Query("lifetimeFrom"),
+		LifetimeTo:           c.Query("lifetimeTo"),
+		ConfidentialityLevel: db.ConfidentialityLevel(c.Query("confidentialityLevel")),
+		AppraisalDecision:    db.AppraisalDecisionOption(c.Query("appraisalDecision")),
+	}
+	switch filter.RecordType {
+	case "", db.RecordTypeFile, db.RecordTypeProcess, db.RecordTypeDocument:
+	default:
+		c.AbortWithError(http.StatusUnprocessableEntity,
+			fmt.Errorf("invalid record type: %s", filter.RecordType))
+		return
+	}
+	if filter.AppraisalDecision != "" && !filter.AppraisalDecision.IsComplete() {
+		c.AbortWithError(http.StatusUnprocessableEntity,
+			fmt.Errorf("invalid appraisal decision: %s", filter.AppraisalDecision))
+		return
+	}
+	for _, date := range []string{filter.LifetimeFrom, filter.LifetimeTo} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+	offset, limit, err := paginationParams(c, 50)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	results, total := core.SearchRecords(c.Request.Context(), accessibleAgencyIDs, agencyID, filter, offset, limit)
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"records": results,
+	})
+}
+
+// searchPrimaryDocuments performs a full-text search on primary documents of
+// all processes of agencies the user is assigned to.
+//
+// Results are paginated with the query parameters offset and limit.
+func searchPrimaryDocuments(c *gin.Context) {
+	accessibleAgencyIDs := auth.AccessibleAgencyIDs(c, auth.PermissionReadProcesses)
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("missing search query"))
+		return
+	}
+	var agencyID *primitive.ObjectID
+	if idParam := c.Query("agencyId"); idParam != "" {
+		id, err := primitive.ObjectIDFromHex(idParam)
+		if err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		agencyID = &id
+	}
+	offset, limit, err := paginationParams(c, 50)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	results, total := core.SearchPrimaryDocuments(
+		c.Request.Context(), accessibleAgencyIDs, agencyID, c.Query("processId"), query, offset, limit,
+	)
+	c.JSON(http.StatusOK, gin.H{
+		"total":     total,
+		"documents": results,
+	})
+}
+
+// paginationParams reads the query parameters offset and limit. If no limit is
+// given, defaultLimit is returned.
+func paginationParams(c *gin.Context, defaultLimit int) (offset, limit int, err error) {
+	offset, limit = 0, defaultLimit
+	if offsetParam := c.Query("offset"); offsetParam != "" {
+		offset, err = strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset: %s", offsetParam)
+		}
+	}
+	if limitParam := c.Query("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > 500 {
+			return 0, 0, fmt.Errorf("invalid limit: %s", limitParam)
+		}
+	}
+	return offset, limit, nil
+}
+
 func getAppraisals(c *gin.Context) {
 	processID := c.Param("processId")
 	appraisals := db.FindAppraisalsForProcess(c.Request.Context(), processID)
@@ -367,6 +850,22 @@ func setAppraisalNote(c *gin.Context) {
 	c.JSON(http.StatusAccepted, appraisals)
 }
 
+func setAppraisalPublicNote(c *gin.Context) {
+	processID := c.Query("processId")
+	recordID := c.Query("recordId")
+	publicNote, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		panic(err)
+	}
+	err = core.SetAppraisalPublicNote(processID, recordID, string(publicNote))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	appraisals := db.FindAppraisalsForProcess(c.Request.Context(), processID)
+	c.JSON(http.StatusAccepted, appraisals)
+}
+
 type MultiAppraisalBody struct {
 	ProcessID       string                     `json:"processId"`
 	RecordObjectIDs []string                   `json:"recordObjectIds"`
@@ -386,6 +885,11 @@ func setAppraisals(c *gin.Context) {
 		c.AbortWithError(http.StatusUnprocessableEntity, err)
 		return
 	}
+	if !auth.AuthorizeProcess(c, auth.PermissionEditProcesses, parsedBody.ProcessID) {
+		return
+	}
+	audit.SetProcessID(c, parsedBody.ProcessID)
+	audit.AddTargetIDs(c, parsedBody.RecordObjectIDs...)
 	err = core.SetAppraisals(
 		parsedBody.ProcessID,
 		parsedBody.RecordObjectIDs,
@@ -400,6 +904,44 @@ func setAppraisals(c *gin.Context) {
 	c.JSON(http.StatusAccepted, appraisals)
 }
 
+type SamplingBody struct {
+	ProcessID       string               `json:"processId"`
+	RecordObjectIDs []string             `json:"recordObjectIds"`
+	Options         core.SamplingOptions `json:"options"`
+	InternalNote    string               `json:"internalNote"`
+}
+
+func applyAppraisalSampling(c *gin.Context) {
+	jsonBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	var parsedBody SamplingBody
+	err = json.Unmarshal(jsonBody, &parsedBody)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	if !auth.AuthorizeProcess(c, auth.PermissionEditProcesses, parsedBody.ProcessID) {
+		return
+	}
+	audit.SetProcessID(c, parsedBody.ProcessID)
+	audit.AddTargetIDs(c, parsedBody.RecordObjectIDs...)
+	err = core.ApplySampling(
+		parsedBody.ProcessID,
+		parsedBody.RecordObjectIDs,
+		parsedBody.Options,
+		parsedBody.InternalNote,
+	)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to apply sampling: %v", err))
+		return
+	}
+	appraisals := db.FindAppraisalsForProcess(c.Request.Context(), parsedBody.ProcessID)
+	c.JSON(http.StatusAccepted, appraisals)
+}
+
 func finalizeMessageAppraisal(c *gin.Context) {
 	processID := c.Param("processId")
 	message, found := db.FindMessage(c, processID, db.MessageType0501)
@@ -417,7 +959,7 @@ func finalizeMessageAppraisal(c *gin.Context) {
 		return
 	}
 	userID := c.MustGet("userId").(string)
-	userName := auth.GetDisplayName(userID)
+	userName := auth.ActingUserName(c.Request.Context(), userID, process.Agency)
 	message = core.FinalizeMessageAppraisal(message, userName)
 	err := core.Send0502Message(process.Agency, message)
 	if err != nil {
@@ -466,13 +1008,80 @@ func finalizeMessageAppraisal(c *gin.Context) {
 	}
 }
 
-func areAllRecordObjectsAppraised(c *gin.Context) {
-	processID := c.Param("processId")
-	appraisalComplete := core.AreAllRecordObjectsAppraised(c.Request.Context(), processID)
-	c.JSON(http.StatusOK, appraisalComplete)
-}
-
-func getPackaging(c *gin.Context) {
+// reopenAppraisal reverts the finalization of an appraisal. The request body
+// contains the mode for handling the previous 0502 message, which defaults to
+// "replace".
+func reopenAppraisal(c *gin.Context) {
+	processID := c.Param("processId")
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		panic(err)
+	}
+	mode := db.Correction0502Mode(body)
+	if mode == "" {
+		mode = db.Correction0502ModeReplace
+	}
+	userID := c.MustGet("userId").(string)
+	userName := auth.GetDisplayName(userID)
+	if process, ok := db.FindProcess(c.Request.Context(), processID); ok {
+		userName = auth.ActingUserName(c.Request.Context(), userID, process.Agency)
+	}
+	err = core.ReopenAppraisal(processID, mode, userName)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// getAppraisalStatistics returns aggregated appraisal statistics.
+//
+// Query parameters (all optional):
+//   - agencyId: restrict statistics to the given agency, required for users
+//     whose permission to read processes is limited to their agencies
+//   - from: first day to include (YYYY-MM-DD)
+//   - to: last day to include (YYYY-MM-DD)
+func getAppraisalStatistics(c *gin.Context) {
+	var agencyID *primitive.ObjectID
+	if idParam := c.Query("agencyId"); idParam != "" {
+		id, err := primitive.ObjectIDFromHex(idParam)
+		if err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		agencyID = &id
+	}
+	if !auth.AuthorizeAgency(c, auth.PermissionReadProcesses, agencyID) {
+		return
+	}
+	var from, to time.Time
+	if fromParam := c.Query("from"); fromParam != "" {
+		var err error
+		from, err = time.ParseInLocation(time.DateOnly, fromParam, time.Local)
+		if err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+	if toParam := c.Query("to"); toParam != "" {
+		t, err := time.ParseInLocation(time.DateOnly, toParam, time.Local)
+		if err != nil {
+			c.AbortWithError(http.StatusUnprocessableEntity, err)
+			return
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	statistics := core.AppraisalStatistics(c.Request.Context(), agencyID, from, to)
+	c.JSON(http.StatusOK, statistics)
+}
+
+func areAllRecordObjectsAppraised(c *gin.Context) {
+	processID := c.Param("processId")
+	appraisalComplete := core.AreAllRecordObjectsAppraised(c.Request.Context(), processID)
+	c.JSON(http.StatusOK, appraisalComplete)
+}
+
+func getPackaging(c *gin.Context) {
 	processID := c.Param("processId")
 	decisions, stats, choices := core.Packaging(processID)
 	c.JSON(http.StatusOK, gin.H{
@@ -502,8 +1111,7 @@ func getPackagingStatsForOptions(c *gin.Context) {
 		c.AbortWithError(http.StatusUnprocessableEntity, err)
 		return
 	}
-	rootRecords := db.FindRootRecords(c.Request.Context(), processID, db.MessageType0503, recordIDs)
-	statsMap := core.PackagingStatsForChoices(rootRecords.Files)
+	statsMap := core.PackagingStatsForChoices(processID, recordIDs)
 	c.JSON(http.StatusOK, statsMap)
 }
 
@@ -523,6 +1131,9 @@ func setPackagingChoice(c *gin.Context) {
 		c.AbortWithError(http.StatusUnprocessableEntity, err)
 		return
 	}
+	if !auth.AuthorizeProcess(c, auth.PermissionEditProcesses, data.ProcessID) {
+		return
+	}
 	for _, id := range data.RecordIDs {
 		db.UpsertPackagingChoice(data.ProcessID, id, data.Packaging)
 	}
@@ -546,15 +1157,40 @@ func setProcessNote(c *gin.Context) {
 	}
 }
 
+func setProcessAppraisalLevel(c *gin.Context) {
+	processID := c.Param("processId")
+	level, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		panic(err)
+	}
+	err = core.SetProcessAppraisalLevel(processID, db.AppraisalLevel(level))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
 func getPrimaryDocument(c *gin.Context) {
-	processID := c.Query("processID")
+	processID := auth.ProcessID(c)
 	filename := c.Query("filename")
+	// Only serve known primary documents of the process that are located
+	// inside the message's store directory.
+	if _, ok := db.FindPrimaryDocumentData(c.Request.Context(), processID, filename); !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 	message, ok := db.FindMessage(c.Request.Context(), processID, db.MessageType0503)
 	if !ok {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 	path := filepath.Join(message.StoreDir, filename)
+	rel, err := filepath.Rel(message.StoreDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Disposition", "attachment; filename="+filename)
 	c.Header("Content-Type", "application/octet-stream")
@@ -630,6 +1266,13 @@ func archive0503Message(c *gin.Context) {
 		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("message can't be archived"))
 		return
 	}
+	if db.HasUnacknowledgedSchemaViolations(c.Request.Context(), processID) {
+		c.AbortWithError(
+			http.StatusConflict,
+			fmt.Errorf("schema violations need to be acknowledged by an administrator"),
+		)
+		return
+	}
 	archiveTarget := os.Getenv("ARCHIVE_TARGET")
 	var collection db.ArchiveCollection
 	if archiveTarget == "dimag" {
@@ -668,6 +1311,381 @@ func users(c *gin.Context) {
 	c.JSON(http.StatusOK, users)
 }
 
+// syncUsers refreshes the cached users of the LDAP directory and flags
+// agencies with orphaned user assignments.
+func syncUsers(c *gin.Context) {
+	present, left := auth.SyncDirectory()
+	routines.FlagOrphanedAssignments()
+	c.JSON(http.StatusOK, gin.H{
+		"present": present,
+		"left":    left,
+	})
+}
+
+// getOrphanedUsers returns the agencies with assigned users that are not
+// listed by any user directory anymore.
+func getOrphanedUsers(c *gin.Context) {
+	c.JSON(http.StatusOK, auth.FindOrphanedAssignments(c.Request.Context()))
+}
+
+// getAccessDenials returns a page of requests that were denied because the
+// user lacked the permission for the requested process or agency.
+//
+// The query parameter userId restricts the result to the given user.
+func getAccessDenials(c *gin.Context) {
+	offset, limit, err := paginationParams(c, 100)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	denials, total := db.FindAccessDenials(c.Request.Context(), c.Query("userId"), offset, limit)
+	if denials == nil {
+		denials = make([]db.AccessDenial, 0)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"denials": denials,
+	})
+}
+
+func getAuditLog(c *gin.Context) {
+	query, err := auditQueryParams(c)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	query.Offset, query.Limit, err = paginationParams(c, 100)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	entries, total := db.QueryAuditEntries(c.Request.Context(), query)
+	if entries == nil {
+		entries = make([]db.AuditEntry, 0)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"entries": entries,
+	})
+}
+
+// exportAuditLog responds with all audit entries matching the query
+// parameters as CSV file in chronological order.
+func exportAuditLog(c *gin.Context) {
+	query, err := auditQueryParams(c)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=audit-log.csv")
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{
+		"Zeitpunkt", "Nutzerkennung", "Nutzer", "Aktion", "Aussonderung",
+		"Abgebende Stelle", "Ziele", "IP-Adresse",
+	})
+	db.ForEachAuditEntry(c.Request.Context(), query, func(e db.AuditEntry) {
+		agencyID := ""
+		if e.AgencyID != nil {
+			agencyID = e.AgencyID.Hex()
+		}
+		w.Write([]string{
+			e.CreatedAt.Format(time.RFC3339), e.UserID, e.UserName, e.Action,
+			e.ProcessID, agencyID, strings.Join(e.TargetIDs, " "), e.ClientIP,
+		})
+	})
+	w.Flush()
+}
+
+// auditQueryParams reads filter options for the audit log from the query
+// parameters.
+func auditQueryParams(c *gin.Context) (q db.AuditQuery, err error) {
+	q.UserID = c.Query("userId")
+	q.Action = c.Query("action")
+	q.ProcessID = c.Query("processId")
+	if idParam := c.Query("agencyId"); idParam != "" {
+		id, err := primitive.ObjectIDFromHex(idParam)
+		if err != nil {
+			return q, err
+		}
+		q.AgencyID = &id
+	}
+	if fromParam := c.Query("from"); fromParam != "" {
+		q.From, err = time.ParseInLocation(time.DateOnly, fromParam, time.Local)
+		if err != nil {
+			return q, err
+		}
+	}
+	if toParam := c.Query("to"); toParam != "" {
+		to, err := time.ParseInLocation(time.DateOnly, toParam, time.Local)
+		if err != nil {
+			return q, err
+		}
+		q.To = to.AddDate(0, 0, 1)
+	}
+	return q, nil
+}
+
+func getAPITokens(c *gin.Context) {
+	tokens := db.FindAPITokens(c.Request.Context())
+	if tokens == nil {
+		tokens = make([]db.APIToken, 0)
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// createAPIToken creates an API token and responds with the token, which is
+// only shown once.
+func createAPIToken(c *gin.Context) {
+	var body struct {
+		Name        string               `json:"name"`
+		Permissions []auth.Permission    `json:"permissions"`
+		AgencyIDs   []primitive.ObjectID `json:"agencyIds"`
+		ExpiresAt   time.Time            `json:"expiresAt"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+	userID := c.MustGet("userId").(string)
+	token, apiToken, err := auth.CreateAPIToken(
+		body.Name, body.Permissions, body.AgencyIDs, body.ExpiresAt, auth.GetDisplayName(userID),
+	)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"token":    token,
+		"apiToken": apiToken,
+	})
+}
+
+func revokeAPIToken(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	userID := c.MustGet("userId").(string)
+	if !db.UpdateAPITokenRevoked(id, auth.GetDisplayName(userID)) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+func getLocalUsers(c *gin.Context) {
+	c.JSON(http.StatusOK, db.FindLocalUsers(c.Request.Context()))
+}
+
+// localUserBody is the request body to create or update a local user.
+type localUserBody struct {
+	Username    string      `json:"username"`
+	DisplayName string      `json:"displayName"`
+	Email       string      `json:"email"`
+	Roles       []auth.Role `json:"roles"`
+	// Password is the initial password. If empty, a random password is
+	// generated.
+	Password string `json:"password"`
+}
+
+// createLocalUser creates a local user and responds with the initial
+// password, which is only shown once.
+func createLocalUser(c *gin.Context) {
+	var body localUserBody
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+	user, password, err := auth.CreateLocalUser(
+		body.Username, body.DisplayName, body.Email, body.Roles, body.Password,
+	)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	audit.AddTargetIDs(c, user.ID.Hex())
+	c.JSON(http.StatusCreated, gin.H{
+		"user":     user,
+		"password": password,
+	})
+}
+
+func updateLocalUser(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	var body localUserBody
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+	err = auth.UpdateLocalUser(id, body.DisplayName, body.Email, body.Roles)
+	if err == auth.ErrLocalUserNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+func deleteLocalUser(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	userID := c.MustGet("userId").(string)
+	if !auth.DeleteLocalUser(id, auth.GetDisplayName(userID)) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// resetLocalUserPassword sets a new password for a local user and responds
+// with the password. If the request body doesn't contain a password, a random
+// password is generated.
+func resetLocalUserPassword(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	var body struct {
+		Password string `json:"password"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+	}
+	userID := c.MustGet("userId").(string)
+	password, err := auth.ResetLocalUserPassword(id, body.Password, auth.GetDisplayName(userID))
+	if err == auth.ErrLocalUserNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"password": password})
+}
+
+// changePassword changes the password of the requesting local user.
+func changePassword(c *gin.Context) {
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+	userID := c.MustGet("userId").(string)
+	err := auth.ChangeLocalUserPassword(userID, body.CurrentPassword, body.NewPassword)
+	switch err {
+	case nil:
+		c.Status(http.StatusAccepted)
+	case auth.ErrLocalUserNotFound:
+		c.AbortWithStatus(http.StatusNotFound)
+	case auth.ErrWrongPassword:
+		c.AbortWithStatus(http.StatusForbidden)
+	default:
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+	}
+}
+
+// getAbsences responds with the current and future absences of the user and of
+// the users the user is deputy for.
+func getAbsences(c *gin.Context) {
+	userID := c.MustGet("userId").(string)
+	c.JSON(http.StatusOK, db.FindAbsencesForUser(c.Request.Context(), userID, time.Now()))
+}
+
+// createAbsence saves an absence of the user in which the deputy receives the
+// notifications and can access the processes of the user's agencies.
+//
+// Administrators can create absences for other users with the field userId.
+func createAbsence(c *gin.Context) {
+	var body struct {
+		UserID   string    `json:"userId"`
+		DeputyID string    `json:"deputyId"`
+		From     time.Time `json:"from"`
+		To       time.Time `json:"to"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+	userID := c.MustGet("userId").(string)
+	if body.UserID != "" && body.UserID != userID {
+		if auth.PermissionScope(c, auth.PermissionAdministrate) != auth.ScopeAll {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		userID = body.UserID
+	}
+	absence, err := auth.CreateAbsence(userID, body.DeputyID, body.From, body.To)
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	audit.AddTargetIDs(c, absence.ID.Hex(), absence.UserID, absence.DeputyID)
+	c.JSON(http.StatusCreated, absence)
+}
+
+// deleteAbsence deletes an absence of the user. Administrators can delete
+// absences of all users.
+func deleteAbsence(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	userID := c.MustGet("userId").(string)
+	isAdmin := auth.PermissionScope(c, auth.PermissionAdministrate) == auth.ScopeAll
+	if err := auth.DeleteAbsence(id, userID, isAdmin); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getSessions responds with the active sessions of the user given by the
+// query parameter userId or of all users if it is omitted.
+func getSessions(c *gin.Context) {
+	sessions := auth.ListSessions(c.Request.Context(), c.Query("userId"))
+	c.JSON(http.StatusOK, sessions)
+}
+
+// revokeUserSessions revokes all active sessions of the user given by the
+// query parameter userId.
+func revokeUserSessions(c *gin.Context) {
+	targetUserID := c.Query("userId")
+	if targetUserID == "" {
+		c.AbortWithError(http.StatusUnprocessableEntity, fmt.Errorf("missing userId"))
+		return
+	}
+	userID := c.MustGet("userId").(string)
+	n := auth.RevokeUserSessions(targetUserID, auth.GetDisplayName(userID))
+	c.JSON(http.StatusAccepted, gin.H{"revoked": n})
+}
+
+func revokeSession(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	userID := c.MustGet("userId").(string)
+	if !auth.RevokeSession(id, auth.GetDisplayName(userID)) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
 func getUserInformation(c *gin.Context) {
 	userID := c.MustGet("userId").(string)
 	agencies := db.FindAgenciesForUser(c.Request.Context(), userID)
@@ -723,7 +1741,23 @@ func putAgency(c *gin.Context) {
 		c.AbortWithError(http.StatusUnprocessableEntity, err)
 		return
 	}
+	if !core.IsValidAppraisalLevel(agency.AppraisalLevel) {
+		c.AbortWithError(http.StatusUnprocessableEntity,
+			fmt.Errorf("invalid appraisal level: \"%s\"", agency.AppraisalLevel))
+		return
+	}
+	if agency.MaxMessageSizeMB < 0 || agency.MaxCompressionRatio < 0 {
+		c.AbortWithError(http.StatusUnprocessableEntity,
+			fmt.Errorf("invalid extraction limits: %d MB, %d:1", agency.MaxMessageSizeMB, agency.MaxCompressionRatio))
+		return
+	}
+	// Group users are set by the directory synchronization.
+	agency.GroupUsers = nil
 	id := db.InsertAgency(agency)
+	if len(agency.Groups) > 0 {
+		go routines.SyncUserDirectory()
+	}
+	audit.SetAgencyID(c, id)
 	c.JSON(http.StatusAccepted, gin.H{"id": id.Hex()})
 }
 
@@ -738,11 +1772,31 @@ func postAgency(c *gin.Context) {
 		c.AbortWithError(http.StatusUnprocessableEntity, err)
 		return
 	}
-	ok := db.ReplaceAgency(agency)
+	if !core.IsValidAppraisalLevel(agency.AppraisalLevel) {
+		c.AbortWithError(http.StatusUnprocessableEntity,
+			fmt.Errorf("invalid appraisal level: \"%s\"", agency.AppraisalLevel))
+		return
+	}
+	if agency.MaxMessageSizeMB < 0 || agency.MaxCompressionRatio < 0 {
+		c.AbortWithError(http.StatusUnprocessableEntity,
+			fmt.Errorf("invalid extraction limits: %d MB, %d:1", agency.MaxMessageSizeMB, agency.MaxCompressionRatio))
+		return
+	}
+	oldAgency, ok := db.FindAgency(c.Request.Context(), agency.ID)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	// Group users are set by the directory synchronization and not replaced.
+	ok = db.ReplaceAgency(agency)
 	if !ok {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	if !slices.Equal(agency.Groups, oldAgency.Groups) {
+		go routines.SyncUserDirectory()
+	}
+	audit.SetAgencyID(c, agency.ID)
 	c.Status(http.StatusAccepted)
 }
 
@@ -758,9 +1812,50 @@ func deleteAgency(c *gin.Context) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	audit.SetAgencyID(c, id)
 	c.Status(http.StatusAccepted)
 }
 
+// uploadMessage imports a message that is uploaded by an administrator for the
+// given agency, e.g., when the agency's transfer directory is unavailable.
+//
+// The message is expected as multipart form field "file". It is processed in
+// the background.
+func uploadMessage(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	}
+	agency, found := db.FindAgency(c.Request.Context(), id)
+	if !found {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	userID := c.MustGet("userId").(string)
+	err = core.UploadMessage(agency, fileHeader.Filename, file, userID)
+	switch err {
+	case nil:
+		c.Status(http.StatusAccepted)
+	case core.ErrInvalidMessageName:
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+	case core.ErrMessageExists:
+		c.AbortWithError(http.StatusConflict, err)
+	default:
+		c.AbortWithError(http.StatusBadRequest, err)
+	}
+}
+
 func getCollections(c *gin.Context) {
 	Collections := db.FindArchiveCollections(c)
 	c.JSON(http.StatusOK, Collections)
@@ -837,6 +1932,9 @@ func getTask(c *gin.Context) {
 		c.Status(http.StatusNotFound)
 		return
 	}
+	if task.ProcessID != "" && !auth.AuthorizeProcess(c, auth.PermissionReadProcesses, task.ProcessID) {
+		return
+	}
 	c.JSON(http.StatusOK, task)
 }
 
@@ -38,9 +38,7 @@ func ArchiveSubmission(
 	collection db.ArchiveCollection,
 	userID string,
 ) {
-	rootRecords := db.FindAllRootRecords(
-		context.Background(), process.ProcessID, db.MessageType0503,
-	)
+	rootRecords, _ := core.RootRecordsToArchive(process.ProcessID)
 	var items []db.TaskItem
 	m, _, _ := core.Packaging(process.ProcessID)
 	items = append(items, taskItemsForFiles(m, []string{}, rootRecords.Files)...)
@@ -173,6 +171,7 @@ type ArchiveHandler struct {
 	process       db.SubmissionProcess
 	message       db.Message
 	records       recordsMap
+	discardedIDs  []string
 	archiveTarget string
 	collection    db.ArchiveCollection
 	targetData    interface{}
@@ -199,9 +198,7 @@ func initArchiveHandler(t *db.Task) (tasks.ItemHandler, error) {
 			panic("failed to find archive collection  " + d.CollectionID.Hex())
 		}
 	}
-	rootRecords := db.FindAllRootRecords(
-		context.Background(), process.ProcessID, db.MessageType0503,
-	)
+	rootRecords, discardedIDs := core.RootRecordsToArchive(process.ProcessID)
 	archiveTarget := os.Getenv("ARCHIVE_TARGET")
 	var targetData interface{}
 	if archiveTarget == "dimag" {
@@ -215,6 +212,7 @@ func initArchiveHandler(t *db.Task) (tasks.ItemHandler, error) {
 		process:       process,
 		message:       message,
 		records:       makeRecordsMap(rootRecords),
+		discardedIDs:  discardedIDs,
 		collection:    collection,
 		archiveTarget: archiveTarget,
 		targetData:    targetData,
@@ -243,6 +241,7 @@ func (h *ArchiveHandler) HandleItem(
 			d.Title, h.process, d.RecordPath, h.records, h.collection.ID,
 		)
 	}
+	aip.ExcludedRecordIDs = h.discardedIDs
 	// Check whether we already created the archive package. This can be the
 	// case when we retry the task after an error.
 	if existingAIP, found := db.FindArchivePackage(
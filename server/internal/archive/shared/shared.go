@@ -27,7 +27,7 @@ func GenerateProtocol(process db.SubmissionProcess) []byte {
 }
 
 // PruneMessage removes all records from the message which are no part of the
-// archive package.
+// archive package as well as all documents excluded from the archive package.
 func PruneMessage(message db.Message, aip db.ArchivePackage) []byte {
 	recordIDs := make([]string, len(aip.RecordIDs))
 	copy(recordIDs, aip.RecordIDs)
@@ -49,6 +49,7 @@ func PruneMessage(message db.Message, aip db.ArchivePackage) []byte {
 	} else {
 		pruneSubRecords(file, recordIDs)
 	}
+	pruneDocuments(root, aip.ExcludedRecordIDs)
 	result, err := doc.WriteToBytes()
 	if err != nil {
 		panic(err)
@@ -92,6 +93,28 @@ func pruneRootRecords(root *etree.Element, keepIDs []string) *etree.Element {
 	return kept
 }
 
+// pruneDocuments removes all documents with the given IDs from the document.
+func pruneDocuments(root *etree.Element, excludeIDs []string) {
+	if len(excludeIDs) == 0 {
+		return
+	}
+	for _, recordEl := range root.FindElements("//Dokument") {
+		idEl := recordEl.FindElementPath(idPathXdomea)
+		if idEl == nil || !slices.Contains(excludeIDs, idEl.Text()) {
+			continue
+		}
+		parent := recordEl.Parent()
+		if parent.Tag == "Schriftgutobjekt" {
+			// Root-level documents are wrapped by a generic record element.
+			recordEl = parent
+			parent = parent.Parent()
+		}
+		if parent.RemoveChild(recordEl) == nil {
+			panic("failed to remove element")
+		}
+	}
+}
+
 // pruneSubRecords removes all sub records from the given file record that are
 // not in `keepIDs`.
 //
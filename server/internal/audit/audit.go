@@ -0,0 +1,128 @@
+// Package audit records who performed which action in an append-only audit
+// log.
+//
+// Actions of users are recorded by the middleware `Log`, which is added to the
+// routes of the actions. Actions that x-man performs automatically are
+// recorded with `Record` and the user ID `SystemUserID`.
+package audit
+
+import (
+	"context"
+	"lath/xman/internal/auth"
+	"lath/xman/internal/db"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Action is the type of a recorded action.
+type Action string
+
+const (
+	ActionDownloadPrimaryDocument Action = "download-primary-document"
+	ActionChangeAppraisal         Action = "change-appraisal"
+	ActionFinalizeAppraisal       Action = "finalize-appraisal"
+	ActionReopenAppraisal         Action = "reopen-appraisal"
+	ActionStartArchiving          Action = "start-archiving"
+	ActionDeleteProcess           Action = "delete-process"
+	ActionDeleteMessage           Action = "delete-message"
+	ActionReimportMessage         Action = "reimport-message"
+	ActionResolveError            Action = "resolve-error"
+	ActionCreateAgency            Action = "create-agency"
+	ActionUpdateAgency            Action = "update-agency"
+	ActionDeleteAgency            Action = "delete-agency"
+	ActionCreateLocalUser         Action = "create-local-user"
+	ActionUpdateLocalUser         Action = "update-local-user"
+	ActionDeleteLocalUser         Action = "delete-local-user"
+	ActionResetPassword           Action = "reset-password"
+	ActionCreateAbsence           Action = "create-absence"
+	ActionDeleteAbsence           Action = "delete-absence"
+)
+
+// SystemUserID is the user ID of actions that x-man performs automatically.
+const SystemUserID = "system"
+
+const (
+	processIDKey = "auditProcessId"
+	agencyIDKey  = "auditAgencyId"
+	targetIDsKey = "auditTargetIds"
+)
+
+// Record appends an entry for the given action to the audit log.
+func Record(e db.AuditEntry) {
+	e.CreatedAt = time.Now()
+	if e.UserID == SystemUserID {
+		e.UserName = "x-man"
+	} else {
+		e.UserName = auth.GetDisplayName(e.UserID)
+	}
+	db.InsertAuditEntry(e)
+}
+
+// Log records the given action after the request was handled successfully.
+//
+// The process of the action is read from the path parameter processId or the
+// query parameter processId. Further path parameters and the query parameters
+// recordId and filename are recorded as target IDs. Handlers that read the
+// process or targets from the request body provide them with SetProcessID,
+// SetAgencyID and AddTargetIDs.
+func Log(action Action) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		e := db.AuditEntry{Action: string(action)}
+		// The agency is looked up before the request, since the action might
+		// delete the process.
+		if e.ProcessID = auth.ProcessID(c); e.ProcessID != "" {
+			e.AgencyID = processAgencyID(c.Request.Context(), e.ProcessID)
+		}
+		c.Next()
+		if c.IsAborted() || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		e.UserID = c.GetString("userId")
+		e.ClientIP = c.ClientIP()
+		if processID := c.GetString(processIDKey); processID != "" && e.ProcessID == "" {
+			e.ProcessID = processID
+			e.AgencyID = processAgencyID(context.Background(), processID)
+		}
+		if agencyID, ok := c.Get(agencyIDKey); ok {
+			e.AgencyID = agencyID.(*primitive.ObjectID)
+		}
+		for _, p := range c.Params {
+			if p.Key != "processId" {
+				e.TargetIDs = append(e.TargetIDs, p.Value)
+			}
+		}
+		for _, key := range []string{"recordId", "filename"} {
+			if value := c.Query(key); value != "" {
+				e.TargetIDs = append(e.TargetIDs, value)
+			}
+		}
+		e.TargetIDs = append(e.TargetIDs, c.GetStringSlice(targetIDsKey)...)
+		Record(e)
+	}
+}
+
+// SetProcessID sets the process of the action recorded by Log.
+func SetProcessID(c *gin.Context, processID string) {
+	c.Set(processIDKey, processID)
+}
+
+// SetAgencyID sets the agency of the action recorded by Log.
+func SetAgencyID(c *gin.Context, agencyID primitive.ObjectID) {
+	c.Set(agencyIDKey, &agencyID)
+}
+
+// AddTargetIDs adds target IDs to the action recorded by Log.
+func AddTargetIDs(c *gin.Context, targetIDs ...string) {
+	c.Set(targetIDsKey, append(c.GetStringSlice(targetIDsKey), targetIDs...))
+}
+
+func processAgencyID(ctx context.Context, processID string) *primitive.ObjectID {
+	process, ok := db.FindProcess(ctx, processID)
+	if !ok {
+		return nil
+	}
+	return &process.Agency.ID
+}
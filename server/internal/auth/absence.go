@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lath/xman/internal/db"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrAbsenceNotFound = errors.New("absence not found")
+
+// CreateAbsence saves an absence of the given user in which the given deputy
+// represents the user.
+func CreateAbsence(userID, deputyID string, from, to time.Time) (db.Absence, error) {
+	if deputyID == userID {
+		return db.Absence{}, fmt.Errorf("users can't be their own deputy")
+	}
+	if !slices.ContainsFunc(ListUsers(), func(u userEntry) bool { return u.ID == deputyID }) {
+		return db.Absence{}, fmt.Errorf("unknown deputy: %s", deputyID)
+	}
+	if !to.After(from) {
+		return db.Absence{}, fmt.Errorf("absence must end after it starts")
+	}
+	if !to.After(time.Now()) {
+		return db.Absence{}, fmt.Errorf("absence must end in the future")
+	}
+	if db.HasOverlappingAbsence(context.Background(), userID, from, to) {
+		return db.Absence{}, fmt.Errorf("absence overlaps with another absence")
+	}
+	a := db.Absence{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		DeputyID:  deputyID,
+		From:      from,
+		To:        to,
+		CreatedAt: time.Now(),
+	}
+	db.InsertAbsence(a)
+	return a, nil
+}
+
+// DeleteAbsence deletes the given absence of the given user.
+//
+// Administrators can delete absences of all users.
+func DeleteAbsence(id primitive.ObjectID, userID string, isAdmin bool) error {
+	a, ok := db.FindAbsence(context.Background(), id)
+	if !ok || a.UserID != userID && !isAdmin {
+		return ErrAbsenceNotFound
+	}
+	db.DeleteAbsence(id)
+	return nil
+}
+
+// RepresentedUsers returns the users that are currently absent and
+// represented by the given user.
+func RepresentedUsers(ctx context.Context, deputyID string) []string {
+	var userIDs []string
+	for _, a := range db.FindActiveAbsencesForDeputy(ctx, deputyID, time.Now()) {
+		userIDs = append(userIDs, a.UserID)
+	}
+	return userIDs
+}
+
+// NotificationRecipients returns the users that should be notified instead of
+// the given users. Currently absent users are replaced by their deputies. If
+// a deputy is absent as well, the deputy's deputy is notified.
+func NotificationRecipients(ctx context.Context, userIDs []string) []string {
+	now := time.Now()
+	return notificationRecipients(userIDs, func(userID string) (string, bool) {
+		a, ok := db.FindActiveAbsence(ctx, userID, now)
+		return a.DeputyID, ok
+	})
+}
+
+// notificationRecipients replaces the given users by their deputies, which are
+// returned by the given function for absent users.
+//
+// Chains of absences are followed until a user is not absent. If the chain
+// leads back to a user of the chain, the last user before the cycle is
+// notified.
+func notificationRecipients(userIDs []string, deputy func(userID string) (string, bool)) []string {
+	var recipients []string
+	for _, userID := range userIDs {
+		visited := []string{userID}
+		for {
+			deputyID, ok := deputy(userID)
+			if !ok || slices.Contains(visited, deputyID) {
+				break
+			}
+			userID = deputyID
+			visited = append(visited, userID)
+		}
+		if !slices.Contains(recipients, userID) {
+			recipients = append(recipients, userID)
+		}
+	}
+	return recipients
+}
+
+// ActingUserName returns the display name of the given user for the process
+// history of the given agency.
+//
+// If the user is not assigned to the agency but acts as deputy of an assigned
+// user, the name of the represented user is added.
+func ActingUserName(ctx context.Context, userID string, agency db.Agency) string {
+	name := GetDisplayName(userID)
+	if agency.IsAssigned(userID) {
+		return name
+	}
+	for _, representedID := range RepresentedUsers(ctx, userID) {
+		if agency.IsAssigned(representedID) {
+			return fmt.Sprintf("%s (in Vertretung für %s)", name, GetDisplayName(representedID))
+		}
+	}
+	return name
+}
+
+// AssignedAgencyUsers returns the given user together with the users that are
+// currently represented by the given user. The user can access the agencies
+// of all of these users.
+func AssignedAgencyUsers(ctx context.Context, userID string) []string {
+	return append([]string{userID}, RepresentedUsers(ctx, userID)...)
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNotificationRecipients(t *testing.T) {
+	tests := []struct {
+		name     string
+		users    []string
+		deputies map[string]string
+		want     []string
+	}{
+		{"no users", nil, nil, nil},
+		{"present users", []string{"a", "b"}, nil, []string{"a", "b"}},
+		{"absent user", []string{"a", "b"}, map[string]string{"a": "c"}, []string{"c", "b"}},
+		{"deputy is assigned", []string{"a", "b"}, map[string]string{"a": "b"}, []string{"b"}},
+		{"shared deputy", []string{"a", "b"}, map[string]string{"a": "c", "b": "c"}, []string{"c"}},
+		{"chain", []string{"a"}, map[string]string{"a": "b", "b": "c"}, []string{"c"}},
+		{"cycle", []string{"a"}, map[string]string{"a": "b", "b": "a"}, []string{"b"}},
+		{"cycle after chain", []string{"a"}, map[string]string{"a": "b", "b": "c", "c": "b"}, []string{"c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := notificationRecipients(tt.users, func(userID string) (string, bool) {
+				deputyID, ok := tt.deputies[userID]
+				return deputyID, ok
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("notificationRecipients(%v) = %v, want %v", tt.users, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"lath/xman/internal/db"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// authorizedProcessIDKey is the context key of the process ID checked by
+// ProcessAccessRequired.
+const authorizedProcessIDKey = "authorizedProcessId"
+
+// ProcessAccessRequired requires the user to be granted the given permission
+// for the agency of the process addressed by the request or else stops the
+// request.
+//
+// The process ID is read from the path parameter processId or the query
+// parameters processId and processID. Requests that contain different process
+// IDs are rejected. The checked process ID is stored in the context and has to
+// be read by handlers with ProcessID. Requests without process ID pass, so
+// handlers that read the process ID from the request body have to call
+// AuthorizeProcess.
+func ProcessAccessRequired(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		processID, err := requestProcessID(c)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if processID != "" {
+			c.Set(authorizedProcessIDKey, processID)
+			AuthorizeProcess(c, permission, processID)
+		}
+	}
+}
+
+// ProcessID returns the process ID addressed by the request.
+//
+// For routes protected by ProcessAccessRequired, this is the process ID that
+// was checked.
+func ProcessID(c *gin.Context) string {
+	if processID, ok := c.Get(authorizedProcessIDKey); ok {
+		return processID.(string)
+	}
+	processID, _ := requestProcessID(c)
+	return processID
+}
+
+// requestProcessID reads the process ID from the path parameter processId or
+// the query parameters processId and processID.
+//
+// It returns an error if the request contains more than one different process
+// ID.
+func requestProcessID(c *gin.Context) (string, error) {
+	ids := []string{c.Param("processId")}
+	ids = append(ids, c.QueryArray("processId")...)
+	// Used by the primary document download.
+	ids = append(ids, c.QueryArray("processID")...)
+	processID := ""
+	for _, id := range ids {
+		if id == "" {
+			continue
+		} else if processID != "" && id != processID {
+			return "", errors.New("ambiguous process ID")
+		}
+		processID = id
+	}
+	return processID, nil
+}
+
+// AuthorizeProcess checks whether the user of the request is granted the given
+// permission for the agency of the given process.
+//
+// If not, it records the denied attempt, stops the request and returns false.
+// Requests for processes that don't exist are allowed, so the handler can
+// respond accordingly.
+func AuthorizeProcess(c *gin.Context, permission Permission, processID string) bool {
+	if PermissionScope(c, permission) == ScopeAll {
+		return true
+	}
+	process, found := db.FindProcess(c.Request.Context(), processID)
+	if !found || HasAgencyPermission(c, permission, process.Agency.ID) {
+		return true
+	}
+	denyAccess(c, permission, processID, &process.Agency.ID)
+	return false
+}
+
+// AuthorizeAgency checks whether the user of the request is granted the given
+// permission for the given agency.
+//
+// If not, it records the denied attempt, stops the request and returns false.
+// A nil agency is only allowed for users that are granted the permission for
+// all agencies.
+func AuthorizeAgency(c *gin.Context, permission Permission, agencyID *primitive.ObjectID) bool {
+	if PermissionScope(c, permission) == ScopeAll {
+		return true
+	}
+	if agencyID != nil && HasAgencyPermission(c, permission, *agencyID) {
+		return true
+	}
+	denyAccess(c, permission, "", agencyID)
+	return false
+}
+
+// denyAccess records a denied request and stops it.
+func denyAccess(c *gin.Context, permission Permission, processID string, agencyID *primitive.ObjectID) {
+	userID := c.GetString("userId")
+	log.Printf("Denied %s %s for user %s\n", c.Request.Method, c.Request.URL.Path, userID)
+	db.InsertAccessDenial(db.AccessDenial{
+		CreatedAt:  time.Now(),
+		UserID:     userID,
+		Permission: string(permission),
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		ProcessID:  processID,
+		AgencyID:   agencyID,
+		ClientIP:   c.ClientIP(),
+	})
+	c.AbortWithStatus(http.StatusForbidden)
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProcessAccessRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name          string
+		url           string
+		wantStatus    int
+		wantProcessID string
+	}{
+		{"no process ID", "/api/primary-document?filename=a.pdf", http.StatusOK, ""},
+		{"query processId", "/api/primary-document?processId=p1", http.StatusOK, "p1"},
+		{"query processID", "/api/primary-document?processID=p1&filename=a.pdf", http.StatusOK, "p1"},
+		{"path parameter", "/api/process/p1", http.StatusOK, "p1"},
+		{"same ID twice", "/api/primary-document?processId=p1&processID=p1", http.StatusOK, "p1"},
+		{"different query parameters", "/api/primary-document?processId=p1&processID=p2", http.StatusBadRequest, ""},
+		{"repeated query parameter", "/api/primary-document?processID=p1&processID=p2", http.StatusBadRequest, ""},
+		{"path and query parameter", "/api/process/p1?processId=p2", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotProcessID string
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				// Administrators pass the agency check without database access.
+				c.Set("permissions", newPermissions([]Role{RoleAdmin}))
+			})
+			router.Use(ProcessAccessRequired(PermissionReadProcesses))
+			handler := func(c *gin.Context) {
+				gotProcessID = ProcessID(c)
+				c.Status(http.StatusOK)
+			}
+			router.GET("/api/primary-document", handler)
+			router.GET("/api/process/:processId", handler)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if gotProcessID != tt.wantProcessID {
+				t.Errorf("ProcessID() = %q, want %q", gotProcessID, tt.wantProcessID)
+			}
+		})
+	}
+}
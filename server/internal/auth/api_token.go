@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"lath/xman/internal/db"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// apiTokenPrefix distinguishes API tokens from login tokens.
+	apiTokenPrefix = "xman_"
+	// apiTokenUserIDPrefix is the prefix of the user ID under which requests
+	// with an API token are made, followed by the ID of the token.
+	apiTokenUserIDPrefix = "api-token:"
+	// lastUsedInterval is the minimum time between two updates of the last
+	// usage of a token, so not every request causes a database write.
+	lastUsedInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIToken = errors.New("invalid API token")
+	// apiTokenPermissions are the permissions that can be granted to API
+	// tokens.
+	apiTokenPermissions = []Permission{
+		PermissionReadProcesses,
+		PermissionEditProcesses,
+		PermissionResolveErrors,
+		PermissionAdministrate,
+	}
+)
+
+// CreateAPIToken creates a new API token and returns the token together with
+// its database entry.
+//
+// The token is not stored and can't be retrieved later.
+func CreateAPIToken(
+	name string,
+	permissions []Permission,
+	agencyIDs []primitive.ObjectID,
+	expiresAt time.Time,
+	createdBy string,
+) (string, db.APIToken, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", db.APIToken{}, fmt.Errorf("missing name")
+	}
+	if len(permissions) == 0 {
+		return "", db.APIToken{}, fmt.Errorf("missing permissions")
+	}
+	permissionNames := make([]string, len(permissions))
+	for i, p := range permissions {
+		if !slices.Contains(apiTokenPermissions, p) {
+			return "", db.APIToken{}, fmt.Errorf("invalid permission: %s", p)
+		}
+		permissionNames[i] = string(p)
+	}
+	if !expiresAt.After(time.Now()) {
+		return "", db.APIToken{}, fmt.Errorf("expiry must be in the future")
+	}
+	if len(agencyIDs) > 0 && slices.Contains(permissions, PermissionAdministrate) {
+		return "", db.APIToken{}, fmt.Errorf("permission %s can't be limited to agencies", PermissionAdministrate)
+	}
+	for _, id := range agencyIDs {
+		if _, ok := db.FindAgency(context.Background(), id); !ok {
+			return "", db.APIToken{}, fmt.Errorf("agency not found: %s", id.Hex())
+		}
+	}
+	if agencyIDs == nil {
+		agencyIDs = make([]primitive.ObjectID, 0)
+	}
+	token := apiTokenPrefix + randomString()
+	t := db.APIToken{
+		ID:          primitive.NewObjectID(),
+		Name:        strings.TrimSpace(name),
+		Hash:        hashToken(token),
+		Prefix:      token[:len(apiTokenPrefix)+6],
+		Permissions: permissionNames,
+		AgencyIDs:   agencyIDs,
+		CreatedAt:   time.Now(),
+		CreatedBy:   createdBy,
+		ExpiresAt:   expiresAt,
+	}
+	db.InsertAPIToken(t)
+	return token, t, nil
+}
+
+// validateAPIToken checks the given API token and returns the permissions
+// granted to it.
+func validateAPIToken(token string) (validationResult, error) {
+	t, ok := db.FindAPITokenByHash(context.Background(), hashToken(token))
+	if !ok || t.RevokedAt != nil || time.Now().After(t.ExpiresAt) {
+		return validationResult{}, ErrInvalidAPIToken
+	}
+	now := time.Now()
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > lastUsedInterval {
+		db.UpdateAPITokenLastUsed(t.ID, now)
+	}
+	perms := permissions{Agencies: t.AgencyIDs}
+	for _, p := range t.Permissions {
+		perms.Permissions = append(perms.Permissions, Permission(p))
+	}
+	return validationResult{
+		UserID:      apiTokenUserIDPrefix + t.ID.Hex(),
+		Permissions: &perms,
+	}, nil
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// apiTokenDirectory provides display names for requests made with API tokens,
+// e.g., when an API token resolves a processing error.
+type apiTokenDirectory struct{}
+
+func (apiTokenDirectory) listUsers() []userEntry {
+	return nil
+}
+
+func (apiTokenDirectory) findUser(userID string) (userEntry, bool) {
+	hexID, ok := strings.CutPrefix(userID, apiTokenUserIDPrefix)
+	if !ok {
+		return userEntry{}, false
+	}
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return userEntry{}, false
+	}
+	t, ok := db.FindAPIToken(context.Background(), id)
+	if !ok {
+		return userEntry{}, false
+	}
+	return userEntry{ID: userID, DisplayName: "API-Token " + t.Name}, true
+}
+
+// findUserWithPermissions returns API tokens without roles, since no
+// sessions are started for them.
+func (d apiTokenDirectory) findUserWithPermissions(userID string) (userEntry, bool) {
+	return d.findUser(userID)
+}
+
+func (apiTokenDirectory) getMailAddress(userID string) (string, error) {
+	return "", fmt.Errorf("API token %s has no email address", userID)
+}
@@ -3,7 +3,9 @@ package auth
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,8 +17,10 @@ func AuthRequired() gin.HandlerFunc {
 		result, err := validateToken(tokenString)
 		if err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
 		c.Set("userId", result.UserID)
+		c.Set("permissions", result.Permissions)
 	}
 }
 
@@ -26,50 +30,80 @@ func AuthRequiredQueryParam() gin.HandlerFunc {
 		result, err := validateToken(token)
 		if err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
 		c.Set("userId", result.UserID)
+		c.Set("permissions", result.Permissions)
 	}
 }
 
-// AdminRequired requires a valid bearer token with permission "admin" or else stops the request.
+// AdminRequired requires a valid bearer token of a system administrator or else
+// stops the request.
 func AdminRequired() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		tokenString := extractToken(c)
-		result, err := validateToken(tokenString)
-		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
-		} else if !result.Permissions.Admin {
-			c.AbortWithStatus(http.StatusForbidden)
-		}
-		c.Set("userId", result.UserID)
-	}
+	return PermissionRequired(PermissionAdministrate)
 }
 
-// Login reads LDAP username and password from HTTP basic auth and responds with
-// a bearer token and information about permissions and the user.
+// Login reads username and password from HTTP basic auth and responds with an
+// access token, a refresh token and information about permissions and the
+// user.
+//
+// If a local user with the given username exists, the password is checked
+// against the local user. Otherwise, the user is authorized via LDAP.
 func Login(c *gin.Context) {
+	if !ldapEnabled() && !LocalUsersEnabled() {
+		c.String(http.StatusNotFound, "Login with username and password is not configured")
+		return
+	}
 	user, pass, ok := c.Request.BasicAuth()
 	if !ok {
 		c.String(http.StatusUnauthorized, "Basic Authentication must be provided")
 		return
 	}
-	authorization := authorizeUser(user, pass)
+	// Failed logins are throttled per username and per client, so passwords
+	// can't be guessed and password hashing can't be used to exhaust the
+	// server.
+	userKey := "user:" + normalizeUsername(user)
+	clientKey := "client:" + c.ClientIP()
+	if wait := failedLogins.retryAfter(userKey, clientKey); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())+1))
+		c.String(http.StatusTooManyRequests, "Too many failed logins")
+		return
+	}
+	var authorization authorizationResult
+	loginMethod := "ldap"
+	isLocalUser := false
+	if LocalUsersEnabled() {
+		authorization, isLocalUser = authorizeLocalUser(user, pass)
+		if isLocalUser {
+			loginMethod = "local"
+		}
+	}
+	if !isLocalUser && ldapEnabled() {
+		authorization = authorizeUser(user, pass)
+	}
 	switch authorization.Predicate {
 	case INVALID:
+		failedLogins.fail(userKey, clientKey)
 		c.String(http.StatusUnauthorized, "Invalid credentials")
 		return
 	case DENIED:
 		c.String(http.StatusForbidden, "Forbidden")
 		return
 	case GRANTED:
-		// continue
+		failedLogins.reset(userKey)
 	default:
 		panic(fmt.Sprintf("unknown authorization predicate: %v", authorization.Predicate))
 	}
-	token := createToken(*authorization.UserEntry)
+	startSession(c, *authorization.UserEntry, loginMethod)
+}
+
+// LoginMethods responds with the enabled login methods, so the login page can
+// offer them before the user is authenticated.
+func LoginMethods(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"token": token,
-		"user":  authorization.UserEntry,
+		"ldap":  ldapEnabled(),
+		"oidc":  OIDCEnabled(),
+		"local": LocalUsersEnabled(),
 	})
 }
 
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"lath/xman/internal/db"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userDirectory provides information about the users that can log into x-man.
+type userDirectory interface {
+	// listUsers lists all users with basic access rights and their
+	// permissions.
+	listUsers() []userEntry
+	// findUser returns the user with the given ID. Permissions are omitted.
+	findUser(userID string) (userEntry, bool)
+	// findUserWithPermissions returns the user with the given ID and their
+	// current permissions. Users without access rights have no roles.
+	findUserWithPermissions(userID string) (userEntry, bool)
+	// getMailAddress returns the e-mail address of the user with the given ID.
+	getMailAddress(userID string) (string, error)
+}
+
+// directories are the enabled user directories in order of precedence.
+var directories []userDirectory
+
+// TestConnection initializes the configured user directories and connects to
+// the LDAP server if configured.
+//
+// API tokens and local users precede LDAP and OpenID Connect users, since
+// their IDs are not valid IDs of these directories.
+func TestConnection() {
+	directories = []userDirectory{apiTokenDirectory{}}
+	if LocalUsersEnabled() {
+		initLocalUsers()
+		directories = append(directories, localDirectory{})
+	}
+	if ldapEnabled() {
+		log.Println("Testing connection to LDAP server...")
+		testLDAPConnection()
+		log.Println("Connection to LDAP server successful")
+		directories = append(directories, ldapDirectory{})
+		if !db.HasLDAPUsers(context.Background()) {
+			log.Println("Reading users from LDAP server...")
+			present, _ := syncLDAPUsers()
+			log.Printf("Read %d users from LDAP server\n", present)
+		}
+	}
+	if OIDCEnabled() {
+		log.Println("Testing connection to OpenID Connect provider...")
+		if _, err := getOIDCProvider(); err != nil {
+			log.Fatal("Failed to connect to OpenID Connect provider: ", err)
+		}
+		log.Println("Connection to OpenID Connect provider successful")
+		directories = append(directories, oidcDirectory{})
+	}
+	if !ldapEnabled() && !OIDCEnabled() && !LocalUsersEnabled() {
+		log.Fatal("no login method configured, set LDAP_URL, OIDC_ISSUER or LOCAL_USERS_ENABLED")
+	}
+}
+
+// ListUsers lists all users with basic access rights and their permissions.
+//
+// Users that are known to multiple directories are listed once with the
+// information of the first directory.
+func ListUsers() []userEntry {
+	userEntries := make([]userEntry, 0)
+	seen := make(map[string]bool)
+	for _, d := range directories {
+		for _, u := range d.listUsers() {
+			if !seen[u.ID] {
+				seen[u.ID] = true
+				userEntries = append(userEntries, u)
+			}
+		}
+	}
+	return userEntries
+}
+
+// GetDisplayName returns the display name of the given user or an empty
+// string if the user is unknown.
+func GetDisplayName(userID string) string {
+	for _, d := range directories {
+		if u, ok := d.findUser(userID); ok {
+			return u.DisplayName
+		}
+	}
+	return ""
+}
+
+// refreshUser returns the given user with their current permissions.
+func refreshUser(userID string) (userEntry, bool) {
+	for _, d := range directoriesForUser(userID) {
+		if u, ok := d.findUserWithPermissions(userID); ok {
+			return u, true
+		}
+	}
+	return userEntry{}, false
+}
+
+// directoriesForUser returns the enabled directories that can own the given
+// user ID in order of precedence.
+//
+// API tokens and local users are recognized by the prefix of their IDs. Users
+// that logged in with OpenID Connect are only looked up in LDAP if they are
+// also known as LDAP users, so refreshing their sessions doesn't query the
+// LDAP server.
+func directoriesForUser(userID string) []userDirectory {
+	ctx := context.Background()
+	prefixed := strings.HasPrefix(userID, apiTokenUserIDPrefix) ||
+		strings.HasPrefix(userID, localUserIDPrefix)
+	var result []userDirectory
+	for _, d := range directories {
+		switch d.(type) {
+		case apiTokenDirectory:
+			if !strings.HasPrefix(userID, apiTokenUserIDPrefix) {
+				continue
+			}
+		case localDirectory:
+			if !strings.HasPrefix(userID, localUserIDPrefix) {
+				continue
+			}
+		case ldapDirectory:
+			if prefixed {
+				continue
+			}
+			if _, ok := db.FindOIDCUser(ctx, userID); ok {
+				if _, ok := db.FindLDAPUser(ctx, userID); !ok {
+					continue
+				}
+			}
+		case oidcDirectory:
+			if prefixed {
+				continue
+			}
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// GetMailAddress returns the e-mail address of the given user.
+func GetMailAddress(userID string) (string, error) {
+	for _, d := range directories {
+		if _, ok := d.findUser(userID); ok {
+			return d.getMailAddress(userID)
+		}
+	}
+	return "", fmt.Errorf("failed to find user with ID %s", userID)
+}
+
+// SyncDirectory refreshes the cached users of the LDAP directory and the
+// members of the groups of agencies. It returns the number of present users
+// and the number of users that left the LDAP directory since the last
+// synchronization.
+func SyncDirectory() (present int, left int) {
+	if ldapEnabled() {
+		present, left = syncLDAPUsers()
+	}
+	syncAgencyGroups()
+	return present, left
+}
+
+// agencyGroups returns the groups referenced by any agency.
+func agencyGroups() []string {
+	var groups []string
+	for _, a := range db.FindAgencies(context.Background()) {
+		for _, g := range a.Groups {
+			if !slices.Contains(groups, g) {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups
+}
+
+// directorySyncMutex serializes the synchronization of the LDAP user cache and
+// of the group users of agencies. Concurrent runs could mark users as left
+// that were just synchronized by the other run or overwrite group users with
+// stale data.
+var directorySyncMutex sync.Mutex
+
+// syncAgencyGroups updates the group users of all agencies with the cached
+// group memberships of LDAP and OpenID Connect users.
+//
+// Only users with access rights are considered. Members of OpenID Connect
+// groups are known after their first login.
+func syncAgencyGroups() {
+	directorySyncMutex.Lock()
+	defer directorySyncMutex.Unlock()
+	ctx := context.Background()
+	memberships := make(map[string][]string)
+	if ldapEnabled() {
+		for _, u := range db.FindLDAPUsers(ctx) {
+			memberships[u.UserID] = u.Groups
+		}
+	}
+	if OIDCEnabled() {
+		for _, u := range db.FindOIDCUsers(ctx) {
+			memberships[u.UserID] = append(memberships[u.UserID], u.Groups...)
+		}
+	}
+	for _, a := range db.FindAgencies(ctx) {
+		groupUsers := make([]string, 0)
+		for userID, groups := range memberships {
+			if slices.ContainsFunc(a.Groups, func(g string) bool { return slices.Contains(groups, g) }) {
+				groupUsers = append(groupUsers, userID)
+			}
+		}
+		slices.Sort(groupUsers)
+		if !slices.Equal(groupUsers, a.GroupUsers) {
+			db.UpdateAgencyGroupUsers(a.ID, groupUsers)
+		}
+	}
+}
+
+// OrphanedUser is a user assigned to an agency that is not listed by any user
+// directory anymore, e.g., because the user left the LDAP directory.
+type OrphanedUser struct {
+	ID string `json:"id"`
+	// DisplayName is empty if the user is unknown.
+	DisplayName string `json:"displayName"`
+	// LeftAt is the time the user was first missing in the LDAP directory or
+	// nil if the time is unknown.
+	LeftAt *time.Time `json:"leftAt"`
+}
+
+// OrphanedAssignments are the orphaned users assigned to an agency.
+type OrphanedAssignments struct {
+	Agency db.Agency      `json:"agency"`
+	Users  []OrphanedUser `json:"users"`
+}
+
+// FindOrphanedAssignments returns all agencies with assigned users that are not
+// listed by any user directory.
+func FindOrphanedAssignments(ctx context.Context) []OrphanedAssignments {
+	listed := make(map[string]bool)
+	for _, u := range ListUsers() {
+		listed[u.ID] = true
+	}
+	result := make([]OrphanedAssignments, 0)
+	for _, agency := range db.FindAgencies(ctx) {
+		var orphaned []OrphanedUser
+		for _, userID := range agency.Users {
+			if listed[userID] {
+				continue
+			}
+			u := OrphanedUser{ID: userID, DisplayName: GetDisplayName(userID)}
+			if ldapEnabled() {
+				if cached, ok := db.FindLDAPUser(ctx, userID); ok {
+					u.LeftAt = cached.LeftAt
+				}
+			}
+			orphaned = append(orphaned, u)
+		}
+		if len(orphaned) > 0 {
+			result = append(result, OrphanedAssignments{Agency: agency, Users: orphaned})
+		}
+	}
+	return result
+}
+
+// syncAgencyGroupsForUser adds the given user to or removes the user from the
+// group users of the agencies whose groups changed for the user.
+//
+// It is called on login with OpenID Connect, so only the agencies of the
+// logged-in user are updated.
+func syncAgencyGroupsForUser(userID string) {
+	directorySyncMutex.Lock()
+	defer directorySyncMutex.Unlock()
+	ctx := context.Background()
+	var groups []string
+	if u, ok := db.FindOIDCUser(ctx, userID); ok {
+		groups = append(groups, u.Groups...)
+	}
+	if ldapEnabled() {
+		if u, ok := db.FindLDAPUser(ctx, userID); ok && u.LeftAt == nil {
+			groups = append(groups, u.Groups...)
+		}
+	}
+	for _, a := range db.FindAgencies(ctx) {
+		isMember := slices.ContainsFunc(a.Groups, func(g string) bool { return slices.Contains(groups, g) })
+		if isMember == slices.Contains(a.GroupUsers, userID) {
+			continue
+		}
+		groupUsers := slices.DeleteFunc(slices.Clone(a.GroupUsers), func(u string) bool { return u == userID })
+		if isMember {
+			groupUsers = append(groupUsers, userID)
+		}
+		slices.Sort(groupUsers)
+		db.UpdateAgencyGroupUsers(a.ID, groupUsers)
+	}
+}
@@ -6,8 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"lath/xman/internal/db"
-	"os"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -33,20 +32,20 @@ func Init() {
 	} else {
 		tokenSecret = s.TokenSecret
 	}
+	loadRevokedSessions()
 }
 
-func createToken(user userEntry) string {
+// createToken creates a short-lived access token for the given user and
+// session.
+func createToken(user userEntry, sessionID string) string {
 	if len(tokenSecret) == 0 {
 		panic("token secret not initialized")
 	}
-	tokenLifetime, err := strconv.Atoi(os.Getenv("LOGIN_TOKEN_LIFETIME_DAYS"))
-	if err != nil {
-		panic(err)
-	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"userId": user.ID,
+		"sid":    sessionID,
 		"perms":  user.Permissions,
-		"exp":    time.Now().Add(time.Hour * 24 * time.Duration(tokenLifetime)).Unix(),
+		"exp":    time.Now().Add(accessTokenLifetime()).Unix(),
 	})
 	// Sign and get the complete encoded token as a string using the secret
 	tokenString, err := token.SignedString(tokenSecret)
@@ -57,6 +56,9 @@ func createToken(user userEntry) string {
 }
 
 func validateToken(tokenString string) (validationResult, error) {
+	if strings.HasPrefix(tokenString, apiTokenPrefix) {
+		return validateAPIToken(tokenString)
+	}
 	if len(tokenSecret) == 0 {
 		panic("token secret not initialized")
 	}
@@ -78,10 +80,18 @@ func validateToken(tokenString string) (validationResult, error) {
 	if !ok {
 		return validationResult{}, errors.New("failed to cast user id")
 	}
+	// Tokens issued before sessions were introduced can't be revoked and are
+	// rejected.
+	sessionID, ok := claims["sid"].(string)
+	if !ok {
+		return validationResult{}, errors.New("failed to cast session id")
+	}
+	if isSessionRevoked(sessionID) {
+		return validationResult{}, ErrSessionRevoked
+	}
 	jsonString, _ := json.Marshal(claims["perms"])
 	perms := permissions{}
 	json.Unmarshal(jsonString, &perms)
-
 	return validationResult{
 		UserID:      userID,
 		Permissions: &perms,
@@ -1,15 +1,20 @@
 package auth
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
+	"lath/xman/internal/db"
+	"log"
 	"os"
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type ldapConfiguration struct {
@@ -27,18 +32,25 @@ type authorizationPredicate int
 const (
 	// INVALID indicates an invalid username / password combination
 	INVALID authorizationPredicate = iota // 0
-	// DENIED denies any user access based on missing LDAP group membership
+	// DENIED denies any user access based on missing group membership
 	DENIED // 1
 	// GRANTED grants basic access rights
 	GRANTED // 2
 )
 
-// permissions grants additional permissions based an LDAP group memberships
+// permissions grants additional permissions based on group memberships
 type permissions struct {
-	Admin bool `json:"admin,omitempty"`
+	// Admin is true for system administrators.
+	Admin bool   `json:"admin,omitempty"`
+	Roles []Role `json:"roles"`
+	// Permissions are granted in addition to the roles, e.g., for API tokens.
+	Permissions []Permission `json:"permissions,omitempty"`
+	// Agencies restrict the permissions to the given agencies instead of the
+	// agencies the user is assigned to.
+	Agencies []primitive.ObjectID `json:"agencies,omitempty"`
 }
 
-// userEntry represents an LDAP user
+// userEntry represents a user of a user directory
 type userEntry struct {
 	// ID is a persistent, unique user ID to reliably identify the user by the system
 	ID string `json:"id"`
@@ -57,40 +69,88 @@ type authorizationResult struct {
 }
 
 var LDAP_BASE_DN string
-var LDAP_ACCESS_GROUP_CN string
-var LDAP_ADMIN_GROUP_CN string
-var LDAP_ACCESS_GROUP_DN string
-var LDAP_ADMIN_GROUP_DN string
 
-func TestConnection() {
+// ldapRoleGroups are the configured groups for each role with the common name
+// of the group as Group.
+var ldapRoleGroups []roleGroup
+
+// ldapGroupDNs maps the common names of the configured groups to their DNs.
+var ldapGroupDNs map[string]string
+
+// ldapEnabled returns true if an LDAP server is configured.
+func ldapEnabled() bool {
+	return os.Getenv("LDAP_URL") != ""
+}
+
+// testLDAPConnection connects to the LDAP server and resolves the configured
+// groups.
+func testLDAPConnection() {
 	l := connectReadonly()
 	defer l.Close()
 	LDAP_BASE_DN = os.Getenv("LDAP_BASE_DN")
-	LDAP_ACCESS_GROUP_CN = os.Getenv("LDAP_ACCESS_GROUP")
-	LDAP_ADMIN_GROUP_CN = os.Getenv("LDAP_ADMIN_GROUP")
-	LDAP_ACCESS_GROUP_DN = getGroupDN(l, LDAP_ACCESS_GROUP_CN)
-	LDAP_ADMIN_GROUP_DN = getGroupDN(l, LDAP_ADMIN_GROUP_CN)
+	ldapRoleGroups = getRoleGroups("LDAP")
+	ldapGroupDNs = make(map[string]string)
+	for _, g := range ldapRoleGroups {
+		ldapGroupDNs[g.Group] = getGroupDN(l, g.Group)
+	}
 }
 
-func GetDisplayName(userID string) string {
-	l := connectReadonly()
-	defer l.Close()
-	user := getLdapUserEntry(l, getIDFilter(userID))
-	return getDisplayName(user)
+// ldapDirectory is the user directory of the LDAP server.
+type ldapDirectory struct{}
+
+// findUser returns the cached user, including users that left the directory,
+// so their names can still be shown.
+func (ldapDirectory) findUser(userID string) (userEntry, bool) {
+	u, ok := db.FindLDAPUser(context.Background(), userID)
+	if !ok {
+		return userEntry{}, false
+	}
+	return userEntry{ID: u.UserID, DisplayName: u.DisplayName}, true
 }
 
-func GetMailAddress(userID string) (string, error) {
+// findUserWithPermissions queries the LDAP server, so changed group
+// memberships take effect without waiting for the next synchronization. The
+// cache is updated with the result.
+func (ldapDirectory) findUserWithPermissions(userID string) (userEntry, bool) {
+	filter, err := getIDFilter(userID)
+	if err != nil {
+		// Not an ID of this directory.
+		return userEntry{}, false
+	}
 	l := connectReadonly()
 	defer l.Close()
-	user := getLdapUserEntryWithAttributes(l, getIDFilter(userID), []string{"dn", "mail"})
+	user := getLdapUserEntry(l, filter)
 	if user == nil {
+		return userEntry{}, false
+	}
+	var roles []Role
+	for _, g := range ldapRoleGroups {
+		if isGroupMember(l, user.DN, g.Group) {
+			roles = append(roles, g.Role)
+		}
+	}
+	if len(roles) > 0 {
+		cacheLDAPUser(user, roles)
+	}
+	return userEntry{
+		ID:          userID,
+		DisplayName: getDisplayName(user),
+		Permissions: newPermissions(roles),
+	}, true
+}
+
+func (ldapDirectory) getMailAddress(userID string) (string, error) {
+	u, ok := db.FindLDAPUser(context.Background(), userID)
+	if !ok {
 		return "", fmt.Errorf("ldap: failed to find user with ID %s", userID)
 	}
-	mail := user.GetAttributeValue("mail")
-	if mail == "" {
+	if u.LeftAt != nil {
+		return "", fmt.Errorf("ldap: user with ID %s left the directory", userID)
+	}
+	if u.Email == "" {
 		return "", fmt.Errorf("ldap: user with ID %s has no email address", userID)
 	}
-	return mail, nil
+	return u.Email, nil
 }
 
 // authorizeUser connects to the LDAP server and checks the given users
@@ -119,21 +179,21 @@ func authorizeUser(username string, password string) authorizationResult {
 		panic(err)
 	}
 	// Check basic access rights
-	isAccessMember := isGroupMember(l, user.DN, LDAP_ACCESS_GROUP_CN)
-	isAdminMember := isGroupMember(l, user.DN, LDAP_ADMIN_GROUP_CN)
-	if !isAccessMember && !isAdminMember {
+	var roles []Role
+	for _, g := range ldapRoleGroups {
+		if isGroupMember(l, user.DN, g.Group) {
+			roles = append(roles, g.Role)
+		}
+	}
+	if len(roles) == 0 {
 		return authorizationResult{Predicate: DENIED}
 	}
+	cacheLDAPUser(user, roles)
 	// At this point, the user has proven basic access authorization (i.e.: Predicate: GRANTED)
-	//
-	// Check further permissions
-	permissions := permissions{
-		Admin: isAdminMember,
-	}
 	userEntry := userEntry{
 		ID:          getID(user),
 		DisplayName: getDisplayName(user),
-		Permissions: &permissions,
+		Permissions: newPermissions(roles),
 	}
 	return authorizationResult{
 		Predicate: GRANTED,
@@ -141,48 +201,101 @@ func authorizeUser(username string, password string) authorizationResult {
 	}
 }
 
-// ListUsers lists all users with basic access rights and their permissions
-func ListUsers() []userEntry {
+// listUsers lists all cached users with basic access rights and their
+// permissions.
+func (ldapDirectory) listUsers() []userEntry {
+	userEntries := make([]userEntry, 0)
+	for _, u := range db.FindLDAPUsers(context.Background()) {
+		userEntries = append(userEntries, userEntry{
+			ID:          u.UserID,
+			DisplayName: u.DisplayName,
+			Permissions: newPermissions(rolesFromNames(u.Roles)),
+		})
+	}
+	return userEntries
+}
+
+// syncLDAPUsers reads all members of the configured groups from the LDAP
+// server and saves them to the user cache. Cached users that are not members
+// anymore are marked as left.
+//
+// Returns the number of present users and the number of users that were
+// marked as left.
+func syncLDAPUsers() (present int, left int) {
+	directorySyncMutex.Lock()
+	defer directorySyncMutex.Unlock()
 	l := connectReadonly()
 	defer l.Close()
-	accessUser, err := getGroupMembersRecursive(l, LDAP_ACCESS_GROUP_DN, make(map[string]bool))
-	if err != nil {
-		panic(err)
+	startedAt := time.Now()
+	users := make(map[string]db.LDAPUser)
+	var ids []string
+	for _, g := range ldapRoleGroups {
+		members, err := getGroupMembersRecursive(l, ldapGroupDNs[g.Group], make(map[string]bool))
+		if err != nil {
+			panic(err)
+		}
+		for _, entry := range members {
+			id := getID(entry)
+			u, ok := users[id]
+			if !ok {
+				ids = append(ids, id)
+				u = newLDAPUser(entry, nil)
+			}
+			if !slices.Contains(u.Roles, string(g.Role)) {
+				u.Roles = append(u.Roles, string(g.Role))
+			}
+			users[id] = u
+		}
 	}
-	adminUser, err := getGroupMembersRecursive(l, LDAP_ADMIN_GROUP_DN, make(map[string]bool))
-	if err != nil {
-		panic(err)
+	// An empty result is more likely a misconfiguration than all users
+	// leaving, so we keep the cache.
+	if len(users) == 0 {
+		panic("ldap: no members found in the configured groups")
 	}
-	var filteredAccessUser []*ldap.Entry
-	for _, userDN := range accessUser {
-		if !slices.Contains(adminUser, userDN) {
-			filteredAccessUser = append(filteredAccessUser, userDN)
+	for _, group := range agencyGroups() {
+		groupDN, ok := findGroupDN(l, group)
+		if !ok {
+			log.Printf("ldap: group %s of an agency not found\n", group)
+			continue
 		}
-	}
-	userEntries := make([]userEntry, 0)
-	for _, user := range filteredAccessUser {
-		permissions := permissions{
-			Admin: false,
+		members, err := getGroupMembersRecursive(l, groupDN, make(map[string]bool))
+		if err != nil {
+			panic(err)
 		}
-		userEntry := userEntry{
-			ID:          getID(user),
-			DisplayName: getDisplayName(user),
-			Permissions: &permissions,
+		for _, entry := range members {
+			id := getID(entry)
+			// Members without access rights can't be assigned.
+			if u, ok := users[id]; ok && !slices.Contains(u.Groups, group) {
+				u.Groups = append(u.Groups, group)
+				users[id] = u
+			}
 		}
-		userEntries = append(userEntries, userEntry)
 	}
-	for _, user := range adminUser {
-		permissions := permissions{
-			Admin: true,
-		}
-		userEntry := userEntry{
-			ID:          getID(user),
-			DisplayName: getDisplayName(user),
-			Permissions: &permissions,
-		}
-		userEntries = append(userEntries, userEntry)
+	for _, id := range ids {
+		db.UpsertLDAPUser(users[id])
+	}
+	return len(users), db.UpdateLDAPUsersLeft(startedAt)
+}
+
+// cacheLDAPUser saves the given user with their current roles to the user
+// cache. The groups of agencies are kept until the next synchronization.
+func cacheLDAPUser(user *ldap.Entry, roles []Role) {
+	u := newLDAPUser(user, roles)
+	if cached, ok := db.FindLDAPUser(context.Background(), u.UserID); ok {
+		u.Groups = cached.Groups
+	}
+	db.UpsertLDAPUser(u)
+}
+
+func newLDAPUser(user *ldap.Entry, roles []Role) db.LDAPUser {
+	config := getConfiguration()
+	return db.LDAPUser{
+		UserID:      getID(user),
+		DisplayName: getDisplayName(user),
+		Email:       user.GetAttributeValue(config.EmailAttribute),
+		Roles:       roleNames(roles),
+		SyncedAt:    time.Now(),
 	}
-	return userEntries
 }
 
 func getGroupMembersRecursive(l *ldap.Conn, groupDN string, visited map[string]bool) ([]*ldap.Entry, error) {
@@ -209,7 +322,7 @@ func getGroupMembersRecursive(l *ldap.Conn, groupDN string, visited map[string]b
 	for _, dn := range members {
 		// Lookup this DN to see if it's a user or a group
 		entry, err := getEntryByDN(l, dn)
-		if err != nil {
+		if err != nil || entry == nil {
 			continue
 		}
 		if isGroup(entry) {
@@ -224,7 +337,9 @@ func getGroupMembersRecursive(l *ldap.Conn, groupDN string, visited map[string]b
 
 func getEntryByDN(l *ldap.Conn, dn string) (*ldap.Entry, error) {
 	config := getConfiguration()
-	attributes := []string{config.IDAttribute, config.DisplayNameAttribute, "objectClass"}
+	attributes := []string{
+		config.IDAttribute, config.DisplayNameAttribute, config.EmailAttribute, "objectClass",
+	}
 	searchRequest := ldap.NewSearchRequest(
 		dn,
 		ldap.ScopeBaseObject,
@@ -313,7 +428,9 @@ func isGroupMember(l *ldap.Conn, userDn string, groupCn string) bool {
 // Returns nil when the user could not be found.
 func getLdapUserEntry(l *ldap.Conn, filter string) *ldap.Entry {
 	config := getConfiguration()
-	attributes := []string{"dn", config.IDAttribute, config.DisplayNameAttribute}
+	attributes := []string{
+		"dn", config.IDAttribute, config.DisplayNameAttribute, config.EmailAttribute,
+	}
 	return getLdapUserEntryWithAttributes(l, filter, attributes)
 }
 
@@ -343,6 +460,27 @@ func getLdapUserEntryWithAttributes(l *ldap.Conn, filter string, attributes []st
 	return sr.Entries[0]
 }
 
+// findGroupDN returns the DN of the group with the given common name.
+//
+// ok is false if there is no unique group with the given name.
+func findGroupDN(l *ldap.Conn, groupCn string) (dn string, ok bool) {
+	searchRequest := ldap.NewSearchRequest(
+		LDAP_BASE_DN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(&(objectClass=group)(cn=%s))", ldap.EscapeFilter(groupCn)),
+		[]string{},
+		nil,
+	)
+	searchResult, err := l.Search(searchRequest)
+	if err != nil {
+		panic(err)
+	}
+	if len(searchResult.Entries) != 1 {
+		return "", false
+	}
+	return searchResult.Entries[0].DN, true
+}
+
 func getGroupDN(l *ldap.Conn, groupCn string) string {
 	searchRequest := ldap.NewSearchRequest(
 		LDAP_BASE_DN,
@@ -402,16 +540,18 @@ func getID(entry *ldap.Entry) string {
 
 // getIDFilter returns a filter string to be used in an LDAP search for an
 // ID obtained with GetID.
-func getIDFilter(id string) string {
+//
+// It returns an error if the ID is not a valid ID of the LDAP directory.
+func getIDFilter(id string) (string, error) {
 	config := getConfiguration()
 	if config.IDAttributeIsBinary {
 		bytes, err := base64.StdEncoding.DecodeString(id)
 		if err != nil {
-			panic(err)
+			return "", fmt.Errorf("invalid LDAP user ID %q: %w", id, err)
 		}
-		return getFilter(config.IDAttribute, string(bytes))
+		return getFilter(config.IDAttribute, string(bytes)), nil
 	} else {
-		return getFilter(config.IDAttribute, id)
+		return getFilter(config.IDAttribute, id), nil
 	}
 }
 
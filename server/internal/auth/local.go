@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"lath/xman/internal/db"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// localUserIDPrefix is the prefix of the user IDs of local users, followed
+	// by the ID of the database entry.
+	localUserIDPrefix = "local:"
+	minPasswordLength = 12
+	// Argon2id parameters as recommended by OWASP.
+	argon2Time       = 3
+	argon2Memory     = 64 * 1024
+	argon2Threads    = 2
+	argon2KeyLength  = 32
+	argon2SaltLength = 16
+	// maxConcurrentPasswordHashes limits the number of Argon2id computations
+	// running at the same time, since each one needs argon2Memory KiB of
+	// memory.
+	maxConcurrentPasswordHashes = 4
+)
+
+var (
+	ErrLocalUserNotFound = errors.New("local user not found")
+	ErrWrongPassword     = errors.New("wrong password")
+	// dummyPasswordHash is verified for unknown usernames, so the response
+	// time doesn't reveal whether a username exists.
+	dummyPasswordHash = sync.OnceValue(func() string {
+		return hashPassword(randomString())
+	})
+	passwordHashSlots = make(chan struct{}, maxConcurrentPasswordHashes)
+)
+
+// LocalUsersEnabled returns true if user accounts managed in x-man are
+// enabled.
+func LocalUsersEnabled() bool {
+	return os.Getenv("LOCAL_USERS_ENABLED") == "true"
+}
+
+// LocalUsersRequired stops the request if local users are not enabled.
+func LocalUsersRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !LocalUsersEnabled() {
+			c.String(http.StatusNotFound, "Local users are not enabled")
+			c.Abort()
+		}
+	}
+}
+
+// initLocalUsers creates the initial administrator configured with
+// LOCAL_ADMIN_USERNAME and LOCAL_ADMIN_PASSWORD if the user doesn't exist.
+func initLocalUsers() {
+	username := os.Getenv("LOCAL_ADMIN_USERNAME")
+	password := os.Getenv("LOCAL_ADMIN_PASSWORD")
+	if username == "" || password == "" {
+		return
+	}
+	if _, ok := db.FindLocalUserByUsername(context.Background(), normalizeUsername(username)); ok {
+		return
+	}
+	_, _, err := CreateLocalUser(username, username, "", []Role{RoleAdmin}, password)
+	if err != nil {
+		log.Fatal("Failed to create local administrator: ", err)
+	}
+	log.Printf("Created local administrator %s\n", username)
+}
+
+// authorizeLocalUser checks the given credentials of a local user.
+//
+// ok is false if there is no local user with the given username.
+func authorizeLocalUser(username string, password string) (result authorizationResult, ok bool) {
+	u, ok := db.FindLocalUserByUsername(context.Background(), normalizeUsername(username))
+	if !ok {
+		verifyPassword(password, dummyPasswordHash())
+		return authorizationResult{Predicate: INVALID}, false
+	}
+	if !verifyPassword(password, u.PasswordHash) {
+		return authorizationResult{Predicate: INVALID}, true
+	}
+	if len(u.Roles) == 0 {
+		return authorizationResult{Predicate: DENIED}, true
+	}
+	entry := localUserEntry(u)
+	return authorizationResult{Predicate: GRANTED, UserEntry: &entry}, true
+}
+
+// CreateLocalUser creates a new local user and returns the user together with
+// the initial password.
+//
+// If password is empty, a random password is generated.
+func CreateLocalUser(
+	username, displayName, email string,
+	roles []Role,
+	password string,
+) (db.LocalUser, string, error) {
+	username = normalizeUsername(username)
+	if username == "" {
+		return db.LocalUser{}, "", fmt.Errorf("missing username")
+	}
+	if strings.ContainsAny(username, " :") {
+		return db.LocalUser{}, "", fmt.Errorf("invalid username: %s", username)
+	}
+	if strings.TrimSpace(displayName) == "" {
+		return db.LocalUser{}, "", fmt.Errorf("missing display name")
+	}
+	if err := validateRoles(roles); err != nil {
+		return db.LocalUser{}, "", err
+	}
+	if password == "" {
+		password = randomString()
+	} else if err := validatePassword(password); err != nil {
+		return db.LocalUser{}, "", err
+	}
+	now := time.Now()
+	u := db.LocalUser{
+		ID:                primitive.NewObjectID(),
+		Username:          username,
+		DisplayName:       strings.TrimSpace(displayName),
+		Email:             strings.TrimSpace(email),
+		Roles:             roleNames(roles),
+		PasswordHash:      hashPassword(password),
+		PasswordChangedAt: now,
+		CreatedAt:         now,
+	}
+	if !db.InsertLocalUser(u) {
+		return db.LocalUser{}, "", fmt.Errorf("username already exists: %s", username)
+	}
+	return u, password, nil
+}
+
+// UpdateLocalUser sets display name, e-mail address and roles of the given
+// local user.
+//
+// Changed roles take effect when the access tokens of the user are renewed.
+// Users without roles can't log in anymore.
+func UpdateLocalUser(id primitive.ObjectID, displayName, email string, roles []Role) error {
+	if strings.TrimSpace(displayName) == "" {
+		return fmt.Errorf("missing display name")
+	}
+	if err := validateRoles(roles); err != nil {
+		return err
+	}
+	if !db.UpdateLocalUser(id, strings.TrimSpace(displayName), strings.TrimSpace(email), roleNames(roles)) {
+		return ErrLocalUserNotFound
+	}
+	return nil
+}
+
+// ResetLocalUserPassword sets a new password for the given local user and ends
+// all sessions of the user. The new password is returned.
+//
+// If password is empty, a random password is generated.
+func ResetLocalUserPassword(id primitive.ObjectID, password string, resetBy string) (string, error) {
+	if password == "" {
+		password = randomString()
+	} else if err := validatePassword(password); err != nil {
+		return "", err
+	}
+	if !db.UpdateLocalUserPassword(id, hashPassword(password)) {
+		return "", ErrLocalUserNotFound
+	}
+	RevokeUserSessions(localUserIDPrefix+id.Hex(), resetBy)
+	return password, nil
+}
+
+// ChangeLocalUserPassword changes the password of the given user after
+// checking the current password.
+func ChangeLocalUserPassword(userID string, currentPassword, newPassword string) error {
+	id, ok := parseLocalUserID(userID)
+	if !ok {
+		return ErrLocalUserNotFound
+	}
+	u, ok := db.FindLocalUser(context.Background(), id)
+	if !ok {
+		return ErrLocalUserNotFound
+	}
+	if !verifyPassword(currentPassword, u.PasswordHash) {
+		return ErrWrongPassword
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+	db.UpdateLocalUserPassword(id, hashPassword(newPassword))
+	return nil
+}
+
+// DeleteLocalUser deletes the given local user and ends all sessions of the
+// user.
+func DeleteLocalUser(id primitive.ObjectID, deletedBy string) bool {
+	if !db.DeleteLocalUser(id) {
+		return false
+	}
+	RevokeUserSessions(localUserIDPrefix+id.Hex(), deletedBy)
+	return true
+}
+
+func validateRoles(roles []Role) error {
+	for _, r := range roles {
+		if _, ok := rolePermissions[r]; !ok {
+			return fmt.Errorf("invalid role: %s", r)
+		}
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return fmt.Errorf("password must have at least %d characters", minPasswordLength)
+	}
+	return nil
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// hashPassword returns the Argon2id hash of the given password in PHC string
+// format.
+func hashPassword(password string) string {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	key := argon2Key(password, salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+// verifyPassword checks the given password against a hash created by
+// hashPassword. The parameters are read from the hash, so hashes created with
+// other parameters stay valid.
+func verifyPassword(password string, encodedHash string) bool {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	expectedKey, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	key := argon2Key(password, salt, iterations, memory, threads, uint32(len(expectedKey)))
+	return subtle.ConstantTimeCompare(key, expectedKey) == 1
+}
+
+// argon2Key derives the Argon2id key of the given password. It waits while
+// maxConcurrentPasswordHashes computations are running.
+func argon2Key(password string, salt []byte, time, memory uint32, threads uint8, keyLength uint32) []byte {
+	passwordHashSlots <- struct{}{}
+	defer func() { <-passwordHashSlots }()
+	return argon2.IDKey([]byte(password), salt, time, memory, threads, keyLength)
+}
+
+func parseLocalUserID(userID string) (primitive.ObjectID, bool) {
+	hexID, ok := strings.CutPrefix(userID, localUserIDPrefix)
+	if !ok {
+		return primitive.ObjectID{}, false
+	}
+	id, err := primitive.ObjectIDFromHex(hexID)
+	return id, err == nil
+}
+
+func localUserEntry(u db.LocalUser) userEntry {
+	return userEntry{
+		ID:          localUserIDPrefix + u.ID.Hex(),
+		DisplayName: u.DisplayName,
+		Permissions: newPermissions(rolesFromNames(u.Roles)),
+	}
+}
+
+// localDirectory is the user directory of the local users.
+type localDirectory struct{}
+
+func (localDirectory) listUsers() []userEntry {
+	var userEntries []userEntry
+	for _, u := range db.FindLocalUsers(context.Background()) {
+		if len(u.Roles) > 0 {
+			userEntries = append(userEntries, localUserEntry(u))
+		}
+	}
+	return userEntries
+}
+
+func (localDirectory) findUser(userID string) (userEntry, bool) {
+	u, ok := findLocalUser(userID)
+	if !ok {
+		return userEntry{}, false
+	}
+	return userEntry{ID: userID, DisplayName: u.DisplayName}, true
+}
+
+func (localDirectory) findUserWithPermissions(userID string) (userEntry, bool) {
+	u, ok := findLocalUser(userID)
+	if !ok {
+		return userEntry{}, false
+	}
+	return localUserEntry(u), true
+}
+
+func (localDirectory) getMailAddress(userID string) (string, error) {
+	u, ok := findLocalUser(userID)
+	if !ok {
+		return "", fmt.Errorf("local: failed to find user with ID %s", userID)
+	}
+	if u.Email == "" {
+		return "", fmt.Errorf("local: user with ID %s has no email address", userID)
+	}
+	return u.Email, nil
+}
+
+func findLocalUser(userID string) (db.LocalUser, bool) {
+	id, ok := parseLocalUserID(userID)
+	if !ok {
+		return db.LocalUser{}, false
+	}
+	return db.FindLocalUser(context.Background(), id)
+}
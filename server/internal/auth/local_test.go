@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash := hashPassword("correct horse")
+	if !strings.HasPrefix(hash, fmt.Sprintf("$argon2id$v=%d$", argon2.Version)) {
+		t.Errorf("hashPassword() = %s, want Argon2id PHC string", hash)
+	}
+	if other := hashPassword("correct horse"); other == hash {
+		t.Errorf("hashPassword() returned the same hash twice, want random salt")
+	}
+	// Hashes with other parameters are verified with the parameters of the
+	// hash.
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("correct horse"), salt, 1, 1024, 1, 16)
+	otherParameters := fmt.Sprintf(
+		"$argon2id$v=%d$m=1024,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+	parts := strings.Split(hash, "$")
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		want     bool
+	}{
+		{"correct password", "correct horse", hash, true},
+		{"wrong password", "battery staple", hash, false},
+		{"empty password", "", hash, false},
+		{"different case", "Correct horse", hash, false},
+		{"other parameters", "correct horse", otherParameters, true},
+		{"other parameters with wrong password", "battery staple", otherParameters, false},
+		{"empty hash", "correct horse", "", false},
+		{"other algorithm", "correct horse", strings.Replace(hash, "argon2id", "argon2i", 1), false},
+		{"other version", "correct horse", strings.Replace(hash, parts[2], "v=16", 1), false},
+		{"invalid parameters", "correct horse", strings.Replace(hash, parts[3], "m=x", 1), false},
+		{"invalid salt", "correct horse", strings.Replace(hash, parts[4], "!", 1), false},
+		{"invalid key", "correct horse", strings.Replace(hash, parts[5], "!", 1), false},
+		{"missing part", "correct horse", strings.TrimSuffix(hash, "$"+parts[5]), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyPassword(tt.password, tt.hash); got != tt.want {
+				t.Errorf("verifyPassword(%q, %q) = %v, want %v", tt.password, tt.hash, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// freeLoginAttempts is the number of failed logins after which further
+	// logins are delayed.
+	freeLoginAttempts = 5
+	// maxLoginDelay is the maximum time a client has to wait before the next
+	// login attempt.
+	maxLoginDelay = 5 * time.Minute
+	// loginFailureExpiry is the time after which failed logins are forgotten.
+	loginFailureExpiry = 15 * time.Minute
+	// maxLoginThrottleEntries limits the memory used for tracking failed
+	// logins.
+	maxLoginThrottleEntries = 10000
+)
+
+// loginThrottle delays logins after repeated failures, e.g., per username and
+// per client address.
+type loginThrottle struct {
+	mu       sync.Mutex
+	failures map[string]loginFailures
+	now      func() time.Time
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+var failedLogins = newLoginThrottle()
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{
+		failures: make(map[string]loginFailures),
+		now:      time.Now,
+	}
+}
+
+// retryAfter returns the time to wait before the next login attempt for the
+// given keys is allowed. It returns 0 if logins are allowed.
+func (t *loginThrottle) retryAfter(keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var wait time.Duration
+	for _, k := range keys {
+		f, ok := t.failures[k]
+		if !ok {
+			continue
+		}
+		if now.Sub(f.last) > loginFailureExpiry {
+			delete(t.failures, k)
+			continue
+		}
+		wait = max(wait, f.last.Add(loginDelay(f.count)).Sub(now))
+	}
+	return wait
+}
+
+// fail records a failed login for the given keys.
+func (t *loginThrottle) fail(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if len(t.failures)+len(keys) > maxLoginThrottleEntries {
+		for k, f := range t.failures {
+			if now.Sub(f.last) > loginFailureExpiry {
+				delete(t.failures, k)
+			}
+		}
+		// Drop arbitrary entries if there are still too many.
+		for k := range t.failures {
+			if len(t.failures)+len(keys) <= maxLoginThrottleEntries {
+				break
+			}
+			delete(t.failures, k)
+		}
+	}
+	for _, k := range keys {
+		f := t.failures[k]
+		f.count++
+		f.last = now
+		t.failures[k] = f
+	}
+}
+
+// reset forgets the failed logins for the given keys.
+func (t *loginThrottle) reset(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		delete(t.failures, k)
+	}
+}
+
+// loginDelay returns the time to wait after the given number of failed
+// logins. The delay doubles with every failure after freeLoginAttempts.
+func loginDelay(failures int) time.Duration {
+	if failures < freeLoginAttempts {
+		return 0
+	}
+	return min(time.Second<<min(failures-freeLoginAttempts, 16), maxLoginDelay)
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{freeLoginAttempts - 1, 0},
+		{freeLoginAttempts, time.Second},
+		{freeLoginAttempts + 1, 2 * time.Second},
+		{freeLoginAttempts + 3, 8 * time.Second},
+		{freeLoginAttempts + 20, maxLoginDelay},
+		{1000, maxLoginDelay},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	throttle := newLoginThrottle()
+	throttle.now = func() time.Time { return now }
+	for range freeLoginAttempts - 1 {
+		throttle.fail("user:a", "client:1")
+	}
+	if wait := throttle.retryAfter("user:a", "client:1"); wait != 0 {
+		t.Fatalf("retryAfter() = %v before reaching the free attempts, want 0", wait)
+	}
+	throttle.fail("user:a", "client:1")
+	if wait := throttle.retryAfter("user:b", "client:1"); wait != time.Second {
+		t.Errorf("retryAfter() for the same client = %v, want 1s", wait)
+	}
+	if wait := throttle.retryAfter("user:a", "client:2"); wait != time.Second {
+		t.Errorf("retryAfter() for the same user = %v, want 1s", wait)
+	}
+	if wait := throttle.retryAfter("user:b", "client:2"); wait != 0 {
+		t.Errorf("retryAfter() for other user and client = %v, want 0", wait)
+	}
+	now = now.Add(time.Second)
+	if wait := throttle.retryAfter("user:a", "client:1"); wait != 0 {
+		t.Errorf("retryAfter() after the delay = %v, want 0", wait)
+	}
+	throttle.reset("user:a")
+	if wait := throttle.retryAfter("user:a"); wait != 0 {
+		t.Errorf("retryAfter() after reset = %v, want 0", wait)
+	}
+	throttle.fail("client:1")
+	now = now.Add(loginFailureExpiry + time.Second)
+	if wait := throttle.retryAfter("client:1"); wait != 0 {
+		t.Errorf("retryAfter() after expiry = %v, want 0", wait)
+	}
+	if _, ok := throttle.failures["client:1"]; ok {
+		t.Error("expired entry was not removed")
+	}
+}
+
+func TestLoginThrottleLimitsEntries(t *testing.T) {
+	throttle := newLoginThrottle()
+	for i := range maxLoginThrottleEntries + 100 {
+		throttle.fail("client:" + strconv.Itoa(i))
+	}
+	if n := len(throttle.failures); n > maxLoginThrottleEntries {
+		t.Errorf("len(failures) = %d, want at most %d", n, maxLoginThrottleEntries)
+	}
+}
@@ -0,0 +1,638 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lath/xman/internal/db"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcLoginTimeout is the time within which a started login has to be
+// completed.
+const oidcLoginTimeout = 10 * time.Minute
+
+// oidcLoginCookie is the name of the cookie that holds the pending login
+// between StartOIDCLogin and FinishOIDCLogin.
+//
+// Starting a login doesn't require authentication, so the server keeps no
+// state for pending logins. Instead, the cookie holds a signed token with the
+// state, code verifier and nonce of the login.
+const oidcLoginCookie = "xman_oidc_login"
+
+// oidcSigningMethods are the accepted signature algorithms of ID tokens.
+var oidcSigningMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+}
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcConfiguration is the configuration of the OpenID Connect login.
+type oidcConfiguration struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// AuthorizationURL overrides the authorization endpoint announced by the
+	// provider, e.g., if the provider is reachable by the server under a
+	// different host name than by the browser.
+	AuthorizationURL string
+	Scopes           string
+	UserIDClaim      string
+	GroupsClaim      string
+}
+
+// oidcProvider holds the provider metadata obtained via OpenID Connect
+// discovery.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcPendingLogin is a login that was started but not yet completed.
+type oidcPendingLogin struct {
+	State        string `json:"state"`
+	CodeVerifier string `json:"codeVerifier"`
+	Nonce        string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+var (
+	oidcMutex         sync.Mutex
+	oidcProviderCache *oidcProvider
+	oidcKeys          map[string]interface{}
+)
+
+// OIDCEnabled returns true if login via OpenID Connect is configured.
+func OIDCEnabled() bool {
+	return os.Getenv("OIDC_ISSUER") != ""
+}
+
+func getOIDCConfiguration() oidcConfiguration {
+	config := oidcConfiguration{
+		Issuer:           os.Getenv("OIDC_ISSUER"),
+		ClientID:         os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:     os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:      os.Getenv("OIDC_REDIRECT_URL"),
+		AuthorizationURL: os.Getenv("OIDC_AUTHORIZATION_URL"),
+		Scopes:           os.Getenv("OIDC_SCOPES"),
+		UserIDClaim:      os.Getenv("OIDC_USER_ID_CLAIM"),
+		GroupsClaim:      os.Getenv("OIDC_GROUPS_CLAIM"),
+	}
+	if config.RedirectURL == "" {
+		config.RedirectURL = strings.TrimSuffix(os.Getenv("ORIGIN"), "/") + "/login"
+	}
+	if config.Scopes == "" {
+		config.Scopes = "openid profile email"
+	}
+	if config.UserIDClaim == "" {
+		config.UserIDClaim = "sub"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	return config
+}
+
+// getOIDCProvider returns the provider metadata, fetching it on first use.
+func getOIDCProvider() (oidcProvider, error) {
+	oidcMutex.Lock()
+	defer oidcMutex.Unlock()
+	if oidcProviderCache != nil {
+		return *oidcProviderCache, nil
+	}
+	config := getOIDCConfiguration()
+	issuer := strings.TrimSuffix(config.Issuer, "/")
+	var p oidcProvider
+	if err := getJSON(issuer+"/.well-known/openid-configuration", &p); err != nil {
+		return p, err
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return p, fmt.Errorf("issuer mismatch: expected %s, provider announced %s", config.Issuer, p.Issuer)
+	}
+	oidcProviderCache = &p
+	return p, nil
+}
+
+// StartOIDCLogin responds with the URL of the OpenID Connect provider to which
+// the browser has to be redirected to log in.
+//
+// The provider redirects back to the configured redirect URL with the query
+// parameters code and state, which have to be passed to FinishOIDCLogin.
+func StartOIDCLogin(c *gin.Context) {
+	if !OIDCEnabled() {
+		c.String(http.StatusNotFound, "OpenID Connect login is not configured")
+		return
+	}
+	provider, err := getOIDCProvider()
+	if err != nil {
+		c.AbortWithError(http.StatusBadGateway, err)
+		return
+	}
+	config := getOIDCConfiguration()
+	state := randomString()
+	login := oidcPendingLogin{
+		State:        state,
+		CodeVerifier: randomString(),
+		Nonce:        randomString(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   oidcLoginCookie,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcLoginTimeout)),
+		},
+	}
+	setOIDCLoginCookie(c, login)
+	challenge := sha256.Sum256([]byte(login.CodeVerifier))
+	authorizationURL := provider.AuthorizationEndpoint
+	if config.AuthorizationURL != "" {
+		authorizationURL = config.AuthorizationURL
+	}
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		panic(err)
+	}
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", config.ClientID)
+	query.Set("redirect_uri", config.RedirectURL)
+	query.Set("scope", config.Scopes)
+	query.Set("state", state)
+	query.Set("nonce", login.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+	c.JSON(http.StatusOK, gin.H{"url": u.String()})
+}
+
+// FinishOIDCLogin exchanges the authorization code returned by the OpenID
+// Connect provider for an ID token and responds with an access token, a refresh
+// token and information about permissions and the user, like Login.
+func FinishOIDCLogin(c *gin.Context) {
+	if !OIDCEnabled() {
+		c.String(http.StatusNotFound, "OpenID Connect login is not configured")
+		return
+	}
+	var body struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+	login, err := readOIDCLoginCookie(c)
+	clearOIDCLoginCookie(c)
+	if err != nil || body.State == "" || body.State != login.State {
+		c.String(http.StatusBadRequest, "Unknown or expired login")
+		return
+	}
+	claims, err := exchangeAuthorizationCode(c.Request.Context(), body.Code, login)
+	if err != nil {
+		log.Printf("OpenID Connect login failed: %v\n", err)
+		c.String(http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+	authorization := authorizeOIDCUser(claims)
+	switch authorization.Predicate {
+	case INVALID:
+		c.String(http.StatusUnauthorized, "Invalid credentials")
+		return
+	case DENIED:
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	case GRANTED:
+		// continue
+	default:
+		panic(fmt.Sprintf("unknown authorization predicate: %v", authorization.Predicate))
+	}
+	startSession(c, *authorization.UserEntry, "oidc")
+}
+
+// setOIDCLoginCookie saves the given pending login in a signed, HTTP-only
+// cookie that is only sent to the OpenID Connect login endpoint.
+func setOIDCLoginCookie(c *gin.Context, login oidcPendingLogin) {
+	if len(tokenSecret) == 0 {
+		panic("token secret not initialized")
+	}
+	value, err := jwt.NewWithClaims(jwt.SigningMethodHS256, login).SignedString(tokenSecret)
+	if err != nil {
+		panic(err)
+	}
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLoginCookie, value, int(oidcLoginTimeout.Seconds()), "/api/login/oidc", "", secure, true)
+}
+
+// readOIDCLoginCookie returns the pending login saved in the cookie of the
+// request. It returns an error if the cookie is missing, invalid or expired.
+func readOIDCLoginCookie(c *gin.Context) (oidcPendingLogin, error) {
+	var login oidcPendingLogin
+	value, err := c.Cookie(oidcLoginCookie)
+	if err != nil {
+		return login, err
+	}
+	_, err = jwt.ParseWithClaims(value, &login, func(token *jwt.Token) (interface{}, error) {
+		return tokenSecret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithSubject(oidcLoginCookie),
+		jwt.WithExpirationRequired(),
+	)
+	return login, err
+}
+
+// clearOIDCLoginCookie removes the cookie of the pending login, so it can't be
+// used again.
+func clearOIDCLoginCookie(c *gin.Context) {
+	c.SetCookie(oidcLoginCookie, "", -1, "/api/login/oidc", "", false, true)
+}
+
+// exchangeAuthorizationCode redeems the authorization code at the token
+// endpoint and returns the claims of the verified ID token.
+//
+// If the ID token doesn't include the groups claim, the claims are
+// complemented with the response of the userinfo endpoint.
+func exchangeAuthorizationCode(
+	ctx context.Context,
+	code string,
+	login oidcPendingLogin,
+) (jwt.MapClaims, error) {
+	provider, err := getOIDCProvider()
+	if err != nil {
+		return nil, err
+	}
+	config := getOIDCConfiguration()
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {config.RedirectURL},
+		"client_id":     {config.ClientID},
+		"code_verifier": {login.CodeVerifier},
+	}
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := doJSON(req, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	claims, err := verifyIDToken(tokenResponse.IDToken, provider, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if claims["nonce"] != login.Nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	if lookupClaim(claims, config.GroupsClaim) == nil && provider.UserinfoEndpoint != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.UserinfoEndpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+tokenResponse.AccessToken)
+		var userinfo map[string]interface{}
+		if err := doJSON(req, &userinfo); err != nil {
+			return nil, fmt.Errorf("userinfo request failed: %w", err)
+		}
+		if userinfo["sub"] != claims["sub"] {
+			return nil, errors.New("userinfo subject mismatch")
+		}
+		for k, v := range userinfo {
+			if _, ok := claims[k]; !ok {
+				claims[k] = v
+			}
+		}
+	}
+	return claims, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience and expiry of the given
+// ID token and returns its claims.
+func verifyIDToken(
+	idToken string,
+	provider oidcProvider,
+	config oidcConfiguration,
+) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(
+		idToken,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return getOIDCKey(provider, kid)
+		},
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("failed to cast token claims")
+	}
+	return claims, nil
+}
+
+// getOIDCKey returns the public key of the provider with the given key ID.
+//
+// The key set is fetched again if the key is unknown, since the provider may
+// have rotated its keys.
+func getOIDCKey(provider oidcProvider, kid string) (interface{}, error) {
+	oidcMutex.Lock()
+	defer oidcMutex.Unlock()
+	if key, ok := oidcKeys[kid]; ok {
+		return key, nil
+	}
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := getJSON(provider.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	oidcKeys = make(map[string]interface{})
+	for _, raw := range jwks.Keys {
+		keyID, key, err := parseJWK(raw)
+		if err != nil {
+			// Skip keys we don't support, e.g., encryption keys.
+			continue
+		}
+		oidcKeys[keyID] = key
+	}
+	if key, ok := oidcKeys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key ID: %s", kid)
+}
+
+// parseJWK parses an RSA or EC public key in JSON Web Key format.
+func parseJWK(raw json.RawMessage) (kid string, key interface{}, err error) {
+	var jwk struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return "", nil, fmt.Errorf("unsupported key use: %s", jwk.Use)
+	}
+	decode := func(s string) *big.Int {
+		b, decodeErr := base64.RawURLEncoding.DecodeString(s)
+		if decodeErr != nil || len(b) == 0 {
+			err = fmt.Errorf("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, e := decode(jwk.N), decode(jwk.E)
+		if err != nil {
+			return "", nil, err
+		}
+		return jwk.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, y := decode(jwk.X), decode(jwk.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		return jwk.Kid, &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+}
+
+// authorizeOIDCUser checks the group claims of a verified ID token.
+//
+// Users that are granted access are saved to the database, so they can be
+// assigned to agencies and notified via e-mail. Users that lost access are
+// removed.
+func authorizeOIDCUser(claims jwt.MapClaims) authorizationResult {
+	config := getOIDCConfiguration()
+	userID, ok := lookupClaim(claims, config.UserIDClaim).(string)
+	if !ok || userID == "" {
+		return authorizationResult{Predicate: INVALID}
+	}
+	groups := claimValues(lookupClaim(claims, config.GroupsClaim))
+	var roles []Role
+	for _, g := range getRoleGroups("OIDC") {
+		if slices.Contains(groups, g.Group) {
+			roles = append(roles, g.Role)
+		}
+	}
+	if len(roles) == 0 {
+		db.DeleteOIDCUser(userID)
+		syncAgencyGroupsForUser(userID)
+		return authorizationResult{Predicate: DENIED}
+	}
+	displayName, _ := claims["name"].(string)
+	if displayName == "" {
+		displayName, _ = claims["preferred_username"].(string)
+	}
+	if displayName == "" {
+		displayName = userID
+	}
+	email, _ := claims["email"].(string)
+	db.UpsertOIDCUser(db.OIDCUser{
+		UserID:      userID,
+		DisplayName: displayName,
+		Email:       email,
+		Roles:       roleNames(roles),
+		Groups:      groups,
+		LastLogin:   time.Now(),
+	})
+	syncAgencyGroupsForUser(userID)
+	return authorizationResult{
+		Predicate: GRANTED,
+		UserEntry: &userEntry{
+			ID:          userID,
+			DisplayName: displayName,
+			Permissions: newPermissions(roles),
+		},
+	}
+}
+
+func roleNames(roles []Role) []string {
+	names := make([]string, len(roles))
+	for i, r := range roles {
+		names[i] = string(r)
+	}
+	return names
+}
+
+func rolesFromNames(names []string) []Role {
+	roles := make([]Role, len(names))
+	for i, n := range names {
+		roles[i] = Role(n)
+	}
+	return roles
+}
+
+// lookupClaim returns the value of the given claim. Nested claims can be
+// addressed with dots, e.g., "realm_access.roles".
+func lookupClaim(claims map[string]interface{}, name string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(name, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// claimValues returns the string values of a claim that can be a single string
+// or an array.
+//
+// Leading slashes are removed, so group paths like "/ship_crew" match the
+// group name.
+func claimValues(value interface{}) []string {
+	var values []string
+	switch v := value.(type) {
+	case string:
+		values = append(values, strings.TrimPrefix(v, "/"))
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, strings.TrimPrefix(s, "/"))
+			}
+		}
+	}
+	return values
+}
+
+// getJSON fetches the given URL and decodes the JSON response into v.
+func getJSON(url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(req, v)
+}
+
+// doJSON sends the given request and decodes the JSON response into v.
+func doJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// randomString returns a random URL-safe string suitable for state, nonce and
+// PKCE code verifier.
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcDirectory is the user directory of users that logged in via OpenID
+// Connect.
+type oidcDirectory struct{}
+
+func (oidcDirectory) listUsers() []userEntry {
+	var userEntries []userEntry
+	for _, u := range db.FindOIDCUsers(context.Background()) {
+		userEntries = append(userEntries, userEntry{
+			ID:          u.UserID,
+			DisplayName: u.DisplayName,
+			Permissions: newPermissions(rolesFromNames(u.Roles)),
+		})
+	}
+	return userEntries
+}
+
+func (oidcDirectory) findUser(userID string) (userEntry, bool) {
+	u, ok := db.FindOIDCUser(context.Background(), userID)
+	if !ok {
+		return userEntry{}, false
+	}
+	return userEntry{ID: u.UserID, DisplayName: u.DisplayName}, true
+}
+
+// findUserWithPermissions returns the roles of the user's last login, since
+// the OpenID Connect provider can only be asked during a login.
+func (oidcDirectory) findUserWithPermissions(userID string) (userEntry, bool) {
+	u, ok := db.FindOIDCUser(context.Background(), userID)
+	if !ok {
+		return userEntry{}, false
+	}
+	return userEntry{
+		ID:          u.UserID,
+		DisplayName: u.DisplayName,
+		Permissions: newPermissions(rolesFromNames(u.Roles)),
+	}, true
+}
+
+func (oidcDirectory) getMailAddress(userID string) (string, error) {
+	u, ok := db.FindOIDCUser(context.Background(), userID)
+	if !ok {
+		return "", fmt.Errorf("oidc: failed to find user with ID %s", userID)
+	}
+	if u.Email == "" {
+		return "", fmt.Errorf("oidc: user with ID %s has no email address", userID)
+	}
+	return u.Email, nil
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"lath/xman/internal/db"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Role is a set of permissions granted to members of a directory group.
+type Role string
+
+const (
+	// RoleAdmin is the system administrator with all permissions.
+	RoleAdmin Role = "admin"
+	// RoleAgencyAdmin can resolve errors for the agencies they are assigned to
+	// in addition to the permissions of RoleArchivist.
+	RoleAgencyAdmin Role = "agency-admin"
+	// RoleArchivist can view and appraise processes of the agencies they are
+	// assigned to.
+	RoleArchivist Role = "archivist"
+	// RoleAuditor can view all processes without changing them.
+	RoleAuditor Role = "auditor"
+)
+
+// Permission is the right to use a group of routes.
+type Permission string
+
+const (
+	// PermissionReadProcesses allows viewing processes, messages, records,
+	// primary documents and reports.
+	PermissionReadProcesses Permission = "read-processes"
+	// PermissionEditProcesses allows appraising, packaging and archiving
+	// processes.
+	PermissionEditProcesses Permission = "edit-processes"
+	// PermissionResolveErrors allows viewing and resolving processing errors
+	// and quarantined files.
+	PermissionResolveErrors Permission = "resolve-errors"
+	// PermissionAdministrate allows managing agencies, archive collections,
+	// tasks and the configuration.
+	PermissionAdministrate Permission = "administrate"
+)
+
+// Scope is the range of agencies for which a permission is granted.
+type Scope int
+
+const (
+	// ScopeNone means the permission is not granted.
+	ScopeNone Scope = iota
+	// ScopeAssignedAgencies grants the permission for the agencies the user is
+	// assigned to.
+	ScopeAssignedAgencies
+	// ScopeAll grants the permission for all agencies.
+	ScopeAll
+)
+
+// rolePermissions defines the permissions of each role.
+var rolePermissions = map[Role]map[Permission]Scope{
+	RoleAdmin: {
+		PermissionReadProcesses: ScopeAll,
+		PermissionEditProcesses: ScopeAll,
+		PermissionResolveErrors: ScopeAll,
+		PermissionAdministrate:  ScopeAll,
+	},
+	RoleAgencyAdmin: {
+		PermissionReadProcesses: ScopeAssignedAgencies,
+		PermissionEditProcesses: ScopeAssignedAgencies,
+		PermissionResolveErrors: ScopeAssignedAgencies,
+	},
+	RoleArchivist: {
+		PermissionReadProcesses: ScopeAssignedAgencies,
+		PermissionEditProcesses: ScopeAssignedAgencies,
+	},
+	RoleAuditor: {
+		PermissionReadProcesses: ScopeAll,
+	},
+}
+
+// roleGroup maps a directory group to a role.
+type roleGroup struct {
+	Role  Role
+	Group string
+}
+
+// getRoleGroups returns the configured groups for each role, ordered from the
+// most to the least privileged role.
+//
+// prefix is the prefix of the environment variables, e.g., "LDAP". Unless
+// prefix is "LDAP", variables that are not set default to the LDAP variables.
+func getRoleGroups(prefix string) []roleGroup {
+	getGroup := func(name string) string {
+		group := os.Getenv(prefix + "_" + name)
+		if group == "" && prefix != "LDAP" {
+			group = os.Getenv("LDAP_" + name)
+		}
+		return group
+	}
+	var groups []roleGroup
+	for _, g := range []roleGroup{
+		{Role: RoleAdmin, Group: getGroup("ADMIN_GROUP")},
+		{Role: RoleAgencyAdmin, Group: getGroup("AGENCY_ADMIN_GROUP")},
+		{Role: RoleArchivist, Group: getGroup("ACCESS_GROUP")},
+		{Role: RoleAuditor, Group: getGroup("AUDITOR_GROUP")},
+	} {
+		if g.Group != "" {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// newPermissions returns the permissions for the given roles.
+func newPermissions(roles []Role) *permissions {
+	return &permissions{
+		Admin: slices.Contains(roles, RoleAdmin),
+		Roles: roles,
+	}
+}
+
+// Scope returns the range of agencies for which the given permission is
+// granted.
+func (p *permissions) Scope(permission Permission) Scope {
+	scope := ScopeNone
+	for _, r := range p.Roles {
+		scope = max(scope, rolePermissions[r][permission])
+	}
+	if slices.Contains(p.Permissions, permission) {
+		if len(p.Agencies) > 0 {
+			scope = max(scope, ScopeAssignedAgencies)
+		} else {
+			scope = ScopeAll
+		}
+	}
+	return scope
+}
+
+// PermissionRequired requires a valid bearer token with the given permission
+// or else stops the request.
+//
+// Whether the permission applies to a specific agency has to be checked with
+// HasAgencyPermission. The administrate permission is not limited to agencies
+// and requires ScopeAll.
+func PermissionRequired(permission Permission) gin.HandlerFunc {
+	minScope := ScopeAssignedAgencies
+	if permission == PermissionAdministrate {
+		minScope = ScopeAll
+	}
+	return func(c *gin.Context) {
+		tokenString := extractToken(c)
+		result, err := validateToken(tokenString)
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		} else if result.Permissions.Scope(permission) < minScope {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Set("userId", result.UserID)
+		c.Set("permissions", result.Permissions)
+	}
+}
+
+// PermissionScope returns the range of agencies for which the user of the
+// request is granted the given permission.
+func PermissionScope(c *gin.Context, permission Permission) Scope {
+	return getPermissions(c).Scope(permission)
+}
+
+// getPermissions returns the permissions of the user of the request.
+func getPermissions(c *gin.Context) *permissions {
+	p, ok := c.Get("permissions")
+	if !ok {
+		return &permissions{}
+	}
+	return p.(*permissions)
+}
+
+// HasAgencyPermission returns true if the user of the request is granted the
+// given permission for the given agency.
+func HasAgencyPermission(c *gin.Context, permission Permission, agencyID primitive.ObjectID) bool {
+	switch PermissionScope(c, permission) {
+	case ScopeAll:
+		return true
+	case ScopeAssignedAgencies:
+		if p := getPermissions(c); len(p.Agencies) > 0 {
+			return slices.Contains(p.Agencies, agencyID)
+		}
+		agency, ok := db.FindAgency(c.Request.Context(), agencyID)
+		return ok && slices.ContainsFunc(
+			AssignedAgencyUsers(c.Request.Context(), c.GetString("userId")),
+			agency.IsAssigned,
+		)
+	default:
+		return false
+	}
+}
+
+// AccessibleAgencyIDs returns the IDs of the agencies for which the user of the
+// request is granted the given permission, or nil if the permission is granted
+// for all agencies.
+func AccessibleAgencyIDs(c *gin.Context, permission Permission) []primitive.ObjectID {
+	switch PermissionScope(c, permission) {
+	case ScopeAll:
+		return nil
+	case ScopeAssignedAgencies:
+		if p := getPermissions(c); len(p.Agencies) > 0 {
+			return p.Agencies
+		}
+		ids := make([]primitive.ObjectID, 0)
+		userIDs := AssignedAgencyUsers(c.Request.Context(), c.GetString("userId"))
+		for _, a := range db.FindAgenciesForUsers(c.Request.Context(), userIDs) {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	default:
+		return make([]primitive.ObjectID, 0)
+	}
+}
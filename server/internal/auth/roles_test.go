@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles []Role
+		want  map[Permission]Scope
+	}{
+		{"no roles", nil, map[Permission]Scope{
+			PermissionReadProcesses: ScopeNone,
+			PermissionEditProcesses: ScopeNone,
+			PermissionResolveErrors: ScopeNone,
+			PermissionAdministrate:  ScopeNone,
+		}},
+		{"admin", []Role{RoleAdmin}, map[Permission]Scope{
+			PermissionReadProcesses: ScopeAll,
+			PermissionEditProcesses: ScopeAll,
+			PermissionResolveErrors: ScopeAll,
+			PermissionAdministrate:  ScopeAll,
+		}},
+		{"agency admin", []Role{RoleAgencyAdmin}, map[Permission]Scope{
+			PermissionReadProcesses: ScopeAssignedAgencies,
+			PermissionEditProcesses: ScopeAssignedAgencies,
+			PermissionResolveErrors: ScopeAssignedAgencies,
+			PermissionAdministrate:  ScopeNone,
+		}},
+		{"archivist", []Role{RoleArchivist}, map[Permission]Scope{
+			PermissionReadProcesses: ScopeAssignedAgencies,
+			PermissionEditProcesses: ScopeAssignedAgencies,
+			PermissionResolveErrors: ScopeNone,
+			PermissionAdministrate:  ScopeNone,
+		}},
+		{"auditor", []Role{RoleAuditor}, map[Permission]Scope{
+			PermissionReadProcesses: ScopeAll,
+			PermissionEditProcesses: ScopeNone,
+			PermissionResolveErrors: ScopeNone,
+			PermissionAdministrate:  ScopeNone,
+		}},
+		{"archivist and auditor", []Role{RoleArchivist, RoleAuditor}, map[Permission]Scope{
+			PermissionReadProcesses: ScopeAll,
+			PermissionEditProcesses: ScopeAssignedAgencies,
+			PermissionResolveErrors: ScopeNone,
+			PermissionAdministrate:  ScopeNone,
+		}},
+		{"unknown role", []Role{"unknown"}, map[Permission]Scope{
+			PermissionReadProcesses: ScopeNone,
+			PermissionEditProcesses: ScopeNone,
+			PermissionResolveErrors: ScopeNone,
+			PermissionAdministrate:  ScopeNone,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPermissions(tt.roles)
+			for permission, want := range tt.want {
+				if got := p.Scope(permission); got != want {
+					t.Errorf("Scope(%s) = %v, want %v", permission, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScopeWithGrantedPermissions(t *testing.T) {
+	agencies := []primitive.ObjectID{primitive.NewObjectID()}
+	tests := []struct {
+		name        string
+		roles       []Role
+		permissions []Permission
+		agencies    []primitive.ObjectID
+		permission  Permission
+		want        Scope
+	}{
+		{"not granted", nil, []Permission{PermissionReadProcesses}, nil, PermissionEditProcesses, ScopeNone},
+		{"all agencies", nil, []Permission{PermissionReadProcesses}, nil, PermissionReadProcesses, ScopeAll},
+		{"given agencies", nil, []Permission{PermissionReadProcesses}, agencies, PermissionReadProcesses, ScopeAssignedAgencies},
+		{"administrate", nil, []Permission{PermissionAdministrate}, nil, PermissionAdministrate, ScopeAll},
+		{"administrate for agencies", nil, []Permission{PermissionAdministrate}, agencies, PermissionAdministrate, ScopeAssignedAgencies},
+		{"role grants more", []Role{RoleAuditor}, []Permission{PermissionReadProcesses}, agencies, PermissionReadProcesses, ScopeAll},
+		{"role grants less", []Role{RoleArchivist}, []Permission{PermissionEditProcesses}, nil, PermissionEditProcesses, ScopeAll},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := permissions{Roles: tt.roles, Permissions: tt.permissions, Agencies: tt.agencies}
+			if got := p.Scope(tt.permission); got != tt.want {
+				t.Errorf("Scope(%s) = %v, want %v", tt.permission, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPermissionsAdmin(t *testing.T) {
+	tests := []struct {
+		roles []Role
+		want  bool
+	}{
+		{nil, false},
+		{[]Role{RoleAdmin}, true},
+		{[]Role{RoleArchivist, RoleAdmin}, true},
+		{[]Role{RoleAgencyAdmin, RoleAuditor}, false},
+	}
+	for _, tt := range tests {
+		if got := newPermissions(tt.roles).Admin; got != tt.want {
+			t.Errorf("newPermissions(%v).Admin = %v, want %v", tt.roles, got, tt.want)
+		}
+	}
+}
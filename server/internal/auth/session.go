@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"lath/xman/internal/db"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultAccessTokenLifetime is the validity period of access tokens if
+	// ACCESS_TOKEN_LIFETIME_MINUTES is not set.
+	defaultAccessTokenLifetime = 10 * time.Minute
+	// refreshReuseGracePeriod is the time in which a replaced refresh token may
+	// be used again without revoking the session, e.g., by a second browser tab
+	// that refreshed at the same time.
+	refreshReuseGracePeriod = 30 * time.Second
+)
+
+var (
+	ErrSessionRevoked = errors.New("session revoked")
+	// revokedSessions holds the IDs of sessions that were revoked while access
+	// tokens issued for them may still be valid, mapped to the time after
+	// which these access tokens have expired.
+	revokedSessions      = make(map[string]time.Time)
+	revokedSessionsMutex sync.Mutex
+)
+
+// accessTokenLifetime returns the validity period of access tokens.
+//
+// Changes of a user's permissions take effect after this time at the latest.
+func accessTokenLifetime() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("ACCESS_TOKEN_LIFETIME_MINUTES"))
+	if err != nil || minutes <= 0 {
+		return defaultAccessTokenLifetime
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// sessionLifetime returns the time after which a user has to log in again.
+func sessionLifetime() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("LOGIN_TOKEN_LIFETIME_DAYS"))
+	if err != nil {
+		panic("missing or improper env variable LOGIN_TOKEN_LIFETIME_DAYS")
+	}
+	return 24 * time.Hour * time.Duration(days)
+}
+
+// loadRevokedSessions restores the revoked sessions whose access tokens may
+// still be valid after a restart.
+func loadRevokedSessions() {
+	since := time.Now().Add(-accessTokenLifetime())
+	for _, s := range db.FindSessionsRevokedAfter(context.Background(), since) {
+		markSessionRevoked(s.ID.Hex())
+	}
+}
+
+// markSessionRevoked rejects access tokens of the given session from now on.
+func markSessionRevoked(sessionID string) {
+	revokedSessionsMutex.Lock()
+	defer revokedSessionsMutex.Unlock()
+	now := time.Now()
+	for id, expiry := range revokedSessions {
+		if now.After(expiry) {
+			delete(revokedSessions, id)
+		}
+	}
+	revokedSessions[sessionID] = now.Add(accessTokenLifetime())
+}
+
+func isSessionRevoked(sessionID string) bool {
+	revokedSessionsMutex.Lock()
+	defer revokedSessionsMutex.Unlock()
+	_, ok := revokedSessions[sessionID]
+	return ok
+}
+
+// startSession creates a new session for the authorized user and responds with
+// an access token, a refresh token and information about permissions and the
+// user.
+func startSession(c *gin.Context, user userEntry, loginMethod string) {
+	refreshToken := randomString()
+	now := time.Now()
+	s := db.Session{
+		ID:               primitive.NewObjectID(),
+		UserID:           user.ID,
+		LoginMethod:      loginMethod,
+		RefreshTokenHash: hashToken(refreshToken),
+		CreatedAt:        now,
+		RefreshedAt:      now,
+		ExpiresAt:        now.Add(sessionLifetime()),
+		ClientIP:         c.ClientIP(),
+		UserAgent:        c.Request.UserAgent(),
+	}
+	db.InsertSession(s)
+	c.JSON(http.StatusOK, gin.H{
+		"token":        createToken(user, s.ID.Hex()),
+		"refreshToken": refreshToken,
+		"user":         user,
+	})
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new
+// refresh token.
+//
+// The permissions of the user are read again from the user directory, so
+// changes take effect without logging in again. Sessions of users that lost
+// their access rights are revoked. A refresh token can only be used once; if a
+// replaced refresh token is used again later, the session is revoked since the
+// token was probably stolen.
+func RefreshSession(c *gin.Context) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+	hash := hashToken(body.RefreshToken)
+	s, ok := db.FindSessionByRefreshTokenHash(c.Request.Context(), hash)
+	if !ok || s.RevokedAt != nil || time.Now().After(s.ExpiresAt) {
+		c.String(http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	if s.RefreshTokenHash != hash {
+		if time.Since(s.RefreshedAt) > refreshReuseGracePeriod {
+			log.Printf("Reused refresh token for session %s of user %s\n", s.ID.Hex(), s.UserID)
+			RevokeSession(s.ID, "system")
+		}
+		c.String(http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	user, ok := refreshUser(s.UserID)
+	if !ok || user.Permissions == nil || len(user.Permissions.Roles) == 0 {
+		log.Printf("Revoking session %s of user %s without access rights\n", s.ID.Hex(), s.UserID)
+		RevokeSession(s.ID, "system")
+		c.String(http.StatusUnauthorized, "Access revoked")
+		return
+	}
+	refreshToken := randomString()
+	if !db.UpdateSessionRefreshed(s.ID, hash, hashToken(refreshToken), time.Now()) {
+		// A concurrent request used the same refresh token.
+		c.String(http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":        createToken(user, s.ID.Hex()),
+		"refreshToken": refreshToken,
+		"user":         user,
+	})
+}
+
+// Logout revokes the session of the given refresh token.
+func Logout(c *gin.Context) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		return
+	}
+	s, ok := db.FindSessionByRefreshTokenHash(c.Request.Context(), hashToken(body.RefreshToken))
+	if ok {
+		RevokeSession(s.ID, GetDisplayName(s.UserID))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListSessions returns the active sessions of the given user or of all users
+// if userID is empty.
+func ListSessions(ctx context.Context, userID string) []db.Session {
+	return db.FindActiveSessions(ctx, userID)
+}
+
+// RevokeSession revokes the session with the given ID. Access tokens issued
+// for the session are rejected immediately.
+func RevokeSession(id primitive.ObjectID, revokedBy string) bool {
+	markSessionRevoked(id.Hex())
+	return db.UpdateSessionRevoked(id, revokedBy)
+}
+
+// RevokeUserSessions revokes all active sessions of the given user and
+// returns the number of revoked sessions.
+func RevokeUserSessions(userID string, revokedBy string) int {
+	n := 0
+	for _, s := range db.FindActiveSessions(context.Background(), userID) {
+		if RevokeSession(s.ID, revokedBy) {
+			n++
+		}
+	}
+	return n
+}
@@ -4,32 +4,117 @@ import (
 	"context"
 	"fmt"
 	"lath/xman/internal/db"
+	"log"
+	"os"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type AppraisableRecordRelations struct {
-	Parent       *string // nil for root-level records
-	Children     []string
+	Parent   *string // nil for root-level records
+	Children []string
+	// HasDocuments is true if the record has documents that are not
+	// appraisable themselves.
 	HasDocuments bool
 	Type         db.RecordType
 }
 
 type AppraisableRecordsMap map[string]AppraisableRecordRelations
 
-func AppraisableRecords(r *db.RootRecords) AppraisableRecordsMap {
+// AppraisalLevel returns the appraisal level that applies to the given
+// process.
+//
+// A level set for the process takes precedence over the level set for its
+// agency, which in turn takes precedence over the global configuration.
+func AppraisalLevel(process db.SubmissionProcess) db.AppraisalLevel {
+	if process.AppraisalLevel != db.AppraisalLevelDefault {
+		return process.AppraisalLevel
+	} else if process.Agency.AppraisalLevel != db.AppraisalLevelDefault {
+		return process.Agency.AppraisalLevel
+	}
+	return db.AppraisalLevel(os.Getenv("APPRAISAL_LEVEL"))
+}
+
+// IsValidAppraisalLevel returns true if the given level can be configured for
+// an agency or process.
+func IsValidAppraisalLevel(level db.AppraisalLevel) bool {
+	switch level {
+	case db.AppraisalLevelDefault,
+		db.AppraisalLevelRoot,
+		db.AppraisalLevelAll,
+		db.AppraisalLevelDocument:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetProcessAppraisalLevel overrides the appraisal level for the given process.
+//
+// The appraisal level can only be changed until the appraisal is finished.
+// Appraisal decisions for records that are no longer appraisable with the new
+// level are kept but ignored.
+func SetProcessAppraisalLevel(processID string, level db.AppraisalLevel) error {
+	if !IsValidAppraisalLevel(level) {
+		return fmt.Errorf("invalid appraisal level: \"%s\"", level)
+	}
+	process, found := db.FindProcess(context.Background(), processID)
+	if !found {
+		return fmt.Errorf("process not found: %s", processID)
+	} else if process.ProcessState.Appraisal.Complete {
+		return fmt.Errorf("appraisal already finished for process \"%s\"", processID)
+	}
+	db.UpdateProcessAppraisalLevel(processID, level)
+	if process.ProcessState.Receive0501.Complete {
+		updateAppraisalProcessStep(processID)
+	}
+	return nil
+}
+
+// appraisalLevelForProcess looks up the process and returns its appraisal
+// level.
+func appraisalLevelForProcess(processID string) db.AppraisalLevel {
+	process, found := db.FindProcess(context.Background(), processID)
+	if !found {
+		panic(fmt.Errorf("process not found: %s", processID))
+	}
+	return AppraisalLevel(process)
+}
+
+// AppraisableRecords returns all records that can be appraised with the given
+// appraisal level.
+//
+// Files and processes are always appraisable. Documents are only appraisable
+// with the appraisal level "document". Attachments are never appraisable.
+func AppraisableRecords(r *db.RootRecords, level db.AppraisalLevel) AppraisableRecordsMap {
 	m := make(AppraisableRecordsMap)
+	includeDocuments := level == db.AppraisalLevelDocument
 	var appendFileRecords func(parent *string, files []db.FileRecord) (childIDs []string)
 	var appendProcessRecords func(parent *string, processes []db.ProcessRecord) (childIDs []string)
+	appendDocumentRecords := func(parent *string, documents []db.DocumentRecord) (childIDs []string) {
+		if !includeDocuments {
+			return
+		}
+		for _, d := range documents {
+			childIDs = append(childIDs, d.RecordID)
+			m[d.RecordID] = AppraisableRecordRelations{
+				Parent: parent,
+				Type:   db.RecordTypeDocument,
+			}
+		}
+		return
+	}
 	appendFileRecords = func(parent *string, files []db.FileRecord) (childIDs []string) {
 		for _, f := range files {
 			childIDs = append(childIDs, f.RecordID)
 			innerChildIDs := appendFileRecords(&f.RecordID, f.Subfiles)
 			innerChildIDs = append(innerChildIDs, appendProcessRecords(&f.RecordID, f.Processes)...)
+			innerChildIDs = append(innerChildIDs, appendDocumentRecords(&f.RecordID, f.Documents)...)
 			m[f.RecordID] = AppraisableRecordRelations{
 				Parent:       parent,
 				Children:     innerChildIDs,
-				HasDocuments: len(f.Documents) > 0,
+				HasDocuments: len(f.Documents) > 0 && !includeDocuments,
 				Type:         db.RecordTypeFile,
 			}
 		}
@@ -39,10 +124,11 @@ func AppraisableRecords(r *db.RootRecords) AppraisableRecordsMap {
 		for _, p := range processes {
 			childIDs = append(childIDs, p.RecordID)
 			innerChildIDs := appendProcessRecords(&p.RecordID, p.Subprocesses)
+			innerChildIDs = append(innerChildIDs, appendDocumentRecords(&p.RecordID, p.Documents)...)
 			m[p.RecordID] = AppraisableRecordRelations{
 				Parent:       parent,
 				Children:     innerChildIDs,
-				HasDocuments: len(p.Documents) > 0,
+				HasDocuments: len(p.Documents) > 0 && !includeDocuments,
 				Type:         db.RecordTypeProcess,
 			}
 		}
@@ -50,17 +136,18 @@ func AppraisableRecords(r *db.RootRecords) AppraisableRecordsMap {
 	}
 	appendFileRecords(nil, r.Files)
 	appendProcessRecords(nil, r.Processes)
+	appendDocumentRecords(nil, r.Documents)
 	return m
 }
 
-// AreAllRecordObjectsAppraised verifies whether every file, subfile, process, and subprocess has been appraised
-// with either an 'A' (de: archivieren) or 'V' (de: vernichten).
+// AreAllRecordObjectsAppraised verifies whether every file, subfile, process,
+// subprocess, and with appraisal level "document" every document has been
+// appraised with either an 'A' (de: archivieren), 'B' (de: Stichprobe) or 'V' (de: vernichten).
 func AreAllRecordObjectsAppraised(ctx context.Context, processID string) bool {
-	rootRecords := db.FindAllRootRecords(ctx, processID, db.MessageType0501)
-	m := AppraisableRecords(&rootRecords)
+	m := appraisableRecordsForMessage(ctx, processID, db.MessageType0501, appraisalLevelForProcess(processID))
+	decisions := appraisalDecisions(ctx, processID)
 	for id := range m {
-		a, _ := db.FindAppraisal(processID, id)
-		if a.Decision != "A" && a.Decision != "V" {
+		if !decisions[id].IsComplete() {
 			return false
 		}
 	}
@@ -78,8 +165,8 @@ func AreAllRecordObjectsAppraised(ctx context.Context, processID string) bool {
 //
 // It clears the internal appraisal note of children that it updates.
 //
-// If the decision to set is "A" and given record is a sub record, it makes sure
-// that all ancestors are also set to "A".
+// If the decision to set is "A" or "B" and given record is a sub record, it
+// makes sure that all ancestors are also marked to be archived.
 //
 // For any other decision, if the given record is a sub record, in case that
 // with the given appraisal all siblings have assumed the same decision, it
@@ -96,14 +183,13 @@ func SetAppraisalDecisionRecursive(
 	} else if process.ProcessState.Appraisal.Complete {
 		return fmt.Errorf("appraisal already finished for process \"%s\"", processID)
 	}
-	rootRecords, ok := db.FindRootRecord(context.Background(), processID, db.MessageType0501, recordID)
-	if !ok {
+	if _, ok := db.FindRecordIndexEntry(context.Background(), processID, db.MessageType0501, recordID); !ok {
 		return fmt.Errorf("record object not found: %v", recordID)
 	}
-	m := AppraisableRecords(&rootRecords)
+	m := appraisableRecordsForMessage(context.Background(), processID, db.MessageType0501, AppraisalLevel(process))
 	previousAppraisal, _ := db.FindAppraisal(processID, recordID)
 	db.UpsertAppraisalDecision(processID, recordID, decision)
-	if decision == db.AppraisalDecisionA {
+	if decision.ToBeArchived() {
 		markAncestorsToBeArchived(processID, m, recordID)
 	} else {
 		matchParentForEqualSiblings(processID, m, recordID, decision)
@@ -150,14 +236,33 @@ func SetAppraisalInternalNote(
 	return nil
 }
 
+// SetAppraisalPublicNote saves a note for the given record object that is sent
+// to the agency with the 0502 message.
+func SetAppraisalPublicNote(
+	processID string,
+	recordID string,
+	publicNote string,
+) error {
+	process, found := db.FindProcess(context.Background(), processID)
+	if !found {
+		return fmt.Errorf("process not found: %s", processID)
+	} else if !process.ProcessState.Receive0501.Complete {
+		return fmt.Errorf("process \"%s\" has no 0501 message", processID)
+	} else if process.ProcessState.Appraisal.Complete {
+		return fmt.Errorf("appraisal already finished for process \"%s\"", processID)
+	}
+	db.UpsertAppraisalPublicNote(processID, recordID, publicNote)
+	return nil
+}
+
 // SetAppraisals saves an appraisal decision and optional internal note for a
 // number of record objects to the database.
 //
 // It checks whether any of the given objects are children of on another and
 // omits the appraisal note for all child objects.
 //
-// If the decision to set is "A", it makes sure that for all sub objects, all
-// ancestors are also set to "A".
+// If the decision to set is "A" or "B", it makes sure that for all sub objects,
+// all ancestors are also marked to be archived.
 func SetAppraisals(
 	processID string,
 	recordIDs []string,
@@ -172,8 +277,7 @@ func SetAppraisals(
 	} else if process.ProcessState.Appraisal.Complete {
 		return fmt.Errorf("appraisal already finished for process \"%s\"", processID)
 	}
-	rootRecords := db.FindAllRootRecords(context.Background(), processID, db.MessageType0501)
-	m := AppraisableRecords(&rootRecords)
+	m := appraisableRecordsForMessage(context.Background(), processID, db.MessageType0501, AppraisalLevel(process))
 	isSubAppraisal := map[int]bool{}
 	// Mark all record objects as sub appraisals that have an ancestor of which
 	// we are setting the appraisal.
@@ -197,7 +301,7 @@ func SetAppraisals(
 			db.UpsertAppraisal(processID, id, decision, "")
 		} else {
 			db.UpsertAppraisal(processID, id, decision, internalNote)
-			if decision == db.AppraisalDecisionA {
+			if decision.ToBeArchived() {
 				markAncestorsToBeArchived(processID, m, id)
 			} else {
 				matchParentForEqualSiblings(processID, m, id, decision)
@@ -241,10 +345,12 @@ func matchParentForEqualSiblings(
 	}
 }
 
+// markAncestorsToBeArchived sets all ancestors of the given record to "A"
+// unless they are already marked to be archived.
 func markAncestorsToBeArchived(processID string, m AppraisableRecordsMap, id string) {
 	for parent := m[id].Parent; parent != nil; parent = m[*parent].Parent {
 		a, _ := db.FindAppraisal(processID, *parent)
-		if a.Decision != db.AppraisalDecisionA {
+		if !a.Decision.ToBeArchived() {
 			db.UpsertAppraisal(processID, *parent, db.AppraisalDecisionA, "")
 		}
 	}
@@ -258,15 +364,65 @@ func FinalizeMessageAppraisal(message db.Message, completedBy string) db.Message
 		true,
 		completedBy,
 	)
+	process, found := db.FindProcess(context.Background(), message.MessageHead.ProcessID)
+	if !found {
+		panic("process not found: " + message.MessageHead.ProcessID)
+	}
+	saveAppraisalStatistics(process, message)
 	return message
 }
 
+// ReopenAppraisal reverts a finalized appraisal, so the appraisal can be
+// changed and a corrected 0502 message can be sent by finalizing the appraisal
+// again.
+//
+// Reopening is possible as long as no 0503 message has been received. It
+// restores the appraisal decisions as they were before finalizing, i.e., it
+// clears decisions that have been set implicitly. A 0505 message received for
+// the previous 0502 message is deleted, so the agency can confirm the
+// corrected 0502 message.
+func ReopenAppraisal(
+	processID string,
+	mode db.Correction0502Mode,
+	reopenedBy string,
+) error {
+	if mode != db.Correction0502ModeReplace && mode != db.Correction0502ModeSupersede {
+		return fmt.Errorf("invalid correction mode: \"%s\"", mode)
+	}
+	process, found := db.FindProcess(context.Background(), processID)
+	if !found {
+		return fmt.Errorf("process not found: %s", processID)
+	} else if !process.ProcessState.Appraisal.Complete {
+		return fmt.Errorf("appraisal not finished for process \"%s\"", processID)
+	} else if _, ok := db.TryFindMessage(context.Background(), processID, db.MessageType0503); ok {
+		return fmt.Errorf("process \"%s\" already has a 0503 message", processID)
+	}
+	log.Printf("Reopening appraisal for process %s (%s)\n", processID, mode)
+	db.ResetImplicitAppraisalDecisions(processID)
+	db.DeleteAppraisalStatisticsForProcess(processID)
+	if _, ok := db.TryFindMessage(context.Background(), processID, db.MessageType0505); ok {
+		if err := DeleteMessage(processID, db.MessageType0505, false); err != nil {
+			panic(err)
+		}
+	}
+	db.PushAppraisalReopening(processID, db.AppraisalReopening{
+		ReopenedAt: time.Now(),
+		ReopenedBy: reopenedBy,
+		Mode:       mode,
+	})
+	db.MustUpdateProcessStepCompletion(processID, db.ProcessStepAppraisal, false, "")
+	updateAppraisalProcessStep(processID)
+	return nil
+}
+
 func markUnappraisedRecordObjectsAsDiscardable(message db.Message) {
-	rootRecords := db.FindAllRootRecords(context.Background(), message.MessageHead.ProcessID, message.MessageType)
-	for id := range AppraisableRecords(&rootRecords) {
-		a, _ := db.FindAppraisal(message.MessageHead.ProcessID, id)
-		if a.Decision != "A" && a.Decision != "V" {
-			db.UpsertAppraisalDecision(message.MessageHead.ProcessID, id, "V")
+	ctx := context.Background()
+	processID := message.MessageHead.ProcessID
+	level := appraisalLevelForProcess(processID)
+	decisions := appraisalDecisions(ctx, processID)
+	for id := range appraisableRecordsForMessage(ctx, processID, message.MessageType, level) {
+		if !decisions[id].IsComplete() {
+			db.UpsertImplicitAppraisalDecision(message.MessageHead.ProcessID, id, db.AppraisalDecisionV)
 		}
 	}
 }
@@ -276,18 +432,18 @@ func updateAppraisalProcessStep(processID string) {
 	if !found {
 		panic(fmt.Errorf("process not found: %s", processID))
 	}
-	rootRecords := db.FindAllRootRecords(context.Background(), processID, db.MessageType0501)
+	ctx := context.Background()
+	m := appraisableRecordsForMessage(ctx, processID, db.MessageType0501, AppraisalLevel(process))
+	decisions := appraisalDecisions(ctx, processID)
 	var appraisableRootRecordIDs []string
-	for _, r := range rootRecords.Files {
-		appraisableRootRecordIDs = append(appraisableRootRecordIDs, r.RecordID)
-	}
-	for _, r := range rootRecords.Processes {
-		appraisableRootRecordIDs = append(appraisableRootRecordIDs, r.RecordID)
+	for id, r := range m {
+		if r.Parent == nil {
+			appraisableRootRecordIDs = append(appraisableRootRecordIDs, id)
+		}
 	}
 	numberAppraisalComplete := 0
 	for _, r := range appraisableRootRecordIDs {
-		a, _ := db.FindAppraisal(processID, r)
-		if a.Decision == db.AppraisalDecisionA || a.Decision == db.AppraisalDecisionV {
+		if decisions[r].IsComplete() {
 			numberAppraisalComplete++
 		}
 	}
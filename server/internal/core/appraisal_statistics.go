@@ -0,0 +1,191 @@
+package core
+
+import (
+	"context"
+	"lath/xman/internal/db"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AppraisalStatisticsCounts struct {
+	Offered   int `json:"offered"`
+	Archived  int `json:"archived"`
+	Sampled   int `json:"sampled"`
+	Discarded int `json:"discarded"`
+	// Recommended is the number of records for which the agency recommended
+	// to archive or to discard them. Records recommended for appraisal ("B")
+	// are not included.
+	Recommended int `json:"recommended"`
+	// Agreed is the number of records of which the appraisal decision has the
+	// same outcome as the agency's recommendation.
+	Agreed int `json:"agreed"`
+}
+
+func (c *AppraisalStatisticsCounts) add(g db.AppraisalStatisticsGroup) {
+	c.Offered += g.Offered
+	c.Archived += g.Archived
+	c.Sampled += g.Sampled
+	c.Discarded += g.Discarded
+	c.Recommended += g.Recommended
+	c.Agreed += g.Agreed
+}
+
+type RecordTypeAppraisalStatistics struct {
+	Files        AppraisalStatisticsCounts `json:"files"`
+	Subfiles     AppraisalStatisticsCounts `json:"subfiles"`
+	Processes    AppraisalStatisticsCounts `json:"processes"`
+	Subprocesses AppraisalStatisticsCounts `json:"subprocesses"`
+}
+
+func (s *RecordTypeAppraisalStatistics) add(g db.AppraisalStatisticsGroup) {
+	switch {
+	case g.RecordType == db.RecordTypeFile && !g.IsSubRecord:
+		s.Files.add(g)
+	case g.RecordType == db.RecordTypeFile:
+		s.Subfiles.add(g)
+	case g.RecordType == db.RecordTypeProcess && !g.IsSubRecord:
+		s.Processes.add(g)
+	case g.RecordType == db.RecordTypeProcess:
+		s.Subprocesses.add(g)
+	}
+}
+
+type FilePlanUnitAppraisalStatistics struct {
+	FilePlanNumber string `json:"filePlanNumber"`
+	Subject        string `json:"subject"`
+	RecordTypeAppraisalStatistics
+}
+
+type AgencyAppraisalStatistics struct {
+	AgencyID   primitive.ObjectID `json:"agencyId"`
+	AgencyName string             `json:"agencyName"`
+	RecordTypeAppraisalStatistics
+	// FilePlanUnits breaks down the statistics by file-plan unit. Records
+	// without file-plan unit are grouped with an empty file-plan number.
+	FilePlanUnits []FilePlanUnitAppraisalStatistics `json:"filePlanUnits"`
+}
+
+// AppraisalStatistics returns aggregated statistics of all appraisals that have
+// been finalized in the time range [from, to), including those of processes
+// that have been deleted since.
+//
+// If agencyID is nil, statistics for all agencies are returned.
+func AppraisalStatistics(
+	ctx context.Context,
+	agencyID *primitive.ObjectID,
+	from, to time.Time,
+) []AgencyAppraisalStatistics {
+	agencyNames := make(map[primitive.ObjectID]string)
+	for _, a := range db.FindAgencies(ctx) {
+		agencyNames[a.ID] = a.Name
+	}
+	result := make([]AgencyAppraisalStatistics, 0)
+	var agency *AgencyAppraisalStatistics
+	var unit *FilePlanUnitAppraisalStatistics
+	// Groups are sorted by agency and file-plan number.
+	for _, g := range db.AggregateAppraisalStatistics(ctx, agencyID, from, to) {
+		if agency == nil || agency.AgencyID != g.AgencyID {
+			result = append(result, AgencyAppraisalStatistics{
+				AgencyID:   g.AgencyID,
+				AgencyName: agencyNames[g.AgencyID],
+			})
+			agency = &result[len(result)-1]
+			unit = nil
+		}
+		if unit == nil || unit.FilePlanNumber != g.FilePlanNumber {
+			agency.FilePlanUnits = append(agency.FilePlanUnits, FilePlanUnitAppraisalStatistics{
+				FilePlanNumber: g.FilePlanNumber,
+				Subject:        g.FilePlanSubject,
+			})
+			unit = &agency.FilePlanUnits[len(agency.FilePlanUnits)-1]
+		}
+		agency.add(g)
+		unit.add(g)
+	}
+	return result
+}
+
+// saveAppraisalStatistics saves the appraisal decisions of all files and
+// processes of the given process for long-term statistics.
+func saveAppraisalStatistics(process db.SubmissionProcess, message db.Message) {
+	appraisals := make(map[string]db.Appraisal)
+	for _, a := range db.FindAppraisalsForProcess(context.Background(), process.ProcessID) {
+		appraisals[a.RecordID] = a
+	}
+	appraisedAt := process.ProcessState.Appraisal.CompletedAt
+	if appraisedAt.IsZero() {
+		appraisedAt = time.Now()
+	}
+	var entries []db.AppraisalStatisticsEntry
+	appendEntry := func(
+		recordID string,
+		recordType db.RecordType,
+		isSubRecord bool,
+		generalMetadata *db.GeneralMetadata,
+		archiveMetadata *db.ArchiveMetadata,
+		parentFilePlan *db.FilePlan,
+	) *db.FilePlan {
+		filePlan := parentFilePlan
+		if generalMetadata != nil && generalMetadata.FilePlan != nil {
+			filePlan = generalMetadata.FilePlan
+		}
+		e := db.AppraisalStatisticsEntry{
+			ProcessID:   process.ProcessID,
+			AgencyID:    process.Agency.ID,
+			AppraisedAt: appraisedAt,
+			RecordID:    recordID,
+			RecordType:  recordType,
+			IsSubRecord: isSubRecord,
+			Decision:    appraisals[recordID].Decision,
+		}
+		if filePlan != nil {
+			e.FilePlanNumber = filePlan.FilePlanNumber
+			e.FilePlanSubject = filePlan.Subject
+		}
+		if archiveMetadata != nil {
+			e.Recommendation = archiveMetadata.AppraisalRecommCode
+		}
+		entries = append(entries, e)
+		return filePlan
+	}
+	var appendFiles func(files []db.FileRecord, isSubRecord bool, filePlan *db.FilePlan)
+	var appendProcesses func(processes []db.ProcessRecord, isSubRecord bool, filePlan *db.FilePlan)
+	appendFiles = func(files []db.FileRecord, isSubRecord bool, filePlan *db.FilePlan) {
+		for _, f := range files {
+			fp := appendEntry(f.RecordID, db.RecordTypeFile, isSubRecord,
+				f.GeneralMetadata, f.ArchiveMetadata, filePlan)
+			appendFiles(f.Subfiles, true, fp)
+			appendProcesses(f.Processes, true, fp)
+		}
+	}
+	appendProcesses = func(processes []db.ProcessRecord, isSubRecord bool, filePlan *db.FilePlan) {
+		for _, p := range processes {
+			fp := appendEntry(p.RecordID, db.RecordTypeProcess, isSubRecord,
+				p.GeneralMetadata, p.ArchiveMetadata, filePlan)
+			appendProcesses(p.Subprocesses, true, fp)
+		}
+	}
+	rootRecords := db.FindAllRootRecords(context.Background(), process.ProcessID, message.MessageType)
+	appendFiles(rootRecords.Files, false, nil)
+	appendProcesses(rootRecords.Processes, false, nil)
+	db.ReplaceAppraisalStatisticsForProcess(process.ProcessID, entries)
+}
+
+// BackfillAppraisalStatistics saves appraisal statistics for all processes
+// with finalized appraisals that have none yet.
+func BackfillAppraisalStatistics() {
+	for _, process := range db.FindProcesses(context.Background()) {
+		if !process.ProcessState.Appraisal.Complete ||
+			db.HasAppraisalStatisticsForProcess(context.Background(), process.ProcessID) {
+			continue
+		}
+		message, ok := db.TryFindMessage(context.Background(), process.ProcessID, db.MessageType0501)
+		if !ok {
+			continue
+		}
+		log.Printf("Saving appraisal statistics for process %s\n", process.ProcessID)
+		saveAppraisalStatistics(process, message)
+	}
+}
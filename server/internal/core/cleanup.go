@@ -7,6 +7,7 @@ import (
 	"lath/xman/internal/tasks"
 	"log"
 	"os"
+	"slices"
 )
 
 // DeleteProcess deletes the given process from the database and removes all
@@ -20,6 +21,14 @@ func DeleteProcess(processID string) bool {
 	}
 	storeDir := process.StoreDir
 	transferFiles := getAllTransferFilesOfProcess(process)
+	// Uploaded messages are not in the transfer directory. A file with the
+	// same name in the transfer directory must not be removed.
+	var uploadedFiles []string
+	for _, m := range db.FindMessagesForProcess(context.Background(), processID) {
+		if m.Upload != nil {
+			uploadedFiles = append(uploadedFiles, m.TransferFile)
+		}
+	}
 	log.Println("Deleting process", processID)
 	// Cancel running tasks
 	tasks.CancelAndDeleteTasksForProcess(processID, nil)
@@ -28,6 +37,7 @@ func DeleteProcess(processID string) bool {
 	db.DeleteMessagesForProcess(processID)
 	db.DeleteRecordsForProcess(processID)
 	db.DeletePrimaryDocumentsDataForProcess(processID)
+	db.DeletePrimaryDocumentTextsForProcess(processID)
 	db.DeleteAppraisalsForProcess(processID)
 	db.DeletePackagingChoicesForProcess(processID)
 	db.DeleteArchivePackagesForProcess(processID)
@@ -39,7 +49,11 @@ func DeleteProcess(processID string) bool {
 	}
 	// Delete transfer files
 	for _, path := range transferFiles {
-		RemoveFileFromTransferDir(process.Agency, path)
+		if slices.Contains(uploadedFiles, path) {
+			db.DeleteTransferFile(process.Agency.ID, path)
+		} else {
+			RemoveFileFromTransferDir(process.Agency, path)
+		}
 	}
 	return true
 }
@@ -62,6 +76,7 @@ func DeleteMessage(processID string, messageType db.MessageType, keepTransferFil
 	case db.MessageType0503:
 		taskTypes[db.ProcessStepFormatVerification] = true
 		taskTypes[db.ProcessStepArchiving] = true
+		taskTypes[db.ProcessStepTextExtraction] = true
 	}
 	tasks.CancelAndDeleteTasksForProcess(processID, taskTypes)
 	// Delete database entries
@@ -69,6 +84,7 @@ func DeleteMessage(processID string, messageType db.MessageType, keepTransferFil
 	db.DeleteRecordsForMessage(message.MessageHead.ProcessID, message.MessageType)
 	if message.MessageType == db.MessageType0503 {
 		db.DeletePrimaryDocumentsDataForProcess(message.MessageHead.ProcessID)
+		db.DeletePrimaryDocumentTextsForProcess(message.MessageHead.ProcessID)
 	}
 	// Reset process step
 	var processStepType db.ProcessStepType
@@ -93,7 +109,13 @@ func DeleteMessage(processID string, messageType db.MessageType, keepTransferFil
 	if keepTransferFile {
 		db.DeleteTransferFile(process.Agency.ID, transferFile)
 	} else {
-		RemoveFileFromTransferDir(process.Agency, transferFile)
+		if message.Upload != nil {
+			// Uploaded messages are not in the transfer directory.
+			db.DeleteTransferFile(process.Agency.ID, transferFile)
+			removeUploadedFile(message.Upload.StorePath)
+		} else {
+			RemoveFileFromTransferDir(process.Agency, transferFile)
+		}
 		cleanupEmptyProcess(message.MessageHead.ProcessID)
 	}
 	db.DeleteWarningsForMessage(processID, message.MessageType)
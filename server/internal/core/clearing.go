@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"lath/xman/internal/db"
 	"lath/xman/internal/tasks"
@@ -22,11 +23,22 @@ func Resolve(e db.ProcessingError, r db.ProcessingErrorResolution, user string)
 	case db.ErrorResolutionRetryTask:
 		err = tasks.Action(*e.TaskID, db.TaskActionRetry)
 	case db.ErrorResolutionReimportMessage:
-		err = DeleteMessage(*e.ProcessID, e.MessageType, true)
+		if e.ErrorType == "changed-transfer-file" {
+			err = reimportTransferFile(e)
+		} else if m, ok := db.FindMessage(context.Background(), *e.ProcessID, e.MessageType); ok && m.Upload != nil {
+			err = reimportUploadedMessage(m)
+		} else {
+			err = DeleteMessage(*e.ProcessID, e.MessageType, true)
+		}
 	case db.ErrorResolutionDeleteMessage:
 		err = DeleteMessage(*e.ProcessID, e.MessageType, false)
 	case db.ErrorResolutionDeleteTransferFile:
-		RemoveFileFromTransferDir(*e.Agency, e.TransferPath)
+		if e.QuarantineID != nil {
+			// The transfer file has been moved to quarantine.
+			err = PurgeQuarantinedFile(context.Background(), *e.QuarantineID)
+		} else {
+			RemoveFileFromTransferDir(*e.Agency, e.TransferPath)
+		}
 	case db.ErrorResolutionIgnoreTransferFile:
 		for _, f := range e.Data.(primitive.A) {
 			db.InsertTransferFile(e.Agency.ID, nil, f.(string))
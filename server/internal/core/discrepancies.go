@@ -21,13 +21,22 @@ type recordMap map[string]recordNode
 // appraisableID returns the record ID of the record of whose appraisal decision
 // is relevant for the given record. In case of file and process records, this
 // is the record itself. In case of documents, it is the nearest appraisable
-// parent.
-func (m recordMap) appraisableID(recordID string) string {
+// parent, or with appraisal level "document", the document itself. For
+// attachments, it is the appraisable ID of the attached document. Root-level
+// records without an appraisable parent return "root".
+func (m recordMap) appraisableID(recordID string, level db.AppraisalLevel) string {
 	for {
 		r := m[recordID]
 		if r.Type != db.RecordTypeDocument {
 			return recordID
 		}
+		if level == db.AppraisalLevelDocument &&
+			(r.Parent == nil || m[*r.Parent].Type != db.RecordTypeDocument) {
+			return recordID
+		}
+		if r.Parent == nil {
+			return "root"
+		}
 		recordID = *r.Parent
 	}
 }
@@ -90,6 +99,7 @@ func FindDiscrepancies(
 	// Gather data
 	var result Discrepancies
 	processID := message0501.MessageHead.ProcessID
+	level := appraisalLevelForProcess(processID)
 	appraisals := make(map[string]db.Appraisal)
 	// represents the root record and is implicitly marked 'A'.
 	appraisals["root"] = db.Appraisal{Decision: db.AppraisalDecisionA}
@@ -111,8 +121,8 @@ L1:
 			// Not missing.
 			continue
 		}
-		appraisal := appraisals[appraisedRecords.appraisableID(id)]
-		if appraisal.Decision != db.AppraisalDecisionA {
+		appraisal := appraisals[appraisedRecords.appraisableID(id, level)]
+		if !appraisal.Decision.ToBeArchived() {
 			// Not missing.
 			continue
 		}
@@ -129,8 +139,8 @@ L1:
 	// Check for surplus objects in the 0503 message
 L2:
 	for id, r := range submittedRecords {
-		appraisal := appraisals[submittedRecords.appraisableID(id)]
-		if appraisal.Decision == db.AppraisalDecisionA {
+		appraisal := appraisals[submittedRecords.appraisableID(id, level)]
+		if appraisal.Decision.ToBeArchived() {
 			// Not surplus.
 			continue
 		}
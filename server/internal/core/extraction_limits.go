@@ -0,0 +1,196 @@
+package core
+
+import (
+	"archive/zip"
+	"fmt"
+	"lath/xman/internal/db"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// defaultMaxMessageSizeMB is the maximum total uncompressed size of a
+	// message archive if not configured otherwise.
+	defaultMaxMessageSizeMB = 100 * 1024
+	// defaultMaxCompressionRatio is the maximum compression ratio of entries
+	// of a message archive if not configured otherwise.
+	defaultMaxCompressionRatio = 100
+	// defaultMinFreeDiskSpaceMB is the disk space that has to remain free
+	// after extracting a message if not configured otherwise.
+	defaultMinFreeDiskSpaceMB = 1024
+	// compressionRatioMinSize is the uncompressed size from which on the
+	// compression ratio of an entry is checked. Small files like XML files
+	// with repetitive content can have high compression ratios without posing
+	// a risk.
+	compressionRatioMinSize = 1 << 20
+)
+
+// ExtractionLimits restrict the extraction of message archives.
+type ExtractionLimits struct {
+	// MaxSize is the maximum total uncompressed size of all entries in bytes.
+	MaxSize uint64
+	// MaxCompressionRatio is the maximum ratio of uncompressed to compressed
+	// size of a single entry.
+	MaxCompressionRatio uint64
+	// MinFreeDiskSpace is the disk space in bytes that has to remain free in
+	// the message store after extraction.
+	MinFreeDiskSpace uint64
+}
+
+// extractionLimitsForAgency returns the extraction limits that apply to
+// messages of the given agency.
+//
+// Limits set for the agency take precedence over the global configuration.
+func extractionLimitsForAgency(agency db.Agency) ExtractionLimits {
+	maxSizeMB := envUint("MAX_MESSAGE_SIZE_MB", defaultMaxMessageSizeMB)
+	if agency.MaxMessageSizeMB > 0 {
+		maxSizeMB = uint64(agency.MaxMessageSizeMB)
+	}
+	maxRatio := envUint("MAX_COMPRESSION_RATIO", defaultMaxCompressionRatio)
+	if agency.MaxCompressionRatio > 0 {
+		maxRatio = uint64(agency.MaxCompressionRatio)
+	}
+	return ExtractionLimits{
+		MaxSize:             maxSizeMB << 20,
+		MaxCompressionRatio: maxRatio,
+		MinFreeDiskSpace:    envUint("MIN_FREE_DISK_SPACE_MB", defaultMinFreeDiskSpaceMB) << 20,
+	}
+}
+
+func envUint(key string, defaultValue uint64) uint64 {
+	s := os.Getenv(key)
+	if s == "" {
+		return defaultValue
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid value for %s: %s", key, s))
+	}
+	return v
+}
+
+// unsafeArchiveEntry describes an entry of a message archive that violates
+// the extraction safeguards.
+type unsafeArchiveEntry struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// checkArchiveEntries checks the entries of a message archive before
+// extraction.
+//
+// names are the decoded file names of the entries. storeDir is the directory
+// to which the message will be extracted and is used to determine the free
+// disk space.
+//
+// It returns a processing error listing all offending entries if any entry is
+// unsafe to extract or if the archive as a whole exceeds the limits.
+func checkArchiveEntries(
+	files []*zip.File,
+	names []string,
+	storeDir string,
+	limits ExtractionLimits,
+) error {
+	var unsafeEntries []unsafeArchiveEntry
+	var totalSize uint64
+	for i, f := range files {
+		name := names[i]
+		if isUnsafePath(name) {
+			unsafeEntries = append(unsafeEntries, unsafeArchiveEntry{
+				Name:   name,
+				Reason: "absoluter Pfad oder Verweis auf übergeordnetes Verzeichnis",
+			})
+		}
+		if f.UncompressedSize64 >= compressionRatioMinSize &&
+			f.UncompressedSize64 > limits.MaxCompressionRatio*max(f.CompressedSize64, 1) {
+			unsafeEntries = append(unsafeEntries, unsafeArchiveEntry{
+				Name: name,
+				Reason: fmt.Sprintf(
+					"Kompressionsrate %d:1 überschreitet das Maximum von %d:1",
+					f.UncompressedSize64/max(f.CompressedSize64, 1), limits.MaxCompressionRatio,
+				),
+			})
+		}
+		totalSize += f.UncompressedSize64
+	}
+	var problems []string
+	if totalSize > limits.MaxSize {
+		problems = append(problems, fmt.Sprintf(
+			"Die entpackte Größe von %d MB überschreitet das Maximum von %d MB.",
+			totalSize>>20, limits.MaxSize>>20,
+		))
+	}
+	if free, err := freeDiskSpace(storeDir); err != nil {
+		panic(err)
+	} else if free < totalSize+limits.MinFreeDiskSpace {
+		problems = append(problems, fmt.Sprintf(
+			"Nicht genügend freier Speicherplatz: %d MB benötigt, %d MB verfügbar, %d MB müssen frei bleiben.",
+			totalSize>>20, free>>20, limits.MinFreeDiskSpace>>20,
+		))
+	}
+	if len(unsafeEntries) == 0 && len(problems) == 0 {
+		return nil
+	}
+	return unsafeArchiveError(unsafeEntries, problems)
+}
+
+// unsafeArchiveError returns a processing error for a message archive that
+// violates the extraction safeguards.
+func unsafeArchiveError(unsafeEntries []unsafeArchiveEntry, problems []string) *db.ProcessingError {
+	parts := []string{"Die Nachricht wurde aus Sicherheitsgründen nicht entpackt."}
+	parts = append(parts, problems...)
+	if len(unsafeEntries) > 0 {
+		entries := "Betroffene Einträge:"
+		for _, e := range unsafeEntries {
+			entries += fmt.Sprintf("\n%s: %s", e.Name, e.Reason)
+		}
+		parts = append(parts, entries)
+	}
+	return &db.ProcessingError{
+		Title:     "Unsichere ZIP-Datei",
+		ErrorType: "unsafe-message-archive",
+		Info:      strings.Join(parts, "\n\n"),
+		Data:      unsafeEntries,
+	}
+}
+
+// isUnsafePath returns true if the given archive entry name is an absolute path
+// or refers to a parent directory.
+func isUnsafePath(name string) bool {
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") {
+		return true
+	}
+	// Windows drive letter, e.g., "C:".
+	if len(name) >= 2 && name[1] == ':' {
+		return true
+	}
+	for _, element := range strings.FieldsFunc(name, func(r rune) bool {
+		return r == '/' || r == '\\'
+	}) {
+		if element == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// freeDiskSpace returns the number of bytes available to unprivileged users on
+// the file system of the given directory.
+//
+// If the directory doesn't exist, the nearest existing parent directory is
+// used.
+func freeDiskSpace(dir string) (uint64, error) {
+	for {
+		var stat syscall.Statfs_t
+		err := syscall.Statfs(dir, &stat)
+		if err == nil {
+			return stat.Bavail * uint64(stat.Bsize), nil
+		} else if !os.IsNotExist(err) || dir == "." || dir == "/" {
+			return 0, err
+		}
+		dir = path.Dir(dir)
+	}
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"archive/zip"
+	"errors"
+	"lath/xman/internal/db"
+	"slices"
+	"testing"
+)
+
+func TestIsUnsafePath(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"message.xml", false},
+		{"dir/file.pdf", false},
+		{"dir\\file.pdf", false},
+		{"..file.pdf", false},
+		{"dir/..file/file.pdf", false},
+		{"/etc/passwd", true},
+		{"\\windows\\file.pdf", true},
+		{"C:file.pdf", true},
+		{"c:\\file.pdf", true},
+		{"../file.pdf", true},
+		{"dir/../../file.pdf", true},
+		{"dir\\..\\file.pdf", true},
+		{"dir/..", true},
+	}
+	for _, tt := range tests {
+		if got := isUnsafePath(tt.name); got != tt.want {
+			t.Errorf("isUnsafePath(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckArchiveEntries(t *testing.T) {
+	limits := ExtractionLimits{
+		MaxSize:             10 << 20,
+		MaxCompressionRatio: 100,
+	}
+	entry := func(uncompressed, compressed uint64) *zip.File {
+		return &zip.File{FileHeader: zip.FileHeader{
+			UncompressedSize64: uncompressed,
+			CompressedSize64:   compressed,
+		}}
+	}
+	tests := []struct {
+		name        string
+		files       []*zip.File
+		names       []string
+		limits      ExtractionLimits
+		wantErr     bool
+		wantEntries []string
+	}{
+		{
+			name:   "safe archive",
+			files:  []*zip.File{entry(1000, 500), entry(2<<20, 1<<20)},
+			names:  []string{"message.xml", "dir/file.pdf"},
+			limits: limits,
+		},
+		{
+			name:        "unsafe paths",
+			files:       []*zip.File{entry(10, 10), entry(10, 10), entry(10, 10)},
+			names:       []string{"../file.pdf", "message.xml", "/file.pdf"},
+			limits:      limits,
+			wantErr:     true,
+			wantEntries: []string{"../file.pdf", "/file.pdf"},
+		},
+		{
+			name:   "high ratio of small entry",
+			files:  []*zip.File{entry(1<<20-1, 1)},
+			names:  []string{"message.xml"},
+			limits: limits,
+		},
+		{
+			name:   "ratio at maximum",
+			files:  []*zip.File{entry(100<<20, 1<<20)},
+			names:  []string{"file.pdf"},
+			limits: ExtractionLimits{MaxSize: 1 << 30, MaxCompressionRatio: 100},
+		},
+		{
+			name:        "ratio above maximum",
+			files:       []*zip.File{entry(1<<20, 1<<10), entry(1<<20, 1<<19)},
+			names:       []string{"bomb.pdf", "file.pdf"},
+			limits:      limits,
+			wantErr:     true,
+			wantEntries: []string{"bomb.pdf"},
+		},
+		{
+			name:        "empty compressed size",
+			files:       []*zip.File{entry(1<<20, 0)},
+			names:       []string{"bomb.pdf"},
+			limits:      limits,
+			wantErr:     true,
+			wantEntries: []string{"bomb.pdf"},
+		},
+		{
+			name:   "total size at maximum",
+			files:  []*zip.File{entry(5<<20, 1<<20), entry(5<<20, 1<<20)},
+			names:  []string{"a.pdf", "b.pdf"},
+			limits: limits,
+		},
+		{
+			name:    "total size above maximum",
+			files:   []*zip.File{entry(5<<20, 1<<20), entry(5<<20+1, 1<<20)},
+			names:   []string{"a.pdf", "b.pdf"},
+			limits:  limits,
+			wantErr: true,
+		},
+		{
+			name:    "not enough free disk space",
+			files:   []*zip.File{entry(1000, 500)},
+			names:   []string{"message.xml"},
+			limits:  ExtractionLimits{MaxSize: 10 << 20, MaxCompressionRatio: 100, MinFreeDiskSpace: 1 << 62},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkArchiveEntries(tt.files, tt.names, t.TempDir(), tt.limits)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkArchiveEntries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			var e *db.ProcessingError
+			if !errors.As(err, &e) {
+				t.Fatalf("checkArchiveEntries() error = %T, want *db.ProcessingError", err)
+			}
+			var names []string
+			for _, entry := range e.Data.([]unsafeArchiveEntry) {
+				names = append(names, entry.Name)
+			}
+			if !slices.Equal(names, tt.wantEntries) {
+				t.Errorf("checkArchiveEntries() unsafe entries = %v, want %v", names, tt.wantEntries)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"lath/xman/internal/db"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/lestrrat-go/libxml2/xsd"
@@ -65,6 +66,7 @@ type generatorMessageHead0502 struct {
 	Sender           generatorContact       `xml:"xdomea:Absender"`
 	Receiver         generatorContact       `xml:"xdomea:Empfaenger"`
 	SendingSystem    generatorSendingSystem `xml:"xdomea:SendendesSystem"`
+	Note             string                 `xml:"xdomea:Hinweis,omitempty"`
 	ReceiptRequested bool                   `xml:"xdomea:Empfangsbestaetigung"`
 }
 
@@ -133,11 +135,12 @@ func Generate0502Message(message db.Message) string {
 	rootRecords := db.FindAllRootRecords(
 		context.Background(), message.MessageHead.ProcessID, message.MessageType,
 	)
-	m := AppraisableRecords(&rootRecords)
+	m := AppraisableRecords(&rootRecords, appraisalLevelForProcess(message.MessageHead.ProcessID))
 	for id := range m {
 		appraisedObject := generateAppraisedObject(messageHead.ProcessID, id, xdomeaVersion)
 		message0502.AppraisedObjects = append(message0502.AppraisedObjects, appraisedObject)
 	}
+	message0502.MessageHead.Note = publicAppraisalNotes(messageHead.ProcessID, &rootRecords, m)
 	xmlBytes, err := xml.MarshalIndent(message0502, " ", " ")
 	if err != nil {
 		panic("0502 message couldn't be created")
@@ -301,18 +304,24 @@ func generateAppraisedObject(
 ) generatorAppraisedObject {
 	var appraisedObject generatorAppraisedObject
 	a, _ := db.FindAppraisal(processID, recordID)
-	if a.Decision != "A" && a.Decision != "V" {
+	if !a.Decision.IsComplete() {
 		panic(fmt.Sprintf("called GenerateAppraisedObject with appraisal \"%s\": %v", a.Decision, recordID))
 	}
+	// Records selected by a sample are communicated as "A" since the 0502
+	// message only knows "A" and "V".
+	decision := a.Decision
+	if decision == db.AppraisalDecisionB {
+		decision = db.AppraisalDecisionA
+	}
 
 	var objectAppraisal generatorObjectAppraisal
 	if isVersionPriorTo300(xdomeaVersion.Code) {
 		objectAppraisal = generatorObjectAppraisal{
-			AppraisalCodePre300: string(a.Decision),
+			AppraisalCodePre300: string(decision),
 		}
 	} else {
 		appraisalCode := generatorCode{
-			Code: string(a.Decision),
+			Code: string(decision),
 		}
 		objectAppraisal = generatorObjectAppraisal{
 			AppraisalCode: &appraisalCode,
@@ -325,6 +334,62 @@ func generateAppraisedObject(
 	return appraisedObject
 }
 
+// publicAppraisalNotes returns the public appraisal notes of all appraisable
+// records in the order they appear in the message, one line per record.
+//
+// None of the supported xdomea versions provides a note field for appraised
+// objects, so the notes are sent in the free-text note of the message head.
+// Internal notes are never included.
+func publicAppraisalNotes(
+	processID string,
+	rootRecords *db.RootRecords,
+	m AppraisableRecordsMap,
+) string {
+	appraisals := make(map[string]db.Appraisal)
+	for _, a := range db.FindAppraisalsForProcess(context.Background(), processID) {
+		appraisals[a.RecordID] = a
+	}
+	var lines []string
+	appendLine := func(recordID, title string) {
+		if _, ok := m[recordID]; !ok {
+			return
+		}
+		note := strings.TrimSpace(appraisals[recordID].PublicNote)
+		if note != "" {
+			lines = append(lines, fmt.Sprintf("%s (%s): %s", title, recordID, note))
+		}
+	}
+	var appendFiles func(files []db.FileRecord, isSubFile bool)
+	var appendProcesses func(processes []db.ProcessRecord, isSubProcess bool)
+	appendDocuments := func(documents []db.DocumentRecord) {
+		for _, d := range documents {
+			appendLine(d.RecordID, DocumentRecordTitle(d, false))
+		}
+	}
+	appendFiles = func(files []db.FileRecord, isSubFile bool) {
+		for _, f := range files {
+			appendLine(f.RecordID, FileRecordTitle(f, isSubFile))
+			appendFiles(f.Subfiles, true)
+			appendProcesses(f.Processes, false)
+			appendDocuments(f.Documents)
+		}
+	}
+	appendProcesses = func(processes []db.ProcessRecord, isSubProcess bool) {
+		for _, p := range processes {
+			appendLine(p.RecordID, ProcessRecordTitle(p, isSubProcess))
+			appendProcesses(p.Subprocesses, true)
+			appendDocuments(p.Documents)
+		}
+	}
+	appendFiles(rootRecords.Files, false)
+	appendProcesses(rootRecords.Processes, false)
+	appendDocuments(rootRecords.Documents)
+	if len(lines) == 0 {
+		return ""
+	}
+	return "Begründungen der Bewertung:\n" + strings.Join(lines, "\n")
+}
+
 func getArchivingInfoPre300(archivePackages []db.ArchivePackage) generatorArchivingInfoPre300 {
 	info := generatorArchivingInfoPre300{
 		Success: true,
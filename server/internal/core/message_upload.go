@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"lath/xman/internal/auth"
+	"lath/xman/internal/db"
+	"lath/xman/internal/errors"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrInvalidMessageName = fmt.Errorf("file name is not a valid name for a 0501, 0503, or 0505 message")
+	ErrMessageExists      = fmt.Errorf("message exists")
+)
+
+// UploadMessage imports a message that was uploaded manually by an
+// administrator for the given agency.
+//
+// The uploaded transfer file is kept in the message store, so the message can
+// be reimported later. It is never written to the agency's transfer directory.
+//
+// The message is processed in the background like a message found in the
+// agency's transfer directory. Problems found while processing are reported as
+// processing errors. An error is returned right away if the file name is not a
+// valid message name or the message already exists.
+func UploadMessage(agency db.Agency, filename string, content io.Reader, userID string) error {
+	filename = filepath.Base(filename)
+	if !isMessage(filename) {
+		return ErrInvalidMessageName
+	}
+	processID := getProcessID(filename)
+	messageType, err := getMessageTypeImpliedByPath(filename)
+	if err != nil {
+		panic(err)
+	}
+	if _, ok := db.TryFindMessage(context.Background(), processID, messageType); ok {
+		return ErrMessageExists
+	}
+	upload := db.MessageUpload{
+		UploadedAt: time.Now(),
+		UserID:     userID,
+		UserName:   auth.GetDisplayName(userID),
+	}
+	return storeUploadedMessage(agency, filename, content, upload)
+}
+
+// storeUploadedMessage saves an uploaded transfer file in the message store
+// and imports it in the background.
+func storeUploadedMessage(agency db.Agency, filename string, content io.Reader, upload db.MessageUpload) error {
+	processID := getProcessID(filename)
+	// Claim the file name before writing the file, so concurrent uploads with
+	// the same name can't overwrite each other. This also keeps a file with
+	// the same name in the transfer directory from being imported again.
+	if !db.InsertTransferFile(agency.ID, &processID, filename) {
+		return ErrMessageExists
+	}
+	storePath := path.Join("message_store", processID, "uploads", filename)
+	if err := os.MkdirAll(path.Dir(storePath), 0700); err != nil {
+		db.DeleteTransferFile(agency.ID, filename)
+		panic(err)
+	}
+	f, err := os.Create(storePath)
+	if err != nil {
+		db.DeleteTransferFile(agency.ID, filename)
+		panic(err)
+	}
+	_, err = io.Copy(f, content)
+	f.Close()
+	if err != nil {
+		removeUploadedFile(storePath)
+		db.DeleteTransferFile(agency.ID, filename)
+		return err
+	}
+	upload.StorePath = storePath
+	go importUploadedMessage(agency, filename, upload)
+	return nil
+}
+
+// importUploadedMessage processes the uploaded transfer file kept in the
+// message store.
+//
+// The file is removed again if the message could not be imported.
+func importUploadedMessage(agency db.Agency, filename string, upload db.MessageUpload) {
+	defer errors.HandlePanic("importUploadedMessage", &db.ProcessingError{
+		Agency:       &agency,
+		TransferPath: filename,
+	})
+	processID := getProcessID(filename)
+	messageType, err := getMessageTypeImpliedByPath(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if _, ok := db.TryFindMessage(context.Background(), processID, messageType); !ok {
+			removeUploadedFile(upload.StorePath)
+		}
+	}()
+	log.Printf("Processing message %s uploaded by %s\n", filename, upload.UserName)
+	processMessage(agency, filename, upload.StorePath, &upload)
+}
+
+// reimportUploadedMessage deletes the given uploaded message and imports it
+// again from the transfer file kept in the message store.
+func reimportUploadedMessage(message db.Message) error {
+	processID := message.MessageHead.ProcessID
+	if message.Upload.StorePath == "" {
+		return fmt.Errorf("uploaded transfer file of %s message not available for process %s",
+			message.MessageType, processID)
+	}
+	process, ok := db.FindProcess(context.Background(), processID)
+	if !ok {
+		return fmt.Errorf("process not found: %s", processID)
+	}
+	if err := DeleteMessage(processID, message.MessageType, true); err != nil {
+		return err
+	}
+	if !db.InsertTransferFile(process.Agency.ID, &processID, message.TransferFile) {
+		return ErrMessageExists
+	}
+	go importUploadedMessage(process.Agency, message.TransferFile, *message.Upload)
+	return nil
+}
+
+// removeUploadedFile deletes an uploaded transfer file from the message store
+// together with its directories if they are empty.
+func removeUploadedFile(storePath string) {
+	if storePath == "" {
+		return
+	}
+	if err := os.Remove(storePath); err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	// Remove the directories unless they hold other files.
+	os.Remove(path.Dir(storePath))
+	os.Remove(path.Dir(path.Dir(storePath)))
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"lath/xman/internal/db"
+	"slices"
 )
 
 // PackagingDecision is the computed decision of how to package a record, based
@@ -56,22 +57,33 @@ func Packaging(processID string) (
 	for _, c := range db.FindPackagingChoicesForProcess(context.Background(), processID) {
 		choices[c.RecordID] = c.PackagingChoice
 	}
-	rootRecords := db.FindAllRootRecords(context.Background(), processID, db.MessageType0503)
+	roots := recordTreeToArchive(processID)
 	decisions = make(map[string]PackagingDecision)
 	stats = make(map[string]PackagingStats)
-	for _, f := range rootRecords.Files {
+	root := &treeNode{Children: roots}
+	for _, f := range root.childrenOfType(db.RecordTypeFile) {
 		stats[f.RecordID] = packagingFileRecord(f, db.PackagingChoiceRoot, 0, choices, decisions)
 	}
 	// Add an entry for the message root to the stats map, so stats are included
 	// when calculating the combined stats.
 	stats["root"] = PackagingStats{
-		Processes: packagingProcessRecords(rootRecords.Processes, decisions),
-		Other:     packagingDocumentRecords(rootRecords.Documents),
+		Processes: packagingProcessRecords(root.childrenOfType(db.RecordTypeProcess), decisions),
+		Other:     packagingDocumentRecords(root.childrenOfType(db.RecordTypeDocument)),
 	}
 	return decisions, stats, choices
 }
 
-func PackagingStatsForChoices(rootRecords []db.FileRecord) map[db.PackagingChoice]PackagingStats {
+// PackagingStatsForChoices returns the packaging stats for each available
+// packaging choice, if applied to all given root file records of the 0503
+// message of the given process.
+func PackagingStatsForChoices(processID string, recordIDs []string) map[db.PackagingChoice]PackagingStats {
+	roots, _ := recordTree(context.Background(), processID, db.MessageType0503)
+	var files []*treeNode
+	for _, n := range roots {
+		if n.RecordType == db.RecordTypeFile && slices.Contains(recordIDs, n.RecordID) {
+			files = append(files, n)
+		}
+	}
 	result := make(map[db.PackagingChoice]PackagingStats)
 	dummyDecisions := make(map[string]PackagingDecision)
 	for _, c := range []db.PackagingChoice{
@@ -80,7 +92,7 @@ func PackagingStatsForChoices(rootRecords []db.FileRecord) map[db.PackagingChoic
 		db.PackagingChoiceLevel2,
 	} {
 		var stats PackagingStats
-		for _, f := range rootRecords {
+		for _, f := range files {
 			stats.add(packagingFileRecord(f, c, 0, nil, dummyDecisions))
 		}
 		result[c] = stats
@@ -92,7 +104,7 @@ func PackagingStatsForChoices(rootRecords []db.FileRecord) map[db.PackagingChoic
 // given file record. Additionally, it returns statistics about the number of
 // packages to be created for the respective record types.
 func packagingFileRecord(
-	record db.FileRecord,
+	record *treeNode,
 	choice db.PackagingChoice,
 	level int,
 	choices map[string]db.PackagingChoice,
@@ -110,16 +122,16 @@ func packagingFileRecord(
 		return PackagingStats{Subfiles: 1, DeepestLevelHasItems: true}
 	case level < choiceLevel(choice):
 		var stats PackagingStats
-		for _, f := range record.Subfiles {
+		for _, f := range record.childrenOfType(db.RecordTypeFile) {
 			stats.add(packagingFileRecord(f, choice, level+1, choices, decisions))
 		}
-		stats.Processes += packagingProcessRecords(record.Processes, decisions)
+		stats.Processes += packagingProcessRecords(record.childrenOfType(db.RecordTypeProcess), decisions)
 		if stats.Total() == 0 {
 			decisions[record.RecordID] = PackagingDecisionSingle
 			return PackagingStats{Subfiles: 1, DeepestLevelHasItems: false}
 		}
 		decisions[record.RecordID] = PackagingDecisionSub
-		stats.Other += packagingDocumentRecords(record.Documents)
+		stats.Other += packagingDocumentRecords(record.childrenOfType(db.RecordTypeDocument))
 		stats.DeepestLevelHasItems = stats.DeepestLevelHasItems || level+1 == choiceLevel(choice)
 		return stats
 	default:
@@ -143,7 +155,7 @@ func choiceLevel(c db.PackagingChoice) int {
 // processFileRecordsForSubPackaging calculates the packaging decisions for the
 // given process records. Returns the number of packages to be created.
 func packagingProcessRecords(
-	records []db.ProcessRecord,
+	records []*treeNode,
 	decisions map[string]PackagingDecision,
 ) (nPackages int) {
 	for _, p := range records {
@@ -158,7 +170,7 @@ func packagingProcessRecords(
 // packagingDocumentRecords returns the number of packages to be
 // created for document records that are not already part of another package.
 func packagingDocumentRecords(
-	records []db.DocumentRecord,
+	records []*treeNode,
 ) (nPackages int) {
 	// Loose documents will be collected into a single archive package.
 	if len(records) > 0 {
@@ -167,3 +179,86 @@ func packagingDocumentRecords(
 		return 0
 	}
 }
+
+// recordTreeToArchive returns the record tree of the 0503 message of the given
+// process without any documents that have been appraised "V".
+//
+// It is the equivalent of RootRecordsToArchive that uses the record index
+// instead of loading all records.
+func recordTreeToArchive(processID string) []*treeNode {
+	ctx := context.Background()
+	roots, _ := recordTree(ctx, processID, db.MessageType0503)
+	if appraisalLevelForProcess(processID) != db.AppraisalLevelDocument {
+		return roots
+	}
+	decisions := appraisalDecisions(ctx, processID)
+	var filter func(nodes []*treeNode) []*treeNode
+	filter = func(nodes []*treeNode) []*treeNode {
+		var result []*treeNode
+		for _, n := range nodes {
+			if n.RecordType == db.RecordTypeDocument && decisions[n.RecordID] == db.AppraisalDecisionV {
+				continue
+			}
+			if n.RecordType != db.RecordTypeDocument {
+				n.Children = filter(n.Children)
+			}
+			result = append(result, n)
+		}
+		return result
+	}
+	return filter(roots)
+}
+
+// RootRecordsToArchive returns the root records of the 0503 message of the
+// given process without any documents that have been appraised "V".
+//
+// Documents can only be appraised with the appraisal level "document". The IDs
+// of all removed documents, including their attachments, are returned as
+// discardedIDs.
+func RootRecordsToArchive(processID string) (rootRecords db.RootRecords, discardedIDs []string) {
+	rootRecords = db.FindAllRootRecords(context.Background(), processID, db.MessageType0503)
+	if appraisalLevelForProcess(processID) != db.AppraisalLevelDocument {
+		return rootRecords, nil
+	}
+	appraisals := make(map[string]db.AppraisalDecisionOption)
+	for _, a := range db.FindAppraisalsForProcess(context.Background(), processID) {
+		appraisals[a.RecordID] = a.Decision
+	}
+	var appendDiscarded func(d db.DocumentRecord)
+	appendDiscarded = func(d db.DocumentRecord) {
+		discardedIDs = append(discardedIDs, d.RecordID)
+		for _, a := range d.Attachments {
+			appendDiscarded(a)
+		}
+	}
+	filterDocuments := func(documents []db.DocumentRecord) []db.DocumentRecord {
+		var result []db.DocumentRecord
+		for _, d := range documents {
+			if appraisals[d.RecordID] == db.AppraisalDecisionV {
+				appendDiscarded(d)
+			} else {
+				result = append(result, d)
+			}
+		}
+		return result
+	}
+	var filterFiles func(files []db.FileRecord)
+	var filterProcesses func(processes []db.ProcessRecord)
+	filterFiles = func(files []db.FileRecord) {
+		for i := range files {
+			filterFiles(files[i].Subfiles)
+			filterProcesses(files[i].Processes)
+			files[i].Documents = filterDocuments(files[i].Documents)
+		}
+	}
+	filterProcesses = func(processes []db.ProcessRecord) {
+		for i := range processes {
+			filterProcesses(processes[i].Subprocesses)
+			processes[i].Documents = filterDocuments(processes[i].Documents)
+		}
+	}
+	filterFiles(rootRecords.Files)
+	filterProcesses(rootRecords.Processes)
+	rootRecords.Documents = filterDocuments(rootRecords.Documents)
+	return rootRecords, discardedIDs
+}
@@ -9,43 +9,17 @@ import (
 	"os"
 )
 
-type message0501 struct {
-	XMLName         xml.Name            `xml:"Aussonderung.Anbieteverzeichnis.0501"`
-	MessageHead     db.MessageHead      `xml:"Kopf"`
-	FileRecords     []db.FileRecord     `xml:"Schriftgutobjekt>Akte"`
-	ProcessRecords  []db.ProcessRecord  `xml:"Schriftgutobjekt>Vorgang"`
-	DocumentRecords []db.DocumentRecord `xml:"Schriftgutobjekt>Dokument"`
-}
-
-type messageBody0501 struct {
-	XMLName xml.Name `xml:"Aussonderung.Anbieteverzeichnis.0501"`
-}
-
-type message0503 struct {
-	XMLName         xml.Name            `xml:"Aussonderung.Aussonderung.0503"`
-	MessageHead     db.MessageHead      `xml:"Kopf"`
-	FileRecords     []db.FileRecord     `xml:"Schriftgutobjekt>Akte"`
-	ProcessRecords  []db.ProcessRecord  `xml:"Schriftgutobjekt>Vorgang"`
-	DocumentRecords []db.DocumentRecord `xml:"Schriftgutobjekt>Dokument"`
-}
-
-type messageBody0503 struct {
-	XMLName xml.Name `xml:"Aussonderung.Aussonderung.0503"`
-}
-
-type message0505 struct {
-	XMLName     xml.Name       `xml:"Aussonderung.BewertungEmpfangBestaetigen.0505"`
-	MessageHead db.MessageHead `xml:"Kopf"`
-}
-
-type messageBody0505 struct {
-	XMLName xml.Name `xml:"Aussonderung.BewertungEmpfangBestaetigen.0505"`
+// messageElementNames maps message types to the local names of the root
+// elements of the respective messages.
+var messageElementNames = map[db.MessageType]string{
+	db.MessageType0501: "Aussonderung.Anbieteverzeichnis.0501",
+	db.MessageType0503: "Aussonderung.Aussonderung.0503",
+	db.MessageType0505: "Aussonderung.BewertungEmpfangBestaetigen.0505",
 }
 
 type parsedMessage struct {
 	MessageHead   db.MessageHead
 	XdomeaVersion XdomeaVersion
-	RootRecords   *db.RootRecords
 }
 
 func extractXdomeaVersion(messageType db.MessageType, messagePath string) (XdomeaVersion, error) {
@@ -54,102 +28,127 @@ func extractXdomeaVersion(messageType db.MessageType, messagePath string) (Xdome
 		panic(err)
 	}
 	defer xmlFile.Close()
-	// Read the opened xmlFile as a byte array.
-	xmlBytes, err := io.ReadAll(xmlFile)
+	d := xml.NewDecoder(xmlFile)
+	root, err := messageElement(d, messageType)
 	if err != nil {
-		panic(err)
-	}
-	switch messageType {
-	case "0501":
-		var messageBody messageBody0501
-		err = xml.Unmarshal(xmlBytes, &messageBody)
-		if err != nil {
-			return XdomeaVersion{}, err
-		}
-		return extractVersion(messageBody.XMLName.Space)
-	case "0503":
-		var messageBody messageBody0503
-		err = xml.Unmarshal(xmlBytes, &messageBody)
-		if err != nil {
-			return XdomeaVersion{}, err
-		}
-		return extractVersion(messageBody.XMLName.Space)
-	case "0505":
-		var messageBody messageBody0505
-		err = xml.Unmarshal(xmlBytes, &messageBody)
-		if err != nil {
-			return XdomeaVersion{}, err
-		}
-		return extractVersion(messageBody.XMLName.Space)
-	default:
-		panic("unknown message type: " + messageType)
+		return XdomeaVersion{}, err
 	}
+	return extractVersion(root.Name.Space)
 }
 
-func parseMessage(messagePath string, messageType db.MessageType) (result parsedMessage, err error) {
+// parseMessage reads the message head of the given message file and passes
+// all root records of the message to handleRootRecord.
+//
+// The message is read token by token and each root record is passed to
+// handleRootRecord as soon as it has been decoded, so memory usage depends on
+// the size of the largest root record rather than the size of the message.
+// The given root records contain exactly one file, process, or document.
+func parseMessage(
+	messagePath string,
+	messageType db.MessageType,
+	handleRootRecord func(r db.RootRecords),
+) (result parsedMessage, err error) {
 	xmlFile, err := os.Open(messagePath)
 	if err != nil {
 		panic(err)
 	}
 	defer xmlFile.Close()
-	// Read the opened xmlFile as a byte array.
-	xmlBytes, err := io.ReadAll(xmlFile)
-	if err != nil {
-		panic(err)
-	}
-	switch messageType {
-	case "0501":
-		return parse0501Message(xmlBytes)
-	case "0503":
-		return parse0503Message(xmlBytes)
-	case "0505":
-		return parse0505Message(xmlBytes)
-	default:
-		panic("unknown message type: " + messageType)
-	}
-}
-
-func parse0501Message(xmlBytes []byte) (result parsedMessage, err error) {
-	var m message0501
-	err = xml.Unmarshal(xmlBytes, &m)
+	d := xml.NewDecoder(xmlFile)
+	root, err := messageElement(d, messageType)
 	if err != nil {
 		return
 	}
-	result.MessageHead = m.MessageHead
-	result.RootRecords = &db.RootRecords{
-		Files:     m.FileRecords,
-		Processes: m.ProcessRecords,
-		Documents: m.DocumentRecords,
+	for {
+		var token xml.Token
+		token, err = d.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "Kopf":
+				err = d.DecodeElement(&result.MessageHead, &t)
+			case t.Name.Local == "Schriftgutobjekt" && messageType != db.MessageType0505:
+				err = parseRecordObject(d, handleRootRecord)
+			default:
+				err = d.Skip()
+			}
+			if err != nil {
+				return
+			}
+		case xml.EndElement:
+			// End of the message element.
+			result.XdomeaVersion, err = extractVersion(root.Name.Space)
+			return
+		}
 	}
-	result.XdomeaVersion, err = extractVersion(m.XMLName.Space)
-	return
 }
 
-func parse0503Message(xmlBytes []byte) (result parsedMessage, err error) {
-	var m message0503
-	err = xml.Unmarshal(xmlBytes, &m)
-	if err != nil {
-		return
+// messageElement reads the root element of a message and checks that it
+// matches the given message type.
+func messageElement(d *xml.Decoder, messageType db.MessageType) (xml.StartElement, error) {
+	name, ok := messageElementNames[messageType]
+	if !ok {
+		panic("unknown message type: " + messageType)
 	}
-	result.MessageHead = m.MessageHead
-	result.RootRecords = &db.RootRecords{
-		Files:     m.FileRecords,
-		Processes: m.ProcessRecords,
-		Documents: m.DocumentRecords,
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Local != name {
+				return start, fmt.Errorf(
+					"expected element type <%s> but have <%s>", name, start.Name.Local,
+				)
+			}
+			return start, nil
+		}
 	}
-	result.XdomeaVersion, err = extractVersion(m.XMLName.Space)
-	return
 }
 
-func parse0505Message(xmlBytes []byte) (result parsedMessage, err error) {
-	var m message0505
-	err = xml.Unmarshal(xmlBytes, &m)
-	if err != nil {
-		return
+// parseRecordObject decodes the contents of a Schriftgutobjekt element and
+// passes each contained record to handleRootRecord.
+func parseRecordObject(d *xml.Decoder, handleRootRecord func(r db.RootRecords)) error {
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "Akte":
+				var f db.FileRecord
+				if err := d.DecodeElement(&f, &t); err != nil {
+					return err
+				}
+				handleRootRecord(db.RootRecords{Files: []db.FileRecord{f}})
+			case "Vorgang":
+				var p db.ProcessRecord
+				if err := d.DecodeElement(&p, &t); err != nil {
+					return err
+				}
+				handleRootRecord(db.RootRecords{Processes: []db.ProcessRecord{p}})
+			case "Dokument":
+				var doc db.DocumentRecord
+				if err := d.DecodeElement(&doc, &t); err != nil {
+					return err
+				}
+				handleRootRecord(db.RootRecords{Documents: []db.DocumentRecord{doc}})
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			return nil
+		}
 	}
-	result.MessageHead = m.MessageHead
-	result.XdomeaVersion, err = extractVersion(m.XMLName.Space)
-	return
 }
 
 func extractVersion(namespace string) (XdomeaVersion, error) {
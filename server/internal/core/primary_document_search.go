@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"lath/xman/internal/db"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// maxSnippets is the maximum number of text snippets per search result.
+	maxSnippets = 3
+	// snippetContext is the number of characters included before and after a
+	// match in a text snippet.
+	snippetContext = 80
+)
+
+type PrimaryDocumentSearchResult struct {
+	db.PrimaryDocumentText
+	FilenameOriginal string `json:"filenameOriginal"`
+	// Snippets are excerpts of the document text around the search terms.
+	Snippets []string `json:"snippets"`
+	// Context contains the record that the primary document belongs to and all
+	// of its ancestors, starting with the root record.
+	Context []db.RecordIndexEntry `json:"context"`
+}
+
+// SearchPrimaryDocuments performs a full-text search on the extracted texts
+// of primary documents of all submission processes of the accessible agencies.
+// If accessibleAgencyIDs is nil, all processes are searched.
+//
+// If agencyID is not nil, only processes of the given agency are searched. If
+// processID is not empty, only the given process is searched.
+func SearchPrimaryDocuments(
+	ctx context.Context,
+	accessibleAgencyIDs []primitive.ObjectID,
+	agencyID *primitive.ObjectID,
+	processID string,
+	query string,
+	offset, limit int,
+) (results []PrimaryDocumentSearchResult, total int) {
+	results = make([]PrimaryDocumentSearchResult, 0)
+	processIDs := accessibleProcessIDs(ctx, accessibleAgencyIDs, agencyID)
+	if processID != "" {
+		if !slices.Contains(processIDs, processID) {
+			return results, 0
+		}
+		processIDs = []string{processID}
+	}
+	if len(processIDs) == 0 {
+		return results, 0
+	}
+	texts, total := db.SearchPrimaryDocumentTexts(ctx, processIDs, query, offset, limit)
+	terms := searchTerms(query)
+	for _, t := range texts {
+		r := PrimaryDocumentSearchResult{
+			PrimaryDocumentText: t,
+			Snippets:            snippets(t.Text, terms),
+			Context:             recordContext(ctx, t.ProcessID, t.RecordID),
+		}
+		if data, ok := db.FindPrimaryDocumentData(ctx, t.ProcessID, t.Filename); ok {
+			r.FilenameOriginal = data.FilenameOriginal
+		}
+		results = append(results, r)
+	}
+	return results, total
+}
+
+// recordContext returns the index entries of the given record and all its
+// ancestors, starting with the root record.
+func recordContext(ctx context.Context, processID, recordID string) []db.RecordIndexEntry {
+	var entries []db.RecordIndexEntry
+	for recordID != "" {
+		e, ok := db.FindRecordIndexEntry(ctx, processID, db.MessageType0503, recordID)
+		if !ok {
+			break
+		}
+		entries = append(entries, e)
+		recordID = e.ParentRecordID
+	}
+	slices.Reverse(entries)
+	return entries
+}
+
+// searchTerms returns the words and phrases of a text search query, omitting
+// excluded words.
+func searchTerms(query string) []string {
+	var terms []string
+	for i, part := range strings.Split(query, "\"") {
+		if i%2 == 1 {
+			if phrase := strings.TrimSpace(part); phrase != "" {
+				terms = append(terms, phrase)
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			if !strings.HasPrefix(word, "-") {
+				terms = append(terms, word)
+			}
+		}
+	}
+	return terms
+}
+
+// snippets returns excerpts of the given text around the first occurrences of
+// the given terms.
+//
+// Since the full-text search matches word stems, terms that are not found
+// literally are also searched for with their last characters removed.
+func snippets(text string, terms []string) []string {
+	var result []string
+	var covered [][2]int
+	for _, term := range terms {
+		if len(result) >= maxSnippets {
+			break
+		}
+		loc := findTerm(text, term)
+		if loc == nil {
+			continue
+		}
+		isCovered := slices.ContainsFunc(covered, func(c [2]int) bool {
+			return loc[0] >= c[0] && loc[1] <= c[1]
+		})
+		if isCovered {
+			continue
+		}
+		start, end := expandByRunes(text, loc[0], loc[1], snippetContext)
+		covered = append(covered, [2]int{start, end})
+		snippet := strings.Join(strings.Fields(text[start:end]), " ")
+		if start > 0 {
+			snippet = "…" + snippet
+		}
+		if end < len(text) {
+			snippet += "…"
+		}
+		result = append(result, snippet)
+	}
+	if len(result) == 0 && text != "" {
+		_, end := expandByRunes(text, 0, 0, 2*snippetContext)
+		snippet := strings.Join(strings.Fields(text[:end]), " ")
+		if end < len(text) {
+			snippet += "…"
+		}
+		result = append(result, snippet)
+	}
+	return result
+}
+
+func findTerm(text, term string) []int {
+	candidates := []string{term}
+	if n := utf8.RuneCountInString(term); n > 4 {
+		runes := []rune(term)
+		candidates = append(candidates, string(runes[:n-2]))
+	}
+	for _, c := range candidates {
+		r := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(c))
+		if loc := r.FindStringIndex(text); loc != nil {
+			return loc
+		}
+	}
+	return nil
+}
+
+// expandByRunes moves start and end of the given range by n runes without
+// exceeding the text.
+func expandByRunes(text string, start, end, n int) (int, int) {
+	for i := 0; i < n && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	for i := 0; i < n && end < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	return start, end
+}
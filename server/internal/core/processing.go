@@ -5,9 +5,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"lath/xman/internal/auth"
 	"lath/xman/internal/db"
 	"lath/xman/internal/errors"
+	"lath/xman/internal/fulltext"
 	"lath/xman/internal/mail"
 	"lath/xman/internal/verification"
 	"log"
@@ -18,7 +20,6 @@ import (
 	"time"
 	"unicode/utf8"
 
-	"github.com/lestrrat-go/libxml2/xsd"
 	"golang.org/x/text/encoding/charmap"
 )
 
@@ -26,6 +27,23 @@ var errTransferFileExists = fmt.Errorf("transfer file exists")
 
 func ProcessNewMessage(agency db.Agency, transferDirMessagePath string) {
 	log.Println("Processing new message " + transferDirMessagePath)
+	// copy message from transfer directory to a local temporary directory
+	localMessagePath := CopyMessageFromTransferDirectory(agency, transferDirMessagePath)
+	defer os.Remove(localMessagePath)
+	processMessage(agency, transferDirMessagePath, localMessagePath, nil)
+}
+
+// processMessage imports the message at localMessagePath, which is a local
+// copy of the transfer file transferDirMessagePath.
+//
+// upload is set if the message was uploaded manually instead of being read
+// from the transfer directory.
+func processMessage(
+	agency db.Agency,
+	transferDirMessagePath string,
+	localMessagePath string,
+	upload *db.MessageUpload,
+) {
 	errorData := db.ProcessingError{
 		Agency:       &agency,
 		TransferPath: transferDirMessagePath,
@@ -37,41 +55,63 @@ func ProcessNewMessage(agency db.Agency, transferDirMessagePath string) {
 	if err != nil {
 		panic(err)
 	}
-	// copy message from transfer directory to a local temporary directory
-	localMessagePath := CopyMessageFromTransferDirectory(agency, transferDirMessagePath)
-	defer os.Remove(localMessagePath)
+	err = checkDuplicateTransferFile(agency, transferDirMessagePath, localMessagePath)
+	if err != nil && upload != nil {
+		// Move uploaded duplicates to quarantine, so resolving the error
+		// never touches a file in the transfer directory.
+		rejectTransferFile(agency, transferDirMessagePath, localMessagePath, err, upload)
+		return
+	} else if err != nil {
+		errors.AddProcessingErrorWithData(err, errorData)
+		return
+	}
 	// extract message to message storage
-	processStoreDir, messageStoreDir := extractMessageToMessageStore(
+	processStoreDir, messageStoreDir, err := extractMessageToMessageStore(
 		agency,
 		transferDirMessagePath,
 		localMessagePath,
 		processID,
 		messageType,
 	)
+	if err != nil {
+		rejectTransferFile(agency, transferDirMessagePath, localMessagePath, err, upload)
+		return
+	}
 	// save message
-	process, message, rootRecords, err := addMessage(
+	process, message, records, err := addMessage(
 		agency,
 		processID,
 		messageType,
 		processStoreDir,
 		messageStoreDir,
 		transferDirMessagePath,
+		upload,
 	)
 	if err != nil {
-		errors.AddProcessingErrorWithData(err, errorData)
+		if err := os.RemoveAll(messageStoreDir); err != nil {
+			panic(err)
+		}
+		// Remove the process directory unless it holds other messages.
+		os.Remove(processStoreDir)
+		rejectTransferFile(agency, transferDirMessagePath, localMessagePath, err, upload)
 		return
 	}
-	errorData.ProcessID = &processID
-	errorData.MessageType = messageType
 	err = compareAgencyFields(agency, message)
 	if err != nil {
-		errors.AddProcessingErrorWithData(err, errorData)
+		if err := DeleteMessage(processID, messageType, true); err != nil {
+			panic(err)
+		}
+		cleanupEmptyProcess(processID)
+		rejectTransferFile(agency, transferDirMessagePath, localMessagePath, err, upload)
+		return
 	}
+	errorData.ProcessID = &processID
+	errorData.MessageType = messageType
 	err = checkMaxRecordObjectDepth(message)
 	if err != nil {
 		errors.AddProcessingErrorWithData(err, errorData)
 	}
-	if rootRecords != nil && len(rootRecords.Documents) > 0 &&
+	if records.HasRootDocuments &&
 		(messageType == db.MessageType0501 ||
 			messageType == db.MessageType0503 && !process.ProcessState.Receive0501.Complete) {
 		db.InsertWarning(db.Warning{
@@ -82,9 +122,8 @@ func ProcessNewMessage(agency db.Agency, transferDirMessagePath string) {
 		})
 	}
 	if messageType == "0503" {
-		primaryDocuments := GetPrimaryDocuments(rootRecords)
 		primaryDocumentsData, err := collectPrimaryDocumentsData(
-			process, message, primaryDocuments,
+			process, message, records.PrimaryDocuments,
 		)
 		if err != nil {
 			errors.AddProcessingErrorWithData(err, errorData)
@@ -97,6 +136,9 @@ func ProcessNewMessage(agency db.Agency, transferDirMessagePath string) {
 		if os.Getenv("BORG_URL") != "" {
 			verification.VerifyFileFormats(process, primaryDocumentsData)
 		}
+		if fulltext.IsEnabled() {
+			fulltext.ExtractTexts(process, primaryDocumentsData)
+		}
 	}
 	confirmMessageReceipt(agency, processID, messageType)
 }
@@ -145,7 +187,7 @@ func confirmMessageReceipt(agency db.Agency, processID string, messageType db.Me
 	}
 	// Send e-mail notification to users.
 	errorData.Title = "Fehler beim Versenden einer E-Mail-Benachrichtigung"
-	for _, user := range agency.Users {
+	for _, user := range auth.NotificationRecipients(context.Background(), agency.AssignedUsers()) {
 		preferences := db.FindUserPreferencesWithDefault(context.Background(), user)
 		if preferences.MessageEmailNotifications {
 			address, err := auth.GetMailAddress(user)
@@ -161,56 +203,61 @@ func confirmMessageReceipt(agency db.Agency, processID string, messageType db.Me
 	}
 }
 
-// extractMessage parses the given message file into a database entry and saves
-// it to the database. It returns the saved entry.
+// extractMessageToMessageStore extracts the given message archive into the
+// message store.
 //
 // Returns the directories in message store for the process and the message.
+// Returns a processing error without extracting any files if the archive
+// cannot be read or violates the extraction limits of the agency.
 func extractMessageToMessageStore(
 	agency db.Agency,
 	transferDirMessagePath string,
 	localMessagePath string,
 	processID string,
 	messageType db.MessageType,
-) (processStoreDir string, messageStoreDir string) {
+) (processStoreDir string, messageStoreDir string, err error) {
 	processStoreDir = path.Join("message_store", processID)
-	// Create the message store directory if necessary.
 	messageStoreDir = path.Join(processStoreDir, string(messageType))
-	err := os.MkdirAll(messageStoreDir, 0700)
-	if err != nil {
-		panic(err)
-	}
 	// Open the message archive (zip).
 	archive, err := zip.OpenReader(localMessagePath)
 	if err != nil {
-		panic(err)
+		return "", "", &db.ProcessingError{
+			Title:     "Ungültige ZIP-Datei",
+			ErrorType: "invalid-message-archive",
+			Info:      err.Error(),
+		}
 	}
 	defer archive.Close()
 	var invalidFilenames []string
-	for _, f := range archive.File {
-		fileName := f.Name
+	fileNames := make([]string, len(archive.File))
+	for i, f := range archive.File {
+		fileNames[i] = f.Name
 		// file name is nor UTF-8 encoded
 		if f.NonUTF8 {
 			// try reading file name as IBM code page 437
-			fileName, err = charmap.CodePage437.NewDecoder().String(f.Name)
+			fileNames[i], err = charmap.CodePage437.NewDecoder().String(f.Name)
 			// file name could not be encoded as UTF-8
-			if err != nil || !utf8.ValidString(fileName) {
-				invalidFilenames = append(invalidFilenames, fileName)
+			if err != nil || !utf8.ValidString(fileNames[i]) {
+				invalidFilenames = append(invalidFilenames, fileNames[i])
 			}
 		}
-		fileInArchive, err := f.Open()
-		if err != nil {
-			panic(err)
-		}
-		defer fileInArchive.Close()
-		fileStorePath := path.Join(messageStoreDir, fileName)
-		fileInStore, err := os.Create(fileStorePath)
-		if err != nil {
-			panic(err)
-		}
-		defer fileInStore.Close()
-		_, err = io.Copy(fileInStore, fileInArchive)
+	}
+	err = checkArchiveEntries(archive.File, fileNames, messageStoreDir, extractionLimitsForAgency(agency))
+	if err != nil {
+		return "", "", err
+	}
+	// Create the message store directory if necessary.
+	err = os.MkdirAll(messageStoreDir, 0700)
+	if err != nil {
+		panic(err)
+	}
+	for i, f := range archive.File {
+		err := extractArchiveFile(f, path.Join(messageStoreDir, fileNames[i]))
 		if err != nil {
-			panic(err)
+			os.RemoveAll(messageStoreDir)
+			return "", "", unsafeArchiveError(
+				[]unsafeArchiveEntry{{Name: fileNames[i], Reason: err.Error()}}, nil,
+			)
 		}
 	}
 	// create a processing error for all invalid encoded file names
@@ -227,13 +274,59 @@ func extractMessageToMessageStore(
 		}
 		errors.AddProcessingError(procErr)
 	}
-	return
+	return processStoreDir, messageStoreDir, nil
 }
 
+// extractArchiveFile writes the content of a file in a message archive to the
+// given path.
+//
+// It returns an error if the content doesn't match the size and checksum
+// declared in the archive.
+func extractArchiveFile(f *zip.File, storePath string) error {
+	fileInArchive, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer fileInArchive.Close()
+	fileInStore, err := os.Create(storePath)
+	if err != nil {
+		panic(err)
+	}
+	defer fileInStore.Close()
+	// The zip reader fails when reading more bytes than declared.
+	_, err = io.Copy(fileInStore, fileInArchive)
+	if _, ok := err.(*fs.PathError); ok {
+		// Failed to write to the message store.
+		panic(err)
+	}
+	return err
+}
+
+// recordSummary holds information about the records of a message that is
+// collected while parsing the message, so records don't need to be kept in
+// memory.
+type recordSummary struct {
+	// MaxRecordDepth is the nesting level of the deepest nested record.
+	MaxRecordDepth int
+	// HasRootDocuments is true if the message contains documents that are not
+	// part of a file or process.
+	HasRootDocuments bool
+	// PrimaryDocuments are the primary documents of all records. Only
+	// collected for 0503 messages.
+	PrimaryDocuments []db.PrimaryDocumentContext
+}
+
+// rootRecordBatchSize is the number of root records that are inserted into
+// the database at once while parsing a message.
+const rootRecordBatchSize = 100
+
 // addMessage parses the message and saves it in the database.
 //
-// It returns panics with a processing error if reading the message failed due
-// to errors within the message file.
+// Root records are saved while the message is being parsed. If parsing fails,
+// records saved so far are removed again.
+//
+// It returns a processing error if reading the message failed due to errors
+// within the message file.
 func addMessage(
 	agency db.Agency,
 	processID string,
@@ -241,7 +334,8 @@ func addMessage(
 	processStoreDir string,
 	storeDir string,
 	transferDir string,
-) (db.SubmissionProcess, db.Message, *db.RootRecords, error) {
+	upload *db.MessageUpload,
+) (db.SubmissionProcess, db.Message, recordSummary, error) {
 	// Check the path for xdomea versions before 4.0.0.
 	messageName := getMessageName(processID, messageType, true)
 	messagePath := path.Join(storeDir, messageName)
@@ -252,7 +346,11 @@ func addMessage(
 		messagePath := path.Join(storeDir, messageName)
 		_, err = os.Stat(messagePath)
 		if err != nil {
-			panic("message doesn't exist: " + messagePath)
+			return db.SubmissionProcess{}, db.Message{}, recordSummary{}, &db.ProcessingError{
+				Title:     "Ungültige ZIP-Datei",
+				ErrorType: "invalid-message-archive",
+				Info:      fmt.Sprintf("Die ZIP-Datei enthält keine Nachrichtendatei %s.", messageName),
+			}
 		}
 	}
 	storagePaths := db.StoragePaths{
@@ -261,48 +359,86 @@ func addMessage(
 		MessagePath:  messagePath,
 	}
 	err = checkMessageValidity(messageType, storagePaths)
-	if err != nil {
+	schemaErr, isSchemaViolation := err.(schemaValidationError)
+	if isSchemaViolation && agency.LenientSchemaValidation {
+		// Import the message anyway and record the violations.
+		locateSchemaViolations(messagePath, schemaErr.violations)
+	} else if err != nil {
 		e := errors.FromError("Schema-Validierung ungültig", err)
-		return db.SubmissionProcess{}, db.Message{}, nil, &e
-	}
-	parsedMessage, err := parseMessage(messagePath, messageType)
+		e.ErrorType = "invalid-message-schema"
+		return db.SubmissionProcess{}, db.Message{}, recordSummary{}, &e
+	}
+	var records recordSummary
+	var inserter *db.RootRecordInserter
+	var batch db.RootRecords
+	batchSize := 0
+	insertBatch := func() {
+		if batchSize == 0 {
+			return
+		}
+		if inserter == nil {
+			inserter = db.NewRootRecordInserter(processID, messageType)
+		}
+		inserter.Insert(batch)
+		batch = db.RootRecords{}
+		batchSize = 0
+	}
+	parsedMessage, err := parseMessage(messagePath, messageType, func(r db.RootRecords) {
+		records.MaxRecordDepth = max(records.MaxRecordDepth, getMaxRecordDepth(&r))
+		records.HasRootDocuments = records.HasRootDocuments || len(r.Documents) > 0
+		if messageType == db.MessageType0503 {
+			records.PrimaryDocuments = append(records.PrimaryDocuments, GetPrimaryDocuments(&r)...)
+		}
+		batch.Files = append(batch.Files, r.Files...)
+		batch.Processes = append(batch.Processes, r.Processes...)
+		batch.Documents = append(batch.Documents, r.Documents...)
+		batchSize++
+		if batchSize >= rootRecordBatchSize {
+			insertBatch()
+		}
+	})
 	if err != nil {
+		if inserter != nil {
+			db.DeleteRecordsForMessage(processID, messageType)
+		}
 		e := errors.FromError("Fehler beim Einlesen der Nachricht", err)
-		return db.SubmissionProcess{}, db.Message{}, nil, &e
+		e.ErrorType = "invalid-message-content"
+		return db.SubmissionProcess{}, db.Message{}, recordSummary{}, &e
 	}
+	insertBatch()
 	message := db.Message{
 		StoragePaths:   storagePaths,
 		MessageType:    messageType,
 		MessageHead:    parsedMessage.MessageHead,
 		XdomeaVersion:  parsedMessage.XdomeaVersion.Code,
-		MaxRecordDepth: getMaxRecordDepth(parsedMessage.RootRecords),
+		MaxRecordDepth: records.MaxRecordDepth,
+		Upload:         upload,
 	}
 	process := db.FindOrInsertProcess(processID, agency, processStoreDir)
 	db.InsertMessage(message)
 	markMessageReceived(message, process)
-	if parsedMessage.RootRecords != nil {
-		db.InsertRootRecords(processID, messageType, *parsedMessage.RootRecords)
+	if isSchemaViolation {
+		db.InsertWarning(db.Warning{
+			CreatedAt:            time.Now(),
+			Title:                "Nachricht entspricht nicht dem xdomea-Schema",
+			ProcessID:            processID,
+			MessageType:          messageType,
+			SchemaViolations:     schemaErr.violations,
+			SchemaViolationCount: schemaErr.total,
+		})
 	}
-	return process, message, parsedMessage.RootRecords, nil
+	return process, message, records, nil
 }
 
 // checkMessageValidity performs a xsd schema validation against the message XML file.
+//
+// Returns a schemaValidationError if the message violates the schema.
 func checkMessageValidity(messageType db.MessageType, storagePaths db.StoragePaths) error {
 	xdomeaVersion, err := extractXdomeaVersion(messageType, storagePaths.MessagePath)
 	if err != nil {
 		return err
 	}
-	err = validateXdomeaXmlFile(storagePaths.MessagePath, xdomeaVersion)
-	validationError, ok := err.(xsd.SchemaValidationError)
-	if ok {
-		var errorMessages []string
-		for _, e := range validationError.Errors() {
-			errorMessages = append(errorMessages, e.Error())
-		}
-		return fmt.Errorf("%s", strings.Join(errorMessages, "\n"))
-	} else {
-		return err
-	}
+	return validateXdomeaXmlFile(storagePaths.MessagePath, xdomeaVersion)
 }
 
 // collectPrimaryDocumentsData checks the files referenced by the given primary
@@ -423,8 +559,9 @@ func compareAgencyFields(agency db.Agency, message db.Message) error {
 	a := message.MessageHead.Sender.AgencyIdentification
 	if a == nil {
 		return &db.ProcessingError{
-			Title: "Behördenkennung der Nachricht stimmt nicht mit der konfigurierten abgebenden Stelle überein",
-			Info:  "Die Nachricht gibt keine Behördenkennung an",
+			Title:     "Behördenkennung der Nachricht stimmt nicht mit der konfigurierten abgebenden Stelle überein",
+			ErrorType: "agency-mismatch",
+			Info:      "Die Nachricht gibt keine Behördenkennung an",
 		}
 	}
 	if (agency.Prefix == "" || agency.Prefix == a.Prefix) && (agency.Code == "" || agency.Code == a.Code) {
@@ -452,8 +589,9 @@ func compareAgencyFields(agency db.Agency, message db.Message) error {
 		info += "Behördenschlüssel der konfigurierten abgebenden Stelle: (kein Wert)"
 	}
 	return &db.ProcessingError{
-		Title: "Behördenkennung der Nachricht stimmt nicht mit der konfigurierten abgebenden Stelle überein",
-		Info:  info,
+		Title:     "Behördenkennung der Nachricht stimmt nicht mit der konfigurierten abgebenden Stelle überein",
+		ErrorType: "agency-mismatch",
+		Info:      info,
 	}
 }
 
@@ -482,14 +620,61 @@ func checkMaxRecordObjectDepth(message db.Message) error {
 	return nil
 }
 
+// Send0502Message generates the 0502 message for the given 0501 message and
+// copies it to the transfer directory.
+//
+// If the appraisal has been reopened since the last 0502 message was sent, the
+// previous message is replaced or superseded as chosen when reopening.
 func Send0502Message(agency db.Agency, message db.Message) error {
+	processID := message.MessageHead.ProcessID
 	messageXml := Generate0502Message(message)
-	return sendMessage(
-		agency,
-		message.MessageHead.ProcessID,
-		messageXml,
-		db.MessageType0502,
-	)
+	process, found := db.FindProcess(context.Background(), processID)
+	if !found {
+		panic("process not found: " + processID)
+	}
+	reopenings := process.AppraisalReopenings
+	if len(reopenings) == 0 || !reopenings[len(reopenings)-1].ResentAt.IsZero() {
+		return sendMessage(agency, processID, messageXml, db.MessageType0502)
+	}
+	previousPath := handlePrevious0502Message(agency, processID, reopenings[len(reopenings)-1].Mode)
+	err := sendMessage(agency, processID, messageXml, db.MessageType0502)
+	if err != nil {
+		return err
+	}
+	log.Printf("Sent corrected 0502 message for process %s\n", processID)
+	db.UpdateAppraisalReopeningResent(processID, len(reopenings)-1, previousPath)
+	return nil
+}
+
+// handlePrevious0502Message removes the previously sent 0502 message of the
+// given process from the transfer directory or renames it to mark it as
+// superseded, depending on mode.
+//
+// Returns the new path of a superseded message if any.
+func handlePrevious0502Message(
+	agency db.Agency,
+	processID string,
+	mode db.Correction0502Mode,
+) (supersededPath string) {
+	for _, f := range db.FindTransferDirFilesForProcess(processID) {
+		if !message0502Regex.MatchString(path.Base(f.Path)) {
+			continue
+		}
+		switch mode {
+		case db.Correction0502ModeSupersede:
+			ext := path.Ext(f.Path)
+			newPath := fmt.Sprintf(
+				"%s_superseded_%s%s",
+				strings.TrimSuffix(f.Path, ext), time.Now().Format("20060102150405"), ext,
+			)
+			if RenameFileInTransferDir(agency, &processID, f.Path, newPath) {
+				supersededPath = newPath
+			}
+		default:
+			RemoveFileFromTransferDir(agency, f.Path)
+		}
+	}
+	return supersededPath
 }
 
 func Send0504Message(agency db.Agency, message db.Message) error {
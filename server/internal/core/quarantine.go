@@ -0,0 +1,181 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"lath/xman/internal/db"
+	"lath/xman/internal/errors"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// quarantineStoreDir is the directory in which rejected transfer files are
+// kept, with one subdirectory per agency.
+const quarantineStoreDir = "quarantine_store"
+
+var (
+	ErrQuarantinedFileNotFound = fmt.Errorf("quarantined file not found")
+	// ErrTransferPathOccupied is returned when a quarantined file cannot be
+	// released because its original path in the transfer directory is in use.
+	ErrTransferPathOccupied = fmt.Errorf("a file with the same name exists in the transfer directory")
+)
+
+// rejectTransferFile moves a transfer file that failed to import to the
+// quarantine store and adds a processing error for the given error.
+//
+// localPath is a local copy of the transfer file. upload is set if the file was
+// uploaded manually. Otherwise, the file is removed from the transfer
+// directory.
+func rejectTransferFile(agency db.Agency, transferPath, localPath string, err error, upload *db.MessageUpload) {
+	e, ok := err.(*db.ProcessingError)
+	if !ok {
+		pe := errors.FromError("Fehler beim Einlesen der Nachricht", err)
+		e = &pe
+	}
+	id := quarantineTransferFile(agency, transferPath, localPath, *e, upload)
+	if upload == nil {
+		RemoveFileFromTransferDir(agency, transferPath)
+	} else {
+		db.DeleteTransferFile(agency.ID, transferPath)
+	}
+	e.Info = strings.TrimSpace(e.Info + "\n\nDie Transferdatei wurde in die Quarantäne verschoben.")
+	errors.AddProcessingErrorWithData(e, db.ProcessingError{
+		Agency:       &agency,
+		TransferPath: transferPath,
+		QuarantineID: &id,
+	})
+}
+
+// quarantineTransferFile copies the local copy of a transfer file to the
+// quarantine store.
+//
+// reason is the processing error that caused the file to be rejected. upload
+// is set if the file was uploaded manually.
+func quarantineTransferFile(
+	agency db.Agency,
+	transferPath string,
+	localPath string,
+	reason db.ProcessingError,
+	upload *db.MessageUpload,
+) primitive.ObjectID {
+	id := primitive.NewObjectID()
+	dir := path.Join(quarantineStoreDir, agency.ID.Hex(), id.Hex())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		panic(err)
+	}
+	storePath := path.Join(dir, path.Base(transferPath))
+	size := copyLocalFile(localPath, storePath)
+	db.InsertQuarantinedFile(db.QuarantinedFile{
+		ID:           id,
+		CreatedAt:    time.Now(),
+		AgencyID:     agency.ID,
+		TransferPath: transferPath,
+		StorePath:    storePath,
+		Size:         size,
+		ContentHash:  fileContentHash(storePath),
+		Reason:       reason.ErrorType,
+		Title:        reason.Title,
+		Info:         reason.Info,
+		Upload:       upload,
+	})
+	log.Printf("Moved %s of %s to quarantine\n", transferPath, agency.Name)
+	return id
+}
+
+// ReleaseQuarantinedFile moves a quarantined file back to its original path in
+// the transfer directory, so it will be imported again with the next scan.
+//
+// Manually uploaded files are not written to the transfer directory but
+// imported again from the message store.
+//
+// Processing errors that refer to the file are marked as obsolete.
+func ReleaseQuarantinedFile(ctx context.Context, id primitive.ObjectID) error {
+	f, ok := db.FindQuarantinedFile(ctx, id)
+	if !ok {
+		return ErrQuarantinedFileNotFound
+	}
+	agency, ok := db.FindAgency(ctx, f.AgencyID)
+	if !ok {
+		return fmt.Errorf("agency not found: %v", f.AgencyID.Hex())
+	}
+	if f.Upload != nil {
+		return releaseQuarantinedUpload(agency, f)
+	}
+	if _, ok := db.FindTransferFile(agency.ID, f.TransferPath); ok {
+		return ErrTransferPathOccupied
+	}
+	if !writeFileToTransferDir(agency, f.StorePath, f.TransferPath) {
+		return ErrTransferPathOccupied
+	}
+	log.Printf("Released %s of %s from quarantine\n", f.TransferPath, agency.Name)
+	removeQuarantinedFile(f)
+	return nil
+}
+
+// releaseQuarantinedUpload imports a quarantined, manually uploaded file
+// again from the message store.
+func releaseQuarantinedUpload(agency db.Agency, f db.QuarantinedFile) error {
+	file, err := os.Open(f.StorePath)
+	if err != nil {
+		panic(err)
+	}
+	err = storeUploadedMessage(agency, path.Base(f.TransferPath), file, *f.Upload)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	log.Printf("Released %s of %s from quarantine for import\n", f.TransferPath, agency.Name)
+	removeQuarantinedFile(f)
+	return nil
+}
+
+// PurgeQuarantinedFile deletes a quarantined file.
+//
+// Processing errors that refer to the file are marked as obsolete.
+func PurgeQuarantinedFile(ctx context.Context, id primitive.ObjectID) error {
+	f, ok := db.FindQuarantinedFile(ctx, id)
+	if !ok {
+		return ErrQuarantinedFileNotFound
+	}
+	log.Printf("Purging %s from quarantine\n", f.TransferPath)
+	removeQuarantinedFile(f)
+	return nil
+}
+
+// removeQuarantinedFile deletes the quarantined file from the quarantine store
+// and the database.
+func removeQuarantinedFile(f db.QuarantinedFile) {
+	if err := os.RemoveAll(path.Dir(f.StorePath)); err != nil {
+		panic(err)
+	}
+	db.DeleteQuarantinedFile(f.ID)
+	for _, e := range db.FindUnresolvedProcessingErrorsForQuarantinedFile(context.Background(), f.ID) {
+		db.UpdateProcessingErrorResolve(e, db.ErrorResolutionObsolete)
+	}
+}
+
+// copyLocalFile copies the file at src to dst and returns the number of bytes
+// copied.
+func copyLocalFile(src, dst string) int64 {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		panic(err)
+	}
+	defer srcFile.Close()
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		panic(err)
+	}
+	defer dstFile.Close()
+	n, err := io.Copy(dstFile, srcFile)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"lath/xman/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SearchRecords searches the records of all submission processes of the
+// accessible agencies. If accessibleAgencyIDs is nil, all processes are
+// searched.
+//
+// If agencyID is not nil, only processes of the given agency are searched.
+// Any process IDs set in the filter are ignored.
+func SearchRecords(
+	ctx context.Context,
+	accessibleAgencyIDs []primitive.ObjectID,
+	agencyID *primitive.ObjectID,
+	filter db.RecordSearchFilter,
+	offset, limit int,
+) (results []db.RecordSearchResult, total int) {
+	filter.ProcessIDs = accessibleProcessIDs(ctx, accessibleAgencyIDs, agencyID)
+	if len(filter.ProcessIDs) == 0 {
+		return make([]db.RecordSearchResult, 0), 0
+	}
+	results, total = db.SearchRecords(ctx, filter, offset, limit)
+	if results == nil {
+		results = make([]db.RecordSearchResult, 0)
+	}
+	return results, total
+}
+
+// accessibleProcessIDs returns the IDs of all submission processes of the
+// accessible agencies or of all processes if accessibleAgencyIDs is nil.
+//
+// If agencyID is not nil, only processes of the given agency are returned.
+func accessibleProcessIDs(
+	ctx context.Context,
+	accessibleAgencyIDs []primitive.ObjectID,
+	agencyID *primitive.ObjectID,
+) []string {
+	processIDs := make([]string, 0)
+	var processes []db.SubmissionProcess
+	if accessibleAgencyIDs == nil {
+		processes = db.FindProcesses(ctx)
+	} else {
+		processes = db.FindProcessesForAgencies(ctx, accessibleAgencyIDs)
+	}
+	for _, p := range processes {
+		if agencyID == nil || p.Agency.ID == *agencyID {
+			processIDs = append(processIDs, p.ProcessID)
+		}
+	}
+	return processIDs
+}
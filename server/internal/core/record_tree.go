@@ -0,0 +1,211 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"lath/xman/internal/db"
+)
+
+// treeNode is a node of the record tree of a message. It only holds the
+// structure of the tree, not the metadata of the records.
+type treeNode struct {
+	RecordID   string
+	RecordType db.RecordType
+	Parent     *treeNode
+	Children   []*treeNode
+}
+
+// childrenOfType returns all direct children of the given node with the given
+// record type.
+func (n *treeNode) childrenOfType(t db.RecordType) []*treeNode {
+	var result []*treeNode
+	for _, c := range n.Children {
+		if c.RecordType == t {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// recordTree reconstructs the record tree of the given message from the
+// record index without loading the records themselves.
+//
+// Children are ordered as they appear in the message.
+func recordTree(
+	ctx context.Context,
+	processID string,
+	messageType db.MessageType,
+) (roots []*treeNode, nodes map[string]*treeNode) {
+	nodes = make(map[string]*treeNode)
+	// Entries are sorted by position, so parents always precede their
+	// children.
+	for _, e := range db.FindRecordStructure(ctx, processID, messageType) {
+		n := &treeNode{RecordID: e.RecordID, RecordType: e.RecordType}
+		nodes[e.RecordID] = n
+		if parent, ok := nodes[e.ParentRecordID]; ok {
+			n.Parent = parent
+			parent.Children = append(parent.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+	return roots, nodes
+}
+
+// RecordTreeRoots returns a page of the root records of the given message
+// together with the total number of root records.
+//
+// Records are returned as index entries that include the number of direct
+// children, which can be fetched with RecordTreeChildren.
+func RecordTreeRoots(
+	ctx context.Context,
+	processID string,
+	messageType db.MessageType,
+	offset, limit int,
+) (records []db.RecordSearchResult, total int) {
+	noParent := ""
+	return db.SearchRecords(ctx, db.RecordSearchFilter{
+		ProcessIDs:     []string{processID},
+		MessageType:    messageType,
+		ParentRecordID: &noParent,
+	}, offset, limit)
+}
+
+// RecordTreeChildren returns a page of the direct children of the given record
+// together with the total number of children.
+func RecordTreeChildren(
+	ctx context.Context,
+	processID string,
+	messageType db.MessageType,
+	recordID string,
+	offset, limit int,
+) (records []db.RecordSearchResult, total int, err error) {
+	if _, ok := db.FindRecordIndexEntry(ctx, processID, messageType, recordID); !ok {
+		return nil, 0, fmt.Errorf("record not found: %s", recordID)
+	}
+	records, total = db.SearchRecords(ctx, db.RecordSearchFilter{
+		ProcessIDs:     []string{processID},
+		MessageType:    messageType,
+		ParentRecordID: &recordID,
+	}, offset, limit)
+	return records, total, nil
+}
+
+// FindRecord returns the full metadata of a single record of the given
+// message. Sub-records are omitted and can be fetched with RecordTreeChildren.
+//
+// The result is a db.FileRecord, db.ProcessRecord, or db.DocumentRecord.
+func FindRecord(
+	ctx context.Context,
+	processID string,
+	messageType db.MessageType,
+	recordID string,
+) (interface{}, bool) {
+	rootRecords, ok := db.FindRootRecord(ctx, processID, messageType, recordID)
+	if !ok {
+		return nil, false
+	}
+	var findDocument func(documents []db.DocumentRecord) (interface{}, bool)
+	findDocument = func(documents []db.DocumentRecord) (interface{}, bool) {
+		for _, d := range documents {
+			if d.RecordID == recordID {
+				d.Attachments = nil
+				return d, true
+			} else if r, ok := findDocument(d.Attachments); ok {
+				return r, true
+			}
+		}
+		return nil, false
+	}
+	var findProcess func(processes []db.ProcessRecord) (interface{}, bool)
+	findProcess = func(processes []db.ProcessRecord) (interface{}, bool) {
+		for _, p := range processes {
+			if p.RecordID == recordID {
+				p.Subprocesses = nil
+				p.Documents = nil
+				return p, true
+			} else if r, ok := findProcess(p.Subprocesses); ok {
+				return r, true
+			} else if r, ok := findDocument(p.Documents); ok {
+				return r, true
+			}
+		}
+		return nil, false
+	}
+	var findFile func(files []db.FileRecord) (interface{}, bool)
+	findFile = func(files []db.FileRecord) (interface{}, bool) {
+		for _, f := range files {
+			if f.RecordID == recordID {
+				f.Subfiles = nil
+				f.Processes = nil
+				f.Documents = nil
+				return f, true
+			} else if r, ok := findFile(f.Subfiles); ok {
+				return r, true
+			} else if r, ok := findProcess(f.Processes); ok {
+				return r, true
+			} else if r, ok := findDocument(f.Documents); ok {
+				return r, true
+			}
+		}
+		return nil, false
+	}
+	if r, ok := findFile(rootRecords.Files); ok {
+		return r, true
+	} else if r, ok := findProcess(rootRecords.Processes); ok {
+		return r, true
+	}
+	return findDocument(rootRecords.Documents)
+}
+
+// appraisableRecordsForMessage returns all records of the given message that
+// can be appraised with the given appraisal level.
+//
+// It yields the same result as AppraisableRecords but uses the record index
+// instead of loading all records.
+func appraisableRecordsForMessage(
+	ctx context.Context,
+	processID string,
+	messageType db.MessageType,
+	level db.AppraisalLevel,
+) AppraisableRecordsMap {
+	m := make(AppraisableRecordsMap)
+	includeDocuments := level == db.AppraisalLevelDocument
+	var appendRecords func(parent *string, nodes []*treeNode) (childIDs []string)
+	appendRecords = func(parent *string, nodes []*treeNode) (childIDs []string) {
+		for _, n := range nodes {
+			if n.RecordType == db.RecordTypeDocument {
+				// Attachments are never appraisable.
+				if includeDocuments && (n.Parent == nil || n.Parent.RecordType != db.RecordTypeDocument) {
+					childIDs = append(childIDs, n.RecordID)
+					m[n.RecordID] = AppraisableRecordRelations{
+						Parent: parent,
+						Type:   db.RecordTypeDocument,
+					}
+				}
+				continue
+			}
+			childIDs = append(childIDs, n.RecordID)
+			m[n.RecordID] = AppraisableRecordRelations{
+				Parent:       parent,
+				Children:     appendRecords(&n.RecordID, n.Children),
+				HasDocuments: len(n.childrenOfType(db.RecordTypeDocument)) > 0 && !includeDocuments,
+				Type:         n.RecordType,
+			}
+		}
+		return
+	}
+	roots, _ := recordTree(ctx, processID, messageType)
+	appendRecords(nil, roots)
+	return m
+}
+
+// appraisalDecisions returns the appraisal decisions of all records of the
+// given process.
+func appraisalDecisions(ctx context.Context, processID string) map[string]db.AppraisalDecisionOption {
+	decisions := make(map[string]db.AppraisalDecisionOption)
+	for _, a := range db.FindAppraisalsForProcess(ctx, processID) {
+		decisions[a.RecordID] = a.Decision
+	}
+	return decisions
+}
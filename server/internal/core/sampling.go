@@ -0,0 +1,295 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"lath/xman/internal/db"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type SamplingMethod string
+
+const (
+	// SamplingMethodSystematic selects every n-th record.
+	SamplingMethodSystematic SamplingMethod = "systematic"
+	// SamplingMethodRandom selects a given percentage of records at random.
+	SamplingMethodRandom SamplingMethod = "random"
+	// SamplingMethodLetter selects all records of which the subject starts
+	// with one of the given letters.
+	SamplingMethodLetter SamplingMethod = "letter"
+)
+
+type SamplingOptions struct {
+	Method SamplingMethod `json:"method"`
+	// Interval is the distance between two selected records for systematic
+	// sampling.
+	Interval int `json:"interval"`
+	// Start is the 1-based position of the first selected record for
+	// systematic sampling. Defaults to Interval.
+	Start int `json:"start"`
+	// Percentage is the share of records to select for random sampling.
+	Percentage float64 `json:"percentage"`
+	// Seed initializes the random number generator for random sampling. A new
+	// seed is chosen if empty.
+	Seed int64 `json:"seed"`
+	// Letters are the initial letters of subjects to select for letter
+	// sampling.
+	Letters []string `json:"letters"`
+}
+
+// ApplySampling appraises the given sibling records by sampling.
+//
+// Records selected by the sample are marked "B", all other given records are
+// marked "V". The sampling method and its parameters, including the seed used
+// for random sampling, are saved to the internal appraisal note, followed by
+// the given internal note if any.
+func ApplySampling(
+	processID string,
+	recordIDs []string,
+	options SamplingOptions,
+	internalNote string,
+) error {
+	if len(recordIDs) == 0 {
+		return fmt.Errorf("no records given for sampling")
+	}
+	process, found := db.FindProcess(context.Background(), processID)
+	if !found {
+		return fmt.Errorf("process not found: %s", processID)
+	}
+	rootRecords := db.FindAllRootRecords(context.Background(), processID, db.MessageType0501)
+	m := AppraisableRecords(&rootRecords, AppraisalLevel(process))
+	records, err := orderedSiblings(&rootRecords, m, recordIDs)
+	if err != nil {
+		return err
+	}
+	var sample []string
+	var description string
+	switch options.Method {
+	case SamplingMethodSystematic:
+		sample, description, err = systematicSample(records, options)
+	case SamplingMethodRandom:
+		sample, description, err = randomSample(records, options)
+	case SamplingMethodLetter:
+		sample, description, err = letterSample(records, subjects(&rootRecords), options)
+	default:
+		err = fmt.Errorf("unknown sampling method: \"%s\"", options.Method)
+	}
+	if err != nil {
+		return err
+	}
+	var rest []string
+	for _, id := range records {
+		if !slices.Contains(sample, id) {
+			rest = append(rest, id)
+		}
+	}
+	note := description
+	if internalNote != "" {
+		note += "\n" + internalNote
+	}
+	if len(sample) > 0 {
+		err = SetAppraisals(processID, sample, db.AppraisalDecisionB, note)
+		if err != nil {
+			return err
+		}
+		for _, id := range sample {
+			setAppraisalsOfDescendants(processID, m, id, db.AppraisalDecisionB)
+		}
+	}
+	if len(rest) > 0 {
+		err = SetAppraisals(processID, rest, db.AppraisalDecisionV, note)
+		if err != nil {
+			return err
+		}
+		for _, id := range rest {
+			setAppraisalsOfDescendants(processID, m, id, db.AppraisalDecisionV)
+		}
+	}
+	updateAppraisalProcessStep(processID)
+	return nil
+}
+
+// setAppraisalsOfDescendants sets the given decision for all appraisable
+// descendants of the given record object that have no decision yet.
+//
+// Without this, descendants of sampled records would be left without a
+// decision and marked as discardable when finishing the appraisal. Decisions
+// that were already made for descendants are kept.
+func setAppraisalsOfDescendants(
+	processID string,
+	m AppraisableRecordsMap,
+	recordID string,
+	decision db.AppraisalDecisionOption,
+) {
+	for _, subRecordID := range m[recordID].Children {
+		if a, _ := db.FindAppraisal(processID, subRecordID); a.Decision == "" {
+			db.UpsertAppraisal(processID, subRecordID, decision, "")
+		}
+		setAppraisalsOfDescendants(processID, m, subRecordID, decision)
+	}
+}
+
+// orderedSiblings verifies that all given records share the same parent and
+// returns them in the order they appear in the message.
+func orderedSiblings(
+	rootRecords *db.RootRecords,
+	m AppraisableRecordsMap,
+	recordIDs []string,
+) ([]string, error) {
+	first, ok := m[recordIDs[0]]
+	if !ok {
+		return nil, fmt.Errorf("record object not found: %v", recordIDs[0])
+	}
+	for _, id := range recordIDs[1:] {
+		r, ok := m[id]
+		if !ok {
+			return nil, fmt.Errorf("record object not found: %v", id)
+		}
+		if (r.Parent == nil) != (first.Parent == nil) ||
+			(r.Parent != nil && *r.Parent != *first.Parent) {
+			return nil, fmt.Errorf("record objects are no siblings: %v, %v", recordIDs[0], id)
+		}
+	}
+	var siblings []string
+	if first.Parent != nil {
+		siblings = m[*first.Parent].Children
+	} else {
+		for _, f := range rootRecords.Files {
+			siblings = append(siblings, f.RecordID)
+		}
+		for _, p := range rootRecords.Processes {
+			siblings = append(siblings, p.RecordID)
+		}
+		for _, d := range rootRecords.Documents {
+			siblings = append(siblings, d.RecordID)
+		}
+	}
+	var result []string
+	for _, id := range siblings {
+		if slices.Contains(recordIDs, id) {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+func systematicSample(records []string, options SamplingOptions) ([]string, string, error) {
+	if options.Interval < 1 {
+		return nil, "", fmt.Errorf("invalid sampling interval: %d", options.Interval)
+	}
+	start := options.Start
+	if start == 0 {
+		start = options.Interval
+	} else if start < 1 || start > options.Interval {
+		return nil, "", fmt.Errorf("invalid sampling start: %d", options.Start)
+	}
+	var sample []string
+	for i := start - 1; i < len(records); i += options.Interval {
+		sample = append(sample, records[i])
+	}
+	description := fmt.Sprintf(
+		"Systematische Stichprobe: jedes %d. Schriftgutobjekt ab Position %d",
+		options.Interval, start,
+	)
+	return sample, description, nil
+}
+
+func randomSample(records []string, options SamplingOptions) ([]string, string, error) {
+	if options.Percentage <= 0 || options.Percentage > 100 {
+		return nil, "", fmt.Errorf("invalid sampling percentage: %v", options.Percentage)
+	}
+	seed := options.Seed
+	if seed == 0 {
+		seed = rand.Int64N(1_000_000_000) + 1
+	}
+	n := int(math.Round(float64(len(records)) * options.Percentage / 100))
+	r := rand.New(rand.NewPCG(uint64(seed), 0))
+	selected := r.Perm(len(records))[:n]
+	slices.Sort(selected)
+	var sample []string
+	for _, i := range selected {
+		sample = append(sample, records[i])
+	}
+	description := fmt.Sprintf(
+		"Zufallsstichprobe: %s %% (Seed %d)",
+		strings.Replace(fmt.Sprint(options.Percentage), ".", ",", 1), seed,
+	)
+	return sample, description, nil
+}
+
+func letterSample(
+	records []string,
+	subjects map[string]string,
+	options SamplingOptions,
+) ([]string, string, error) {
+	var letters []rune
+	for _, l := range options.Letters {
+		letter, size := utf8.DecodeRuneInString(strings.TrimSpace(l))
+		if size == 0 || !unicode.IsLetter(letter) {
+			return nil, "", fmt.Errorf("invalid sampling letter: \"%s\"", l)
+		}
+		letters = append(letters, unicode.ToUpper(letter))
+	}
+	if len(letters) == 0 {
+		return nil, "", fmt.Errorf("no sampling letters given")
+	}
+	var sample []string
+	for _, id := range records {
+		initial, _ := utf8.DecodeRuneInString(strings.TrimSpace(subjects[id]))
+		if slices.Contains(letters, unicode.ToUpper(initial)) {
+			sample = append(sample, id)
+		}
+	}
+	letterStrings := make([]string, len(letters))
+	for i, l := range letters {
+		letterStrings[i] = string(l)
+	}
+	description := fmt.Sprintf(
+		"Buchstabenstichprobe: Betreff beginnt mit %s",
+		strings.Join(letterStrings, ", "),
+	)
+	return sample, description, nil
+}
+
+// subjects returns the subjects of all files, processes and documents by record
+// ID.
+func subjects(r *db.RootRecords) map[string]string {
+	result := make(map[string]string)
+	var appendFiles func(files []db.FileRecord)
+	var appendProcesses func(processes []db.ProcessRecord)
+	appendDocuments := func(documents []db.DocumentRecord) {
+		for _, d := range documents {
+			if d.GeneralMetadata != nil {
+				result[d.RecordID] = d.GeneralMetadata.Subject
+			}
+		}
+	}
+	appendFiles = func(files []db.FileRecord) {
+		for _, f := range files {
+			if f.GeneralMetadata != nil {
+				result[f.RecordID] = f.GeneralMetadata.Subject
+			}
+			appendFiles(f.Subfiles)
+			appendProcesses(f.Processes)
+			appendDocuments(f.Documents)
+		}
+	}
+	appendProcesses = func(processes []db.ProcessRecord) {
+		for _, p := range processes {
+			if p.GeneralMetadata != nil {
+				result[p.RecordID] = p.GeneralMetadata.Subject
+			}
+			appendProcesses(p.Subprocesses)
+			appendDocuments(p.Documents)
+		}
+	}
+	appendFiles(r.Files)
+	appendProcesses(r.Processes)
+	appendDocuments(r.Documents)
+	return result
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func testRecords(n int) []string {
+	records := make([]string, n)
+	for i := range records {
+		records[i] = "r" + strconv.Itoa(i+1)
+	}
+	return records
+}
+
+func TestSystematicSample(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  int
+		interval int
+		start    int
+		want     []string
+		wantErr  bool
+	}{
+		{"every record", 3, 1, 0, []string{"r1", "r2", "r3"}, false},
+		{"default start", 10, 3, 0, []string{"r3", "r6", "r9"}, false},
+		{"first record", 10, 3, 1, []string{"r1", "r4", "r7", "r10"}, false},
+		{"start at interval", 10, 4, 4, []string{"r4", "r8"}, false},
+		{"too few records", 2, 3, 0, nil, false},
+		{"zero interval", 10, 0, 0, nil, true},
+		{"negative interval", 10, -1, 0, nil, true},
+		{"negative start", 10, 3, -1, nil, true},
+		{"start after interval", 10, 3, 4, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := SamplingOptions{Method: SamplingMethodSystematic, Interval: tt.interval, Start: tt.start}
+			got, _, err := systematicSample(testRecords(tt.records), options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("systematicSample() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("systematicSample() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomSample(t *testing.T) {
+	tests := []struct {
+		name       string
+		records    int
+		percentage float64
+		wantLen    int
+		wantErr    bool
+	}{
+		{"all records", 10, 100, 10, false},
+		{"half", 10, 50, 5, false},
+		{"rounded up", 10, 15, 2, false},
+		{"rounded down", 10, 14, 1, false},
+		{"fraction", 1000, 0.5, 5, false},
+		{"too small for one record", 10, 1, 0, false},
+		{"zero", 10, 0, 0, true},
+		{"negative", 10, -5, 0, true},
+		{"over 100", 10, 100.5, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := testRecords(tt.records)
+			options := SamplingOptions{Method: SamplingMethodRandom, Percentage: tt.percentage, Seed: 42}
+			got, _, err := randomSample(records, options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("randomSample() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.wantLen {
+				t.Fatalf("randomSample() selected %d records, want %d", len(got), tt.wantLen)
+			}
+			if !slices.IsSortedFunc(got, func(a, b string) int {
+				return slices.Index(records, a) - slices.Index(records, b)
+			}) {
+				t.Errorf("randomSample() = %v, not in record order", got)
+			}
+			again, _, _ := randomSample(records, options)
+			if !slices.Equal(got, again) {
+				t.Errorf("randomSample() = %v and %v with the same seed", got, again)
+			}
+		})
+	}
+}
+
+func TestRandomSampleChoosesSeed(t *testing.T) {
+	options := SamplingOptions{Method: SamplingMethodRandom, Percentage: 50}
+	_, description, err := randomSample(testRecords(10), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if description == "Zufallsstichprobe: 50 % (Seed 0)" {
+		t.Errorf("randomSample() didn't choose a seed: %s", description)
+	}
+}
+
+func TestLetterSample(t *testing.T) {
+	subjects := map[string]string{
+		"r1": "Akte",
+		"r2": "bauantrag",
+		"r3": " Ärger",
+		"r4": "Christbaum",
+		"r5": "",
+	}
+	tests := []struct {
+		name    string
+		letters []string
+		want    []string
+		wantErr bool
+	}{
+		{"single letter", []string{"A"}, []string{"r1"}, false},
+		{"case insensitive", []string{"b", "c"}, []string{"r2", "r4"}, false},
+		{"umlaut", []string{"ä"}, []string{"r3"}, false},
+		{"surrounding spaces", []string{" a "}, []string{"r1"}, false},
+		{"no match", []string{"Z"}, nil, false},
+		{"no letters", nil, nil, true},
+		{"empty letter", []string{""}, nil, true},
+		{"digit", []string{"1"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := SamplingOptions{Method: SamplingMethodLetter, Letters: tt.letters}
+			got, _, err := letterSample(testRecords(5), subjects, options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("letterSample() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("letterSample() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -220,11 +220,13 @@ func getUnknownFilesErrors() map[primitive.ObjectID]db.ProcessingError {
 	return m
 }
 
-func getProcessedTransferFiles(agencyID primitive.ObjectID) map[string]bool {
+// getProcessedTransferFiles returns the known transfer files of the given
+// agency, mapped by path.
+func getProcessedTransferFiles(agencyID primitive.ObjectID) map[string]db.TransferFile {
 	files := db.FindTransferDirFilesForAgency(agencyID)
-	m := make(map[string]bool)
+	m := make(map[string]db.TransferFile)
 	for _, file := range files {
-		m[file.Path] = true
+		m[file.Path] = file
 	}
 	return m
 }
@@ -243,9 +245,13 @@ func readMessagesFromFilesystem(agency db.Agency) error {
 		if err != nil {
 			return err
 		}
+		// known files
+		if f, ok := processedPaths[file.Name()]; ok {
+			checkTransferFileChanged(agency, f, fileInfo)
+			continue
+		}
 		// ignored files
-		if processedPaths[file.Name()] ||
-			file.Name() == ".gitkeep" ||
+		if file.Name() == ".gitkeep" ||
 			isMessagePath(agency.TransferDir, fileInfo) {
 			continue
 		}
@@ -309,7 +315,11 @@ func readMessagesFromWebDAV(agency db.Agency) error {
 	processedPaths := getProcessedTransferFiles(agency.ID)
 	var unknownFiles []string
 	for _, file := range files {
-		if processedPaths[file.Name()] || isMessagePath(agency.TransferDir, file) {
+		if f, ok := processedPaths[file.Name()]; ok {
+			checkTransferFileChanged(agency, f, file)
+			continue
+		}
+		if isMessagePath(agency.TransferDir, file) {
 			continue
 		}
 		if file.IsDir() || !isMessage(file.Name()) {
@@ -548,6 +558,101 @@ func RemoveFileFromTransferDir(agency db.Agency, path string) {
 	db.DeleteTransferFile(agency.ID, path)
 }
 
+// writeFileToTransferDir copies a local file to the given path in the transfer
+// directory of the agency.
+//
+// The file is not marked as known, so it will be processed like a newly
+// delivered file. Returns false if a file already exists at the given path.
+func writeFileToTransferDir(agency db.Agency, localPath, transferPath string) (ok bool) {
+	log.Printf("Writing file to transfer dir for %s: %s\n", agency.Name, transferPath)
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		panic(err)
+	}
+	defer localFile.Close()
+	switch agency.TransferDir.Protocol {
+	case db.ProtocolFile:
+		fullPath := filepath.Join("/", agency.TransferDir.Path, transferPath)
+		f, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			return false
+		} else if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		_, err = io.Copy(f, localFile)
+		if err != nil {
+			panic(err)
+		}
+	case db.ProtocolWebDAV, db.ProtocolWebDAVSecure:
+		client, err := connectWebDAV(agency.TransferDir)
+		if err != nil {
+			panic(err)
+		}
+		_, err = client.Stat(transferPath)
+		if err == nil {
+			return false
+		} else if !gowebdav.IsErrNotFound(err) {
+			panic(err)
+		}
+		err = client.WriteStream(transferPath, localFile, 0644)
+		if err != nil {
+			panic(err)
+		}
+	default:
+		panic("unknown transfer directory scheme")
+	}
+	return true
+}
+
+// RenameFileInTransferDir moves a file on a transfer directory to a new path
+// and updates the entry that marks it as known.
+//
+// Returns false if the file does not exist on the transfer directory.
+func RenameFileInTransferDir(agency db.Agency, processID *string, oldPath, newPath string) (ok bool) {
+	log.Printf("Renaming file in transfer dir for %s: %s -> %s\n", agency.Name, oldPath, newPath)
+	switch agency.TransferDir.Protocol {
+	case db.ProtocolFile:
+		ok = renameFileInLocalFilesystem(agency.TransferDir, oldPath, newPath)
+	case db.ProtocolWebDAV, db.ProtocolWebDAVSecure:
+		ok = renameFileInWebDAV(agency.TransferDir, oldPath, newPath)
+	default:
+		panic("unknown transfer directory scheme")
+	}
+	db.DeleteTransferFile(agency.ID, oldPath)
+	if ok {
+		db.InsertTransferFile(agency.ID, processID, newPath)
+	}
+	return ok
+}
+
+func renameFileInLocalFilesystem(transferDir db.TransferDir, oldPath, newPath string) (ok bool) {
+	fullOldPath := filepath.Join("/", transferDir.Path, oldPath)
+	fullNewPath := filepath.Join("/", transferDir.Path, newPath)
+	err := os.Rename(fullOldPath, fullNewPath)
+	if os.IsNotExist(err) {
+		return false
+	} else if err != nil {
+		panic(err)
+	}
+	return true
+}
+
+func renameFileInWebDAV(transferDir db.TransferDir, oldPath, newPath string) (ok bool) {
+	client, err := connectWebDAV(transferDir)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := client.Stat(oldPath); gowebdav.IsErrNotFound(err) {
+		return false
+	}
+	err = client.Rename(oldPath, newPath, false)
+	if err != nil {
+		panic(err)
+	}
+	return true
+}
+
 // RemoveFileFromLocalFilesystem deletes a file on a local filesystem.
 func RemoveFileFromLocalFilesystem(transferDir db.TransferDir, path string) {
 	fullPath := filepath.Join("/", transferDir.Path, path)
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"lath/xman/internal/db"
+	"lath/xman/internal/errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// changeCheckDelay is the time after the last modification of a known transfer
+// file after which its content is compared with the imported content. This
+// avoids comparing files that are still being written.
+const changeCheckDelay = 10 * time.Second
+
+// fileContentHash returns the hex-encoded SHA-256 hash of the given file.
+func fileContentHash(filePath string) string {
+	f, err := os.Open(filePath)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkDuplicateTransferFile checks whether the agency has already delivered a
+// transfer file with the same content as the given newly received file.
+//
+// If not, it saves the content hash of the file. Otherwise, it returns a
+// processing error. The hash of duplicates is not saved, so only the originally
+// imported file is considered for further comparisons.
+func checkDuplicateTransferFile(agency db.Agency, transferPath string, localPath string) error {
+	hash := fileContentHash(localPath)
+	original, found := db.FindTransferFileByContentHash(agency.ID, hash, transferPath)
+	if !found {
+		db.UpdateTransferFileContentHash(agency.ID, transferPath, hash)
+		return nil
+	}
+	info := fmt.Sprintf(
+		"Die Transferdatei ist inhaltlich identisch mit der bereits empfangenen Transferdatei \"%s\"",
+		original.Path,
+	)
+	if original.ProcessID != nil {
+		info += fmt.Sprintf(" der Aussonderung %s", *original.ProcessID)
+	}
+	info += ".\n\nDie Nachricht wurde nicht erneut eingelesen."
+	return &db.ProcessingError{
+		Title:     "Nachricht bereits empfangen",
+		ErrorType: "duplicate-transfer-file",
+		Info:      info,
+		Data:      original.Path,
+	}
+}
+
+// checkTransferFileChanged compares a known transfer file with the state in
+// which it was imported and creates a processing error if its content has
+// changed.
+//
+// The content is only compared if the size or modification time of the file
+// changed.
+func checkTransferFileChanged(agency db.Agency, f db.TransferFile, info fs.FileInfo) {
+	if f.ContentHash == "" {
+		// Not an imported message or imported before content hashes were
+		// introduced.
+		return
+	}
+	if f.ModTime.IsZero() {
+		// First time we see the file after importing it.
+		db.UpdateTransferFileStat(agency.ID, f.Path, info.Size(), info.ModTime())
+		return
+	}
+	if f.Size == info.Size() && f.ModTime.Equal(info.ModTime()) {
+		return
+	}
+	if time.Since(info.ModTime()) < changeCheckDelay {
+		// Check again with the next scan.
+		return
+	}
+	localPath := CopyMessageFromTransferDirectory(agency, f.Path)
+	defer os.RemoveAll(filepath.Dir(localPath))
+	hash := fileContentHash(localPath)
+	db.UpdateTransferFileStat(agency.ID, f.Path, info.Size(), info.ModTime())
+	if hash == f.ContentHash {
+		return
+	}
+	messageType, err := getMessageTypeImpliedByPath(f.Path)
+	if err != nil {
+		panic(err)
+	}
+	errors.AddProcessingError(db.ProcessingError{
+		Title:     "Transferdatei nach dem Einlesen verändert",
+		ErrorType: "changed-transfer-file",
+		Agency:    &agency,
+		ProcessID: f.ProcessID,
+		// Setting the message type offers reimporting the message.
+		MessageType:  messageType,
+		TransferPath: f.Path,
+		Info: "Die Transferdatei wurde nach dem Einlesen durch eine Datei mit anderem Inhalt ersetzt.\n\n" +
+			"Der geänderte Inhalt wird erst nach erneutem Einlesen der Nachricht übernommen.",
+	})
+}
+
+// reimportTransferFile resolves a processing error about a changed transfer
+// file by deleting the previously imported message if it exists, so the
+// transfer file will be imported again.
+func reimportTransferFile(e db.ProcessingError) error {
+	if e.ProcessID != nil {
+		if _, ok := db.TryFindMessage(context.Background(), *e.ProcessID, e.MessageType); ok {
+			return DeleteMessage(*e.ProcessID, e.MessageType, true)
+		}
+	}
+	db.DeleteTransferFile(e.Agency.ID, e.TransferPath)
+	return nil
+}
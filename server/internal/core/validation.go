@@ -1,40 +1,234 @@
 package core
 
+/*
+#cgo pkg-config: libxml-2.0
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+#include <libxml/xmlschemas.h>
+
+#define MAX_SCHEMA_ERRORS 1000
+
+typedef struct {
+	char *message;
+	int line;
+} schemaError;
+
+typedef struct {
+	schemaError errors[MAX_SCHEMA_ERRORS];
+	// count is the number of collected errors.
+	int count;
+	// total is the number of all errors, including errors that were not
+	// collected because MAX_SCHEMA_ERRORS was reached.
+	int total;
+} schemaErrors;
+
+static void collectSchemaError(void *ctx, xmlErrorPtr error) {
+	schemaErrors *errs = (schemaErrors *)ctx;
+	char *message;
+	size_t len;
+	if (error == NULL || error->message == NULL) {
+		return;
+	}
+	errs->total++;
+	if (errs->count >= MAX_SCHEMA_ERRORS) {
+		return;
+	}
+	message = strdup(error->message);
+	len = strlen(message);
+	if (len > 0 && message[len-1] == '\n') {
+		message[len-1] = '\0';
+	}
+	errs->errors[errs->count].message = message;
+	errs->errors[errs->count].line = error->line;
+	errs->count++;
+}
+
+static int validateFile(uintptr_t schema, const char *path, schemaErrors *errs) {
+	int result;
+	xmlSchemaValidCtxtPtr ctxt = xmlSchemaNewValidCtxt((xmlSchemaPtr)schema);
+	if (ctxt == NULL) {
+		return -1;
+	}
+	xmlSchemaSetValidStructuredErrors(ctxt, collectSchemaError, errs);
+	result = xmlSchemaValidateFile(ctxt, path, 0);
+	xmlSchemaFreeValidCtxt(ctxt);
+	return result;
+}
+*/
+import "C"
+
 import (
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
+	"lath/xman/internal/db"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unsafe"
 
 	"github.com/lestrrat-go/libxml2"
 	"github.com/lestrrat-go/libxml2/types"
 	"github.com/lestrrat-go/libxml2/xsd"
 )
 
+// schemaValidationError holds all errors found by a schema validation of a
+// file.
+type schemaValidationError struct {
+	violations []db.SchemaViolation
+	// total is the number of all violations, which can be more than the
+	// number of collected violations.
+	total int
+}
+
+func (e schemaValidationError) Error() string {
+	var lines []string
+	for _, v := range e.violations {
+		lines = append(lines, v.Message)
+	}
+	if e.total > len(e.violations) {
+		lines = append(lines, fmt.Sprintf("(%d weitere Fehler)", e.total-len(e.violations)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e schemaValidationError) Errors() []error {
+	var errs []error
+	for _, v := range e.violations {
+		errs = append(errs, errors.New(v.Message))
+	}
+	return errs
+}
+
 // validateXdomeaXmlFile performs a xsd schema validation against the XML file of a xdomea message.
 // Returns nil if the schema validation didn't find an error.
-// Returns SchemaValidationError if the schema validation found errors.
-// All schema errors can be extracted from the SchemaValidationError.
+// Returns a schemaValidationError if the schema validation found errors.
+// All schema errors can be extracted from the error.
 // Returns error if another error happened.
+//
+// The file is validated while it is being read, without building a DOM, so
+// memory usage doesn't depend on the size of the file.
 func validateXdomeaXmlFile(xmlPath string, version XdomeaVersion) error {
 	schema, err := xsd.ParseFromFile(version.XSDPath)
 	if err != nil {
 		return err
 	}
 	defer schema.Free()
-	xmlFile, err := os.Open(xmlPath)
-	if err != nil {
-		return err
+	cPath := C.CString(xmlPath)
+	defer C.free(unsafe.Pointer(cPath))
+	errs := (*C.schemaErrors)(C.calloc(1, C.sizeof_schemaErrors))
+	defer func() {
+		for i := 0; i < int(errs.count); i++ {
+			C.free(unsafe.Pointer(errs.errors[i].message))
+		}
+		C.free(unsafe.Pointer(errs))
+	}()
+	result := C.validateFile(C.uintptr_t(schema.Pointer()), cPath, errs)
+	switch {
+	case result == 0:
+		return nil
+	case result < 0:
+		return fmt.Errorf("failed to validate %s", xmlPath)
 	}
-	defer xmlFile.Close()
-	xmlBytes, err := io.ReadAll(xmlFile)
-	if err != nil {
-		return err
+	validationError := schemaValidationError{total: int(errs.total)}
+	for i := 0; i < int(errs.count); i++ {
+		validationError.violations = append(validationError.violations, db.SchemaViolation{
+			Message: C.GoString(errs.errors[i].message),
+			Line:    int(errs.errors[i].line),
+		})
+	}
+	if len(validationError.violations) == 0 {
+		return fmt.Errorf("failed to validate %s", xmlPath)
+	}
+	return validationError
+}
+
+// violationElementRegex matches the element name at the start of schema
+// validation messages, e.g., "Element '{urn:xoev-de:xdomea:schema:3.0.0}Kopf':".
+var violationElementRegex = regexp.MustCompile(`^Element '(?:\{[^}]*\})?([^']+)'`)
+
+// locateSchemaViolations sets the XPath of the given schema violations of the
+// XML file.
+//
+// libxml2 doesn't report the affected node when validating a file while
+// reading it. The XPath is determined by finding the element named in the
+// violation's message whose start or end tag ends on the violation's line.
+// Violations that cannot be located keep an empty XPath.
+func locateSchemaViolations(xmlPath string, violations []db.SchemaViolation) {
+	// Map line numbers to violations by element name.
+	pending := make(map[int]map[string][]int)
+	for i, v := range violations {
+		match := violationElementRegex.FindStringSubmatch(v.Message)
+		if match == nil {
+			continue
+		}
+		if pending[v.Line] == nil {
+			pending[v.Line] = make(map[string][]int)
+		}
+		pending[v.Line][match[1]] = append(pending[v.Line][match[1]], i)
+	}
+	if len(pending) == 0 {
+		return
 	}
-	xml, err := libxml2.Parse(xmlBytes)
+	f, err := os.Open(xmlPath)
 	if err != nil {
-		return err
+		panic(err)
+	}
+	defer f.Close()
+	type pathElement struct {
+		name     string
+		index    int
+		children map[string]int
+	}
+	stack := []*pathElement{{children: make(map[string]int)}}
+	xpath := func() string {
+		var b strings.Builder
+		for _, e := range stack[1:] {
+			b.WriteString("/" + e.name + "[" + strconv.Itoa(e.index) + "]")
+		}
+		return b.String()
+	}
+	locate := func(name string, line int) {
+		indices := pending[line][name]
+		if len(indices) == 0 {
+			return
+		}
+		p := xpath()
+		for _, i := range indices {
+			violations[i].XPath = p
+		}
+		delete(pending[line], name)
+	}
+	d := xml.NewDecoder(f)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// The file was validated, so this is not expected. Keep the
+			// violations located so far.
+			return
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			parent := stack[len(stack)-1]
+			parent.children[t.Name.Local]++
+			stack = append(stack, &pathElement{
+				name:     t.Name.Local,
+				index:    parent.children[t.Name.Local],
+				children: make(map[string]int),
+			})
+			line, _ := d.InputPos()
+			locate(t.Name.Local, line)
+		case xml.EndElement:
+			line, _ := d.InputPos()
+			locate(t.Name.Local, line)
+			stack = stack[:len(stack)-1]
+		}
 	}
-	defer xml.Free()
-	return validateXdomeaXml(schema, xml)
 }
 
 // ValidateXdomeaXmlString performs a xsd schema validation against the XML code of a xdomea message.
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"lath/xman/internal/db"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocateSchemaViolations(t *testing.T) {
+	xmlPath := filepath.Join(t.TempDir(), "message.xml")
+	lines := []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`<xdomea:Aussonderung xmlns:xdomea="urn:xoev-de:xdomea:schema:3.0.0">`,
+		`  <xdomea:Kopf>`,
+		`    <xdomea:ProzessID>1</xdomea:ProzessID>`,
+		`  </xdomea:Kopf>`,
+		`  <xdomea:Schriftgutobjekt>`,
+		`    <xdomea:Akte/>`,
+		`  </xdomea:Schriftgutobjekt>`,
+		`  <xdomea:Schriftgutobjekt>`,
+		`    <xdomea:Akte`,
+		`      id="1">`,
+		`    </xdomea:Akte>`,
+		`  </xdomea:Schriftgutobjekt>`,
+		`</xdomea:Aussonderung>`,
+	}
+	err := os.WriteFile(xmlPath, []byte(strings.Join(lines, "\n")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		message string
+		line    int
+		want    string
+	}{
+		{"start tag", "Element '{urn:xoev-de:xdomea:schema:3.0.0}Kopf': This element is not expected.", 3, "/Aussonderung[1]/Kopf[1]"},
+		{"end tag", "Element '{urn:xoev-de:xdomea:schema:3.0.0}Kopf': Missing child element(s).", 5, "/Aussonderung[1]/Kopf[1]"},
+		{"start and end tag on same line", "Element '{urn:xoev-de:xdomea:schema:3.0.0}ProzessID': 'x' is not a valid value.", 4, "/Aussonderung[1]/Kopf[1]/ProzessID[1]"},
+		{"empty element", "Element '{urn:xoev-de:xdomea:schema:3.0.0}Akte': Missing child element(s).", 7, "/Aussonderung[1]/Schriftgutobjekt[1]/Akte[1]"},
+		{"second sibling", "Element '{urn:xoev-de:xdomea:schema:3.0.0}Schriftgutobjekt': Missing child element(s).", 9, "/Aussonderung[1]/Schriftgutobjekt[2]"},
+		{"start tag on several lines", "Element '{urn:xoev-de:xdomea:schema:3.0.0}Akte', attribute 'id': The attribute 'id' is not allowed.", 11, "/Aussonderung[1]/Schriftgutobjekt[2]/Akte[1]"},
+		{"without namespace", "Element 'Akte': Missing child element(s).", 12, "/Aussonderung[1]/Schriftgutobjekt[2]/Akte[1]"},
+		{"root element", "Element '{urn:xoev-de:xdomea:schema:3.0.0}Aussonderung': Missing child element(s).", 14, "/Aussonderung[1]"},
+		{"element not on line", "Element '{urn:xoev-de:xdomea:schema:3.0.0}Akte': Missing child element(s).", 3, ""},
+		{"unknown element", "Element '{urn:xoev-de:xdomea:schema:3.0.0}Vorgang': Missing child element(s).", 7, ""},
+		{"no element in message", "Premature end of data.", 7, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := []db.SchemaViolation{{Message: tt.message, Line: tt.line}}
+			locateSchemaViolations(xmlPath, violations)
+			if violations[0].XPath != tt.want {
+				t.Errorf("XPath = %q, want %q", violations[0].XPath, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocateSchemaViolationsSameElement(t *testing.T) {
+	xmlPath := filepath.Join(t.TempDir(), "message.xml")
+	err := os.WriteFile(xmlPath, []byte("<a>\n<b/>\n<b/>\n</a>"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations := []db.SchemaViolation{
+		{Message: "Element 'b': first violation.", Line: 3},
+		{Message: "Element 'b': second violation.", Line: 3},
+		{Message: "Element 'b': third violation.", Line: 2},
+	}
+	locateSchemaViolations(xmlPath, violations)
+	want := []string{"/a[1]/b[2]", "/a[1]/b[2]", "/a[1]/b[1]"}
+	for i, v := range violations {
+		if v.XPath != want[i] {
+			t.Errorf("violations[%d].XPath = %q, want %q", i, v.XPath, want[i])
+		}
+	}
+}
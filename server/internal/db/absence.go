@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Absence is a period in which a user is represented by a deputy.
+//
+// While the absence is active, the deputy receives the notifications of the
+// user and can access and appraise the processes of the user's agencies.
+type Absence struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	UserID   string             `bson:"user_id" json:"userId"`
+	DeputyID string             `bson:"deputy_id" json:"deputyId"`
+	// From is the start of the absence.
+	From time.Time `bson:"from" json:"from"`
+	// To is the end of the absence, exclusive.
+	To        time.Time `bson:"to" json:"to"`
+	CreatedAt time.Time `bson:"created_at" json:"createdAt"`
+}
+
+// InsertAbsence saves a new absence to the database.
+func InsertAbsence(a Absence) {
+	coll := mongoDatabase.Collection("absences")
+	_, err := coll.InsertOne(context.Background(), a)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// FindAbsence returns the absence with the given ID.
+func FindAbsence(ctx context.Context, id primitive.ObjectID) (a Absence, ok bool) {
+	coll := mongoDatabase.Collection("absences")
+	filter := bson.D{{"_id", id}}
+	err := coll.FindOne(ctx, filter).Decode(&a)
+	return a, handleError(ctx, err)
+}
+
+// FindAbsencesForUser returns the absences of the given user or of the users
+// the given user is deputy for that end after the given time.
+func FindAbsencesForUser(ctx context.Context, userID string, endAfter time.Time) []Absence {
+	filter := bson.D{
+		{"$or", bson.A{
+			bson.D{{"user_id", userID}},
+			bson.D{{"deputy_id", userID}},
+		}},
+		{"to", bson.D{{"$gt", endAfter}}},
+	}
+	return findAbsences(ctx, filter)
+}
+
+// FindActiveAbsence returns the absence of the given user at the given time.
+func FindActiveAbsence(ctx context.Context, userID string, at time.Time) (a Absence, ok bool) {
+	coll := mongoDatabase.Collection("absences")
+	filter := bson.D{
+		{"user_id", userID},
+		{"from", bson.D{{"$lte", at}}},
+		{"to", bson.D{{"$gt", at}}},
+	}
+	err := coll.FindOne(ctx, filter).Decode(&a)
+	return a, handleError(ctx, err)
+}
+
+// FindActiveAbsencesForDeputy returns the absences at the given time in which
+// the given user is the deputy.
+func FindActiveAbsencesForDeputy(ctx context.Context, deputyID string, at time.Time) []Absence {
+	filter := bson.D{
+		{"deputy_id", deputyID},
+		{"from", bson.D{{"$lte", at}}},
+		{"to", bson.D{{"$gt", at}}},
+	}
+	return findAbsences(ctx, filter)
+}
+
+// HasOverlappingAbsence returns true if the given user has an absence that
+// overlaps with the given period.
+func HasOverlappingAbsence(ctx context.Context, userID string, from time.Time, to time.Time) bool {
+	coll := mongoDatabase.Collection("absences")
+	filter := bson.D{
+		{"user_id", userID},
+		{"from", bson.D{{"$lt", to}}},
+		{"to", bson.D{{"$gt", from}}},
+	}
+	n, err := coll.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	handleError(ctx, err)
+	return n > 0
+}
+
+// DeleteAbsence deletes the absence with the given ID.
+func DeleteAbsence(id primitive.ObjectID) (ok bool) {
+	coll := mongoDatabase.Collection("absences")
+	filter := bson.D{{"_id", id}}
+	result, err := coll.DeleteOne(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+	return result.DeletedCount > 0
+}
+
+// DeleteAbsencesEndedBefore deletes all absences that ended before the given
+// time.
+func DeleteAbsencesEndedBefore(t time.Time) {
+	coll := mongoDatabase.Collection("absences")
+	filter := bson.D{{"to", bson.D{{"$lt", t}}}}
+	_, err := coll.DeleteMany(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func findAbsences(ctx context.Context, filter bson.D) []Absence {
+	coll := mongoDatabase.Collection("absences")
+	opts := options.Find().SetSort(bson.D{{"from", 1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	handleError(ctx, err)
+	absences := make([]Absence, 0)
+	err = cursor.All(ctx, &absences)
+	handleError(ctx, err)
+	return absences
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AccessDenial records a request that was denied because the user lacked the
+// permission for the requested process or agency.
+type AccessDenial struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
+	UserID    string             `bson:"user_id" json:"userId"`
+	// Permission is the permission that was required for the request.
+	Permission string              `bson:"permission" json:"permission"`
+	Method     string              `bson:"method" json:"method"`
+	Path       string              `bson:"path" json:"path"`
+	ProcessID  string              `bson:"process_id,omitempty" json:"processId,omitempty"`
+	AgencyID   *primitive.ObjectID `bson:"agency_id,omitempty" json:"agencyId,omitempty"`
+	ClientIP   string              `bson:"client_ip" json:"clientIp"`
+}
+
+// InsertAccessDenial saves a denied request to the database.
+func InsertAccessDenial(d AccessDenial) {
+	coll := mongoDatabase.Collection("access_denials")
+	d.ID = primitive.NewObjectID()
+	_, err := coll.InsertOne(context.Background(), d)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// FindAccessDenials returns a page of denied requests, newest first, together
+// with the total number of matching entries.
+//
+// If userID is not empty, only requests of the given user are returned.
+func FindAccessDenials(
+	ctx context.Context,
+	userID string,
+	offset, limit int,
+) (denials []AccessDenial, total int) {
+	coll := mongoDatabase.Collection("access_denials")
+	match := bson.D{}
+	if userID != "" {
+		match = append(match, bson.E{"user_id", userID})
+	}
+	// The total is counted separately since combining it with the results in
+	// a single document could exceed the maximum document size.
+	n, err := coll.CountDocuments(ctx, match)
+	if !handleError(ctx, err) {
+		return nil, 0
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"created_at", -1}, {"_id", -1}}).
+		SetSkip(int64(offset))
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := coll.Find(ctx, match, opts)
+	if !handleError(ctx, err) {
+		return nil, 0
+	}
+	err = cursor.All(ctx, &denials)
+	handleError(ctx, err)
+	return denials, int(n)
+}
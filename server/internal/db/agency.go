@@ -2,9 +2,11 @@ package db
 
 import (
 	"context"
+	"slices"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Agency represents an institution as configured in the administration panel.
@@ -26,9 +28,56 @@ type Agency struct {
 	// ContactEmail is the e-mail address to use to contact the agency.
 	ContactEmail string `bson:"contact_email" json:"contactEmail"`
 	// Users are users responsible for processes of this Agency.
-	Users        []string            `json:"users"`
+	Users []string `json:"users"`
+	// Groups are LDAP or OpenID Connect groups whose members are treated as
+	// assigned users in addition to Users.
+	Groups []string `bson:"groups" json:"groups"`
+	// GroupUsers are the members of Groups with access to x-man. They are
+	// kept in sync by the directory synchronization and can't be set via the
+	// API.
+	GroupUsers   []string            `bson:"group_users" json:"groupUsers"`
 	CollectionID *primitive.ObjectID `bson:"collection_id" json:"collectionId"`
 	TransferDir  TransferDir         `json:"transferDir"`
+	// AppraisalLevel overrides the globally configured appraisal level for
+	// processes of this agency if set.
+	AppraisalLevel AppraisalLevel `bson:"appraisal_level" json:"appraisalLevel"`
+	// MaxMessageSizeMB overrides the globally configured maximum uncompressed
+	// size of messages of this agency if greater than 0.
+	MaxMessageSizeMB int64 `bson:"max_message_size_mb" json:"maxMessageSizeMB"`
+	// MaxCompressionRatio overrides the globally configured maximum
+	// compression ratio of files in messages of this agency if greater than 0.
+	MaxCompressionRatio int64 `bson:"max_compression_ratio" json:"maxCompressionRatio"`
+	// LenientSchemaValidation allows importing messages of this agency that
+	// violate the xdomea schema. Violations are recorded as warnings and must
+	// be acknowledged by an administrator before archiving.
+	LenientSchemaValidation bool `bson:"lenient_schema_validation" json:"lenientSchemaValidation"`
+}
+
+// AssignedUsers returns the users that are assigned to the agency, either
+// directly or as members of one of its groups.
+func (a Agency) AssignedUsers() []string {
+	users := slices.Clone(a.Users)
+	for _, u := range a.GroupUsers {
+		if !slices.Contains(users, u) {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// IsAssigned returns true if the given user is assigned to the agency, either
+// directly or as member of one of its groups.
+func (a Agency) IsAssigned(userID string) bool {
+	return slices.Contains(a.Users, userID) || slices.Contains(a.GroupUsers, userID)
+}
+
+// assignedUserFilter returns a filter for documents whose agency at the given
+// path has any of the given users assigned.
+func assignedUserFilter(path string, userIDs ...string) bson.D {
+	return bson.D{{"$or", bson.A{
+		bson.D{{path + "users", bson.D{{"$in", userIDs}}}},
+		bson.D{{path + "group_users", bson.D{{"$in", userIDs}}}},
+	}}}
 }
 
 type TransferProtocol string
@@ -60,13 +109,26 @@ func FindAgencies(ctx context.Context) []Agency {
 }
 
 func FindAgenciesForUser(ctx context.Context, userID string) []Agency {
-	return findAgencies(ctx, bson.D{{"users", bson.D{{"$all", bson.A{userID}}}}})
+	return findAgencies(ctx, assignedUserFilter("", userID))
+}
+
+// FindAgenciesForUsers returns the agencies any of the given users is
+// assigned to.
+func FindAgenciesForUsers(ctx context.Context, userIDs []string) []Agency {
+	return findAgencies(ctx, assignedUserFilter("", userIDs...))
 }
 
 func FindAgenciesForCollection(ctx context.Context, collectionID primitive.ObjectID) []Agency {
 	return findAgencies(ctx, bson.D{{"collection_id", collectionID}})
 }
 
+func FindAgency(ctx context.Context, id primitive.ObjectID) (agency Agency, ok bool) {
+	coll := mongoDatabase.Collection("agencies")
+	filter := bson.D{{"_id", id}}
+	err := coll.FindOne(ctx, filter).Decode(&agency)
+	return agency, handleError(ctx, err)
+}
+
 func findAgencies(ctx context.Context, filter bson.D) []Agency {
 	coll := mongoDatabase.Collection("agencies")
 	var agencies []Agency
@@ -91,11 +153,39 @@ func ReplaceAgency(agency Agency) (ok bool) {
 	agency.SchemaVersion = 2
 	coll := mongoDatabase.Collection("agencies")
 	filter := bson.D{{"_id", agency.ID}}
-	result, err := coll.ReplaceOne(context.Background(), filter, agency)
+	// The group users are set by the directory synchronization and kept, so
+	// a synchronization running at the same time isn't undone.
+	data, err := bson.Marshal(agency)
 	if err != nil {
 		panic(err)
 	}
-	if result.MatchedCount == 0 {
+	var fields bson.D
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		panic(err)
+	}
+	fields = slices.DeleteFunc(fields, func(e bson.E) bool {
+		return e.Key == "_id" || e.Key == "group_users"
+	})
+	update := bson.D{{"$set", fields}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = coll.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&agency)
+	if !handleError(context.Background(), err) {
+		return false
+	}
+	updateAgencyForProcesses(agency)
+	updateAgencyForProcessingErrors(agency)
+	return true
+}
+
+// UpdateAgencyGroupUsers sets the members of the groups of the given agency.
+func UpdateAgencyGroupUsers(id primitive.ObjectID, groupUsers []string) (ok bool) {
+	coll := mongoDatabase.Collection("agencies")
+	filter := bson.D{{"_id", id}}
+	update := bson.D{{"$set", bson.D{{"group_users", groupUsers}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var agency Agency
+	err := coll.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&agency)
+	if !handleError(context.Background(), err) {
 		return false
 	}
 	updateAgencyForProcesses(agency)
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIToken is a long-lived token for scripted access to the API.
+//
+// Only a hash of the token is stored. The token itself is shown once when it
+// is created.
+type APIToken struct {
+	ID   primitive.ObjectID `bson:"_id" json:"id"`
+	Name string             `bson:"name" json:"name"`
+	// Hash is the hex-encoded SHA-256 hash of the token.
+	Hash string `bson:"hash" json:"-"`
+	// Prefix is the beginning of the token to help identifying it.
+	Prefix string `bson:"prefix" json:"prefix"`
+	// Permissions are the permissions granted to the token.
+	Permissions []string `bson:"permissions" json:"permissions"`
+	// AgencyIDs restrict the permissions to the given agencies. If empty, the
+	// permissions are granted for all agencies.
+	AgencyIDs  []primitive.ObjectID `bson:"agency_ids" json:"agencyIds"`
+	CreatedAt  time.Time            `bson:"created_at" json:"createdAt"`
+	CreatedBy  string               `bson:"created_by" json:"createdBy"`
+	ExpiresAt  time.Time            `bson:"expires_at" json:"expiresAt"`
+	LastUsedAt *time.Time           `bson:"last_used_at" json:"lastUsedAt"`
+	RevokedAt  *time.Time           `bson:"revoked_at" json:"revokedAt"`
+	RevokedBy  string               `bson:"revoked_by" json:"revokedBy,omitempty"`
+}
+
+// InsertAPIToken saves a new API token to the database.
+func InsertAPIToken(t APIToken) {
+	coll := mongoDatabase.Collection("api_tokens")
+	_, err := coll.InsertOne(context.Background(), t)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// FindAPIToken returns the API token with the given ID.
+func FindAPIToken(ctx context.Context, id primitive.ObjectID) (t APIToken, ok bool) {
+	coll := mongoDatabase.Collection("api_tokens")
+	filter := bson.D{{"_id", id}}
+	err := coll.FindOne(ctx, filter).Decode(&t)
+	return t, handleError(ctx, err)
+}
+
+// FindAPITokenByHash returns the API token with the given hash.
+func FindAPITokenByHash(ctx context.Context, hash string) (t APIToken, ok bool) {
+	coll := mongoDatabase.Collection("api_tokens")
+	filter := bson.D{{"hash", hash}}
+	err := coll.FindOne(ctx, filter).Decode(&t)
+	return t, handleError(ctx, err)
+}
+
+// FindAPITokens returns all API tokens, newest first.
+func FindAPITokens(ctx context.Context) []APIToken {
+	coll := mongoDatabase.Collection("api_tokens")
+	opts := options.Find().SetSort(bson.D{{"created_at", -1}})
+	cursor, err := coll.Find(ctx, bson.D{}, opts)
+	handleError(ctx, err)
+	var tokens []APIToken
+	err = cursor.All(ctx, &tokens)
+	handleError(ctx, err)
+	return tokens
+}
+
+// UpdateAPITokenLastUsed sets the time the token was last used.
+func UpdateAPITokenLastUsed(id primitive.ObjectID, t time.Time) {
+	coll := mongoDatabase.Collection("api_tokens")
+	update := bson.D{{"$set", bson.D{{"last_used_at", t}}}}
+	_, err := coll.UpdateByID(context.Background(), id, update)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// UpdateAPITokenRevoked revokes the token with the given ID.
+func UpdateAPITokenRevoked(id primitive.ObjectID, revokedBy string) (ok bool) {
+	coll := mongoDatabase.Collection("api_tokens")
+	filter := bson.D{{"_id", id}, {"revoked_at", nil}}
+	update := bson.D{{"$set", bson.D{
+		{"revoked_at", time.Now()},
+		{"revoked_by", revokedBy},
+	}}}
+	result, err := coll.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		panic(err)
+	}
+	return result.MatchedCount > 0
+}
@@ -13,15 +13,49 @@ type AppraisalDecisionOption string
 const (
 	AppraisalDecisionEmpty AppraisalDecisionOption = ""
 	AppraisalDecisionA     AppraisalDecisionOption = "A"
-	AppraisalDecisionB     AppraisalDecisionOption = "B"
-	AppraisalDecisionV     AppraisalDecisionOption = "V"
+	// AppraisalDecisionB marks records that have been selected by a sample.
+	// They are archived just like records marked "A".
+	AppraisalDecisionB AppraisalDecisionOption = "B"
+	AppraisalDecisionV AppraisalDecisionOption = "V"
+)
+
+// ToBeArchived returns true for decisions that cause a record to be archived.
+func (d AppraisalDecisionOption) ToBeArchived() bool {
+	return d == AppraisalDecisionA || d == AppraisalDecisionB
+}
+
+// IsComplete returns true for any final appraisal decision.
+func (d AppraisalDecisionOption) IsComplete() bool {
+	return d.ToBeArchived() || d == AppraisalDecisionV
+}
+
+// AppraisalLevel determines which records can be appraised.
+type AppraisalLevel string
+
+const (
+	// AppraisalLevelDefault defers to the next higher configuration level.
+	AppraisalLevelDefault AppraisalLevel = ""
+	// AppraisalLevelRoot allows appraisal of root-level files and processes.
+	AppraisalLevelRoot AppraisalLevel = "root"
+	// AppraisalLevelAll allows appraisal of files and processes on all levels.
+	AppraisalLevelAll AppraisalLevel = "all"
+	// AppraisalLevelDocument allows appraisal of files, processes and documents
+	// on all levels. Documents carry their own appraisal decisions.
+	AppraisalLevelDocument AppraisalLevel = "document"
 )
 
 type Appraisal struct {
 	ProcessID string                  `bson:"process_id" json:"-"`
 	RecordID  string                  `bson:"record_id" json:"recordId"`
 	Decision  AppraisalDecisionOption `json:"decision"`
-	Note      string                  `json:"note"`
+	// Note is an internal note that is only visible to archivists.
+	Note string `json:"note"`
+	// PublicNote explains the appraisal decision to the agency and is
+	// included in the 0502 message.
+	PublicNote string `bson:"public_note" json:"publicNote"`
+	// Implicit is true if the decision was set automatically when finalizing
+	// the appraisal rather than by a user.
+	Implicit bool `json:"implicit"`
 }
 
 func FindAppraisalsForProcess(ctx context.Context, processID string) []Appraisal {
@@ -59,6 +93,7 @@ func UpsertAppraisal(
 	upsertAppraisal(processID, recordID, bson.D{
 		{"decision", decision},
 		{"note", note},
+		{"implicit", false},
 	})
 }
 
@@ -69,9 +104,40 @@ func UpsertAppraisalDecision(
 ) {
 	upsertAppraisal(processID, recordID, bson.D{
 		{"decision", decision},
+		{"implicit", false},
+	})
+}
+
+// UpsertImplicitAppraisalDecision saves a decision that was not made by a user.
+func UpsertImplicitAppraisalDecision(
+	processID string,
+	recordID string,
+	decision AppraisalDecisionOption,
+) {
+	upsertAppraisal(processID, recordID, bson.D{
+		{"decision", decision},
+		{"implicit", true},
 	})
 }
 
+// ResetImplicitAppraisalDecisions clears all implicitly set decisions of the
+// given process.
+func ResetImplicitAppraisalDecisions(processID string) {
+	coll := mongoDatabase.Collection("appraisals")
+	filter := bson.D{
+		{"process_id", processID},
+		{"implicit", true},
+	}
+	update := bson.D{{"$set", bson.D{
+		{"decision", AppraisalDecisionEmpty},
+		{"implicit", false},
+	}}}
+	_, err := coll.UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func UpsertAppraisalNote(
 	processID string,
 	recordID string,
@@ -82,6 +148,16 @@ func UpsertAppraisalNote(
 	})
 }
 
+func UpsertAppraisalPublicNote(
+	processID string,
+	recordID string,
+	publicNote string,
+) {
+	upsertAppraisal(processID, recordID, bson.D{
+		{"public_note", publicNote},
+	})
+}
+
 func upsertAppraisal(
 	processID string,
 	recordID string,
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AppraisalStatisticsEntry records the final appraisal decision of a single
+// record.
+//
+// Entries are created when the appraisal of a submission process is finalized
+// and are kept when the submission process is deleted, so statistics can
+// include archived processes.
+type AppraisalStatisticsEntry struct {
+	ProcessID   string             `bson:"process_id"`
+	AgencyID    primitive.ObjectID `bson:"agency_id"`
+	AppraisedAt time.Time          `bson:"appraised_at"`
+	RecordID    string             `bson:"record_id"`
+	RecordType  RecordType         `bson:"record_type"`
+	// IsSubRecord is true for all records that are not on the root level of
+	// the message.
+	IsSubRecord bool `bson:"is_sub_record"`
+	// FilePlanNumber and FilePlanSubject describe the file-plan unit of the
+	// record, or of its nearest ancestor if the record has no file-plan unit.
+	FilePlanNumber  string `bson:"file_plan_number"`
+	FilePlanSubject string `bson:"file_plan_subject"`
+	// Recommendation is the appraisal recommendation of the agency.
+	Recommendation string                  `bson:"recommendation"`
+	Decision       AppraisalDecisionOption `bson:"decision"`
+}
+
+// AppraisalStatisticsGroup contains aggregated counts of appraisal statistics
+// entries with the same agency, file-plan unit and record type.
+type AppraisalStatisticsGroup struct {
+	AgencyID        primitive.ObjectID `bson:"agency_id"`
+	FilePlanNumber  string             `bson:"file_plan_number"`
+	FilePlanSubject string             `bson:"file_plan_subject"`
+	RecordType      RecordType         `bson:"record_type"`
+	IsSubRecord     bool               `bson:"is_sub_record"`
+	Offered         int                `bson:"offered"`
+	Archived        int                `bson:"archived"`
+	Sampled         int                `bson:"sampled"`
+	Discarded       int                `bson:"discarded"`
+	// Recommended is the number of records with an appraisal recommendation
+	// to archive ("A") or to discard ("V").
+	Recommended int `bson:"recommended"`
+	// Agreed is the number of records of which the appraisal decision has the
+	// same outcome (archive or discard) as the appraisal recommendation.
+	Agreed int `bson:"agreed"`
+}
+
+// ReplaceAppraisalStatisticsForProcess replaces all appraisal statistics
+// entries of the given process with the given entries.
+func ReplaceAppraisalStatisticsForProcess(processID string, entries []AppraisalStatisticsEntry) {
+	DeleteAppraisalStatisticsForProcess(processID)
+	if len(entries) == 0 {
+		return
+	}
+	coll := mongoDatabase.Collection("appraisal_statistics")
+	docs := make([]interface{}, len(entries))
+	for i, e := range entries {
+		docs[i] = e
+	}
+	_, err := coll.InsertMany(context.Background(), docs)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func DeleteAppraisalStatisticsForProcess(processID string) {
+	coll := mongoDatabase.Collection("appraisal_statistics")
+	filter := bson.D{{"process_id", processID}}
+	_, err := coll.DeleteMany(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// HasAppraisalStatisticsForProcess returns true if there are appraisal
+// statistics entries for the given process.
+func HasAppraisalStatisticsForProcess(ctx context.Context, processID string) bool {
+	coll := mongoDatabase.Collection("appraisal_statistics")
+	filter := bson.D{{"process_id", processID}}
+	n, err := coll.CountDocuments(ctx, filter)
+	handleError(ctx, err)
+	return n > 0
+}
+
+// AggregateAppraisalStatistics groups all appraisal statistics entries of
+// appraisals finalized in the time range [from, to) by agency, file-plan unit
+// and record type.
+//
+// If agencyID is nil, entries of all agencies are included. Zero times are
+// ignored.
+func AggregateAppraisalStatistics(
+	ctx context.Context,
+	agencyID *primitive.ObjectID,
+	from, to time.Time,
+) []AppraisalStatisticsGroup {
+	coll := mongoDatabase.Collection("appraisal_statistics")
+	match := bson.D{}
+	if agencyID != nil {
+		match = append(match, bson.E{"agency_id", *agencyID})
+	}
+	timeRange := bson.D{}
+	if !from.IsZero() {
+		timeRange = append(timeRange, bson.E{"$gte", from})
+	}
+	if !to.IsZero() {
+		timeRange = append(timeRange, bson.E{"$lt", to})
+	}
+	if len(timeRange) > 0 {
+		match = append(match, bson.E{"appraised_at", timeRange})
+	}
+	countIf := func(condition bson.D) bson.D {
+		return bson.D{{"$sum", bson.D{{"$cond", bson.A{condition, 1, 0}}}}}
+	}
+	matchStage := bson.D{{"$match", match}}
+	groupStage := bson.D{{"$group", bson.D{
+		{"_id", bson.D{
+			{"agency_id", "$agency_id"},
+			{"file_plan_number", "$file_plan_number"},
+			{"record_type", "$record_type"},
+			{"is_sub_record", "$is_sub_record"},
+		}},
+		{"file_plan_subject", bson.D{{"$first", "$file_plan_subject"}}},
+		{"offered", bson.D{{"$sum", 1}}},
+		{"archived", countIf(bson.D{{"$in", bson.A{
+			"$decision", bson.A{AppraisalDecisionA, AppraisalDecisionB},
+		}}})},
+		{"sampled", countIf(bson.D{{"$eq", bson.A{"$decision", AppraisalDecisionB}}})},
+		{"discarded", countIf(bson.D{{"$eq", bson.A{"$decision", AppraisalDecisionV}}})},
+		{"recommended", countIf(bson.D{{"$in", bson.A{
+			"$recommendation", bson.A{AppraisalDecisionA, AppraisalDecisionV},
+		}}})},
+		// A recommendation "A" agrees with the decisions "A" and "B" since
+		// records to be sampled are partly archived. The recommendation "B"
+		// only asks the archive to appraise the record and is never counted.
+		{"agreed", countIf(bson.D{{"$or", bson.A{
+			bson.D{{"$and", bson.A{
+				bson.D{{"$eq", bson.A{"$recommendation", AppraisalDecisionA}}},
+				bson.D{{"$in", bson.A{"$decision", bson.A{AppraisalDecisionA, AppraisalDecisionB}}}},
+			}}},
+			bson.D{{"$and", bson.A{
+				bson.D{{"$eq", bson.A{"$recommendation", AppraisalDecisionV}}},
+				bson.D{{"$eq", bson.A{"$decision", AppraisalDecisionV}}},
+			}}},
+		}}})},
+	}}}
+	projectStage := bson.D{{"$project", bson.D{
+		{"_id", 0},
+		{"agency_id", "$_id.agency_id"},
+		{"file_plan_number", "$_id.file_plan_number"},
+		{"record_type", "$_id.record_type"},
+		{"is_sub_record", "$_id.is_sub_record"},
+		{"file_plan_subject", 1},
+		{"offered", 1},
+		{"archived", 1},
+		{"sampled", 1},
+		{"discarded", 1},
+		{"recommended", 1},
+		{"agreed", 1},
+	}}}
+	sortStage := bson.D{{"$sort", bson.D{
+		{"agency_id", 1},
+		{"file_plan_number", 1},
+	}}}
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, projectStage, sortStage})
+	handleError(ctx, err)
+	var groups []AppraisalStatisticsGroup
+	err = cursor.All(ctx, &groups)
+	handleError(ctx, err)
+	return groups
+}
@@ -39,6 +39,9 @@ type ArchivePackage struct {
 	// PrimaryDocuments are all primary documents contained in the archive
 	// package.
 	PrimaryDocuments []PrimaryDocumentContext `bson:"primary_documents"`
+	// ExcludedRecordIDs are the RecordIDs of documents that have been
+	// appraised "V" and are to be removed from the packaged message.
+	ExcludedRecordIDs []string `bson:"excluded_record_ids"`
 	// PackageID is the ID assigned by DIMAG when importing the package.
 	PackageID string `bson:"package_id"`
 }
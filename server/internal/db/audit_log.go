@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEntry records an action of a user, e.g., the download of a primary
+// document or the deletion of a process.
+//
+// Audit entries are never changed. They are only deleted after the retention
+// period.
+type AuditEntry struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
+	UserID    string             `bson:"user_id" json:"userId"`
+	// UserName is the display name of the user at the time of the action.
+	UserName  string              `bson:"user_name" json:"userName"`
+	Action    string              `bson:"action" json:"action"`
+	ProcessID string              `bson:"process_id,omitempty" json:"processId,omitempty"`
+	AgencyID  *primitive.ObjectID `bson:"agency_id,omitempty" json:"agencyId,omitempty"`
+	// TargetIDs identify the objects of the action within the process or
+	// agency, e.g., record IDs, message types or filenames.
+	TargetIDs []string `bson:"target_ids" json:"targetIds"`
+	ClientIP  string   `bson:"client_ip,omitempty" json:"clientIp,omitempty"`
+}
+
+// AuditQuery filters audit entries. Empty fields are ignored.
+type AuditQuery struct {
+	UserID    string
+	Action    string
+	ProcessID string
+	AgencyID  *primitive.ObjectID
+	// From and To restrict the time of the action to [from, to).
+	From   time.Time
+	To     time.Time
+	Offset int
+	// Limit is the maximum number of entries to return. 0 means no limit.
+	Limit int
+}
+
+func (q AuditQuery) filter() bson.D {
+	filter := bson.D{}
+	if q.UserID != "" {
+		filter = append(filter, bson.E{"user_id", q.UserID})
+	}
+	if q.Action != "" {
+		filter = append(filter, bson.E{"action", q.Action})
+	}
+	if q.ProcessID != "" {
+		filter = append(filter, bson.E{"process_id", q.ProcessID})
+	}
+	if q.AgencyID != nil {
+		filter = append(filter, bson.E{"agency_id", *q.AgencyID})
+	}
+	createdAt := bson.D{}
+	if !q.From.IsZero() {
+		createdAt = append(createdAt, bson.E{"$gte", q.From})
+	}
+	if !q.To.IsZero() {
+		createdAt = append(createdAt, bson.E{"$lt", q.To})
+	}
+	if len(createdAt) > 0 {
+		filter = append(filter, bson.E{"created_at", createdAt})
+	}
+	return filter
+}
+
+// InsertAuditEntry appends an entry to the audit log.
+func InsertAuditEntry(e AuditEntry) {
+	coll := mongoDatabase.Collection("audit_log")
+	e.ID = primitive.NewObjectID()
+	if e.TargetIDs == nil {
+		e.TargetIDs = make([]string, 0)
+	}
+	_, err := coll.InsertOne(context.Background(), e)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// QueryAuditEntries returns a page of audit entries matching the given query,
+// newest first, together with the total number of matching entries.
+func QueryAuditEntries(ctx context.Context, q AuditQuery) (entries []AuditEntry, total int) {
+	coll := mongoDatabase.Collection("audit_log")
+	filter := q.filter()
+	// The total is counted separately since combining it with the results in
+	// a single document could exceed the maximum document size.
+	n, err := coll.CountDocuments(ctx, filter)
+	if !handleError(ctx, err) {
+		return nil, 0
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"created_at", -1}, {"_id", -1}}).
+		SetSkip(int64(q.Offset))
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	cursor, err := coll.Find(ctx, filter, opts)
+	if !handleError(ctx, err) {
+		return nil, 0
+	}
+	err = cursor.All(ctx, &entries)
+	handleError(ctx, err)
+	return entries, int(n)
+}
+
+// ForEachAuditEntry calls f for every audit entry matching the given query in
+// chronological order without loading all entries into memory.
+//
+// Offset and limit of the query are ignored.
+func ForEachAuditEntry(ctx context.Context, q AuditQuery, f func(AuditEntry)) {
+	coll := mongoDatabase.Collection("audit_log")
+	opts := options.Find().SetSort(bson.D{{"created_at", 1}, {"_id", 1}})
+	cursor, err := coll.Find(ctx, q.filter(), opts)
+	if !handleError(ctx, err) {
+		return
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var e AuditEntry
+		if err := cursor.Decode(&e); err != nil {
+			panic(err)
+		}
+		f(e)
+	}
+	handleError(ctx, cursor.Err())
+}
+
+// DeleteAuditEntriesBefore deletes all audit entries that were created before
+// the given time.
+func DeleteAuditEntriesBefore(t time.Time) {
+	coll := mongoDatabase.Collection("audit_log")
+	filter := bson.D{{"created_at", bson.D{{"$lt", t}}}}
+	_, err := coll.DeleteMany(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -66,6 +66,30 @@ func createIndexes() {
 			{"process_id", 1},
 		},
 	})
+	createIndex("transfer_files", mongo.IndexModel{
+		Keys: bson.D{
+			{"agency_id", 1},
+			{"content_hash", 1},
+		},
+	})
+	createIndex("oidc_users", mongo.IndexModel{
+		Keys: bson.D{
+			{"user_id", 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	createIndex("ldap_users", mongo.IndexModel{
+		Keys: bson.D{
+			{"user_id", 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	createIndex("local_users", mongo.IndexModel{
+		Keys: bson.D{
+			{"username", 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
 	// We use an additional field because mongo express doesn't like UUIDs for
 	// _id.
 	createIndex("submission_processes", mongo.IndexModel{
@@ -74,6 +98,41 @@ func createIndexes() {
 		},
 		Options: options.Index().SetUnique(true),
 	})
+	// Indexes for filtering and sorting process lists.
+	createIndex("submission_processes", mongo.IndexModel{
+		Keys: bson.D{
+			{"created_at", 1},
+		},
+	})
+	createIndex("submission_processes", mongo.IndexModel{
+		Keys: bson.D{
+			{"agency.users", 1},
+			{"created_at", 1},
+		},
+	})
+	createIndex("submission_processes", mongo.IndexModel{
+		Keys: bson.D{
+			{"agency.group_users", 1},
+			{"created_at", 1},
+		},
+	})
+	createIndex("submission_processes", mongo.IndexModel{
+		Keys: bson.D{
+			{"agency._id", 1},
+			{"created_at", 1},
+		},
+	})
+	createIndex("submission_processes", mongo.IndexModel{
+		Keys: bson.D{
+			{"agency.name", 1},
+			{"created_at", 1},
+		},
+	})
+	createIndex("submission_processes", mongo.IndexModel{
+		Keys: bson.D{
+			{"unresolved_errors", 1},
+		},
+	})
 	createIndex("messages", mongo.IndexModel{
 		Keys: bson.D{
 			{"message_head.process_id", 1},
@@ -93,6 +152,34 @@ func createIndexes() {
 			{"contained_records", 1},
 		},
 	})
+	createIndex("record_index", mongo.IndexModel{
+		Keys: bson.D{
+			{"process_id", 1},
+			{"message_type", 1},
+			{"position", 1},
+		},
+	})
+	createIndex("record_index", mongo.IndexModel{
+		Keys: bson.D{
+			{"process_id", 1},
+			{"message_type", 1},
+			{"parent_record_id", 1},
+			{"position", 1},
+		},
+	})
+	createIndex("primary_document_texts", mongo.IndexModel{
+		Keys: bson.D{
+			{"process_id", 1},
+			{"filename", 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	createIndex("primary_document_texts", mongo.IndexModel{
+		Keys: bson.D{
+			{"text", "text"},
+		},
+		Options: options.Index().SetDefaultLanguage("german"),
+	})
 	createIndex("appraisals", mongo.IndexModel{
 		Keys: bson.D{
 			{"process_id", 1},
@@ -100,6 +187,17 @@ func createIndexes() {
 		},
 		Options: options.Index().SetUnique(true),
 	})
+	createIndex("appraisal_statistics", mongo.IndexModel{
+		Keys: bson.D{
+			{"process_id", 1},
+		},
+	})
+	createIndex("appraisal_statistics", mongo.IndexModel{
+		Keys: bson.D{
+			{"agency_id", 1},
+			{"appraised_at", 1},
+		},
+	})
 	createIndex("packaging_choices", mongo.IndexModel{
 		Keys: bson.D{
 			{"process_id", 1},
@@ -137,6 +235,86 @@ func createIndexes() {
 			{"error_type", 1},
 		},
 	})
+	createIndex("processing_errors", mongo.IndexModel{
+		Keys: bson.D{
+			{"quarantine_id", 1},
+			{"resolved", 1},
+		},
+	})
+	createIndex("quarantined_files", mongo.IndexModel{
+		Keys: bson.D{
+			{"agency_id", 1},
+			{"created_at", -1},
+		},
+	})
+	createIndex("access_denials", mongo.IndexModel{
+		Keys: bson.D{
+			{"created_at", -1},
+		},
+	})
+	createIndex("access_denials", mongo.IndexModel{
+		Keys: bson.D{
+			{"user_id", 1},
+			{"created_at", -1},
+		},
+	})
+	createIndex("audit_log", mongo.IndexModel{
+		Keys: bson.D{
+			{"created_at", -1},
+		},
+	})
+	createIndex("audit_log", mongo.IndexModel{
+		Keys: bson.D{
+			{"user_id", 1},
+			{"created_at", -1},
+		},
+	})
+	createIndex("audit_log", mongo.IndexModel{
+		Keys: bson.D{
+			{"process_id", 1},
+			{"created_at", -1},
+		},
+	})
+	createIndex("audit_log", mongo.IndexModel{
+		Keys: bson.D{
+			{"agency_id", 1},
+			{"created_at", -1},
+		},
+	})
+	createIndex("api_tokens", mongo.IndexModel{
+		Keys: bson.D{
+			{"hash", 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	createIndex("sessions", mongo.IndexModel{
+		Keys: bson.D{
+			{"refresh_token_hash", 1},
+		},
+	})
+	createIndex("sessions", mongo.IndexModel{
+		Keys: bson.D{
+			{"previous_refresh_token_hash", 1},
+		},
+	})
+	createIndex("sessions", mongo.IndexModel{
+		Keys: bson.D{
+			{"user_id", 1},
+			{"created_at", -1},
+		},
+	})
+	createIndex("absences", mongo.IndexModel{
+		Keys: bson.D{
+			{"user_id", 1},
+			{"to", 1},
+		},
+	})
+	createIndex("absences", mongo.IndexModel{
+		Keys: bson.D{
+			{"deputy_id", 1},
+			{"to", 1},
+		},
+	})
 	createIndex("user_preferences", mongo.IndexModel{
 		Keys: bson.D{
 			{"user_id", 1},
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LDAPUser is a cached entry of a user with access rights in the LDAP
+// directory.
+//
+// The cache is refreshed periodically, so listing users and looking up display
+// names and e-mail addresses doesn't require LDAP queries. Users that are not
+// found anymore are kept with LeftAt set, so their names can still be shown
+// and their agency assignments can be flagged.
+type LDAPUser struct {
+	UserID      string `bson:"user_id" json:"id"`
+	DisplayName string `bson:"display_name" json:"displayName"`
+	Email       string `bson:"email" json:"email"`
+	// Roles are the roles granted by group memberships.
+	Roles []string `bson:"roles" json:"roles"`
+	// Groups are the groups referenced by agencies the user is a member of,
+	// including nested groups.
+	Groups []string `bson:"groups" json:"groups"`
+	// SyncedAt is the time the user was last found in the directory.
+	SyncedAt time.Time `bson:"synced_at" json:"syncedAt"`
+	// LeftAt is the time the user was first missing in the directory or nil
+	// if the user is still present.
+	LeftAt *time.Time `bson:"left_at" json:"leftAt"`
+}
+
+// UpsertLDAPUser saves the given user as present in the directory.
+func UpsertLDAPUser(u LDAPUser) {
+	coll := mongoDatabase.Collection("ldap_users")
+	u.LeftAt = nil
+	filter := bson.D{{"user_id", u.UserID}}
+	opts := options.Replace().SetUpsert(true)
+	_, err := coll.ReplaceOne(context.Background(), filter, u, opts)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// FindLDAPUser returns the cached user with the given ID, including users that
+// left the directory.
+func FindLDAPUser(ctx context.Context, userID string) (u LDAPUser, ok bool) {
+	coll := mongoDatabase.Collection("ldap_users")
+	filter := bson.D{{"user_id", userID}}
+	err := coll.FindOne(ctx, filter).Decode(&u)
+	return u, handleError(ctx, err)
+}
+
+// FindLDAPUsers returns all cached users that are present in the directory.
+func FindLDAPUsers(ctx context.Context) []LDAPUser {
+	filter := bson.D{{"left_at", nil}}
+	return findLDAPUsers(ctx, filter)
+}
+
+// FindLeftLDAPUsers returns all cached users that left the directory.
+func FindLeftLDAPUsers(ctx context.Context) []LDAPUser {
+	filter := bson.D{{"left_at", bson.D{{"$ne", nil}}}}
+	return findLDAPUsers(ctx, filter)
+}
+
+// HasLDAPUsers returns true if the cache contains any user.
+func HasLDAPUsers(ctx context.Context) bool {
+	coll := mongoDatabase.Collection("ldap_users")
+	n, err := coll.CountDocuments(ctx, bson.D{}, options.Count().SetLimit(1))
+	handleError(ctx, err)
+	return n > 0
+}
+
+// UpdateLDAPUsersLeft marks all present users that were last synchronized
+// before the given time as left and returns the number of marked users.
+func UpdateLDAPUsersLeft(syncedBefore time.Time) int {
+	coll := mongoDatabase.Collection("ldap_users")
+	filter := bson.D{
+		{"synced_at", bson.D{{"$lt", syncedBefore}}},
+		{"left_at", nil},
+	}
+	update := bson.D{{"$set", bson.D{{"left_at", time.Now()}}}}
+	result, err := coll.UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		panic(err)
+	}
+	return int(result.ModifiedCount)
+}
+
+func findLDAPUsers(ctx context.Context, filter bson.D) []LDAPUser {
+	coll := mongoDatabase.Collection("ldap_users")
+	opts := options.Find().SetSort(bson.D{{"display_name", 1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	handleError(ctx, err)
+	var users []LDAPUser
+	err = cursor.All(ctx, &users)
+	handleError(ctx, err)
+	return users
+}
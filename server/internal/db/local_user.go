@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LocalUser is a user account managed by administrators in x-man itself,
+// e.g., for archives without LDAP.
+type LocalUser struct {
+	ID primitive.ObjectID `bson:"_id" json:"id"`
+	// Username is the lower-case name used to log in.
+	Username    string `bson:"username" json:"username"`
+	DisplayName string `bson:"display_name" json:"displayName"`
+	Email       string `bson:"email" json:"email"`
+	// Roles are the roles granted to the user.
+	Roles []string `bson:"roles" json:"roles"`
+	// PasswordHash is the Argon2id hash of the password in PHC string format.
+	PasswordHash      string    `bson:"password_hash" json:"-"`
+	PasswordChangedAt time.Time `bson:"password_changed_at" json:"passwordChangedAt"`
+	CreatedAt         time.Time `bson:"created_at" json:"createdAt"`
+}
+
+// InsertLocalUser saves a new local user to the database.
+//
+// Returns false if a user with the same username already exists.
+func InsertLocalUser(u LocalUser) (ok bool) {
+	coll := mongoDatabase.Collection("local_users")
+	_, err := coll.InsertOne(context.Background(), u)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false
+		}
+		panic(err)
+	}
+	return true
+}
+
+// FindLocalUser returns the local user with the given ID.
+func FindLocalUser(ctx context.Context, id primitive.ObjectID) (u LocalUser, ok bool) {
+	coll := mongoDatabase.Collection("local_users")
+	filter := bson.D{{"_id", id}}
+	err := coll.FindOne(ctx, filter).Decode(&u)
+	return u, handleError(ctx, err)
+}
+
+// FindLocalUserByUsername returns the local user with the given username.
+func FindLocalUserByUsername(ctx context.Context, username string) (u LocalUser, ok bool) {
+	coll := mongoDatabase.Collection("local_users")
+	filter := bson.D{{"username", username}}
+	err := coll.FindOne(ctx, filter).Decode(&u)
+	return u, handleError(ctx, err)
+}
+
+// FindLocalUsers returns all local users sorted by display name.
+func FindLocalUsers(ctx context.Context) []LocalUser {
+	coll := mongoDatabase.Collection("local_users")
+	opts := options.Find().SetSort(bson.D{{"display_name", 1}})
+	cursor, err := coll.Find(ctx, bson.D{}, opts)
+	handleError(ctx, err)
+	users := make([]LocalUser, 0)
+	err = cursor.All(ctx, &users)
+	handleError(ctx, err)
+	return users
+}
+
+// UpdateLocalUser sets display name, e-mail address and roles of the local user
+// with the given ID.
+func UpdateLocalUser(id primitive.ObjectID, displayName, email string, roles []string) (ok bool) {
+	coll := mongoDatabase.Collection("local_users")
+	update := bson.D{{"$set", bson.D{
+		{"display_name", displayName},
+		{"email", email},
+		{"roles", roles},
+	}}}
+	result, err := coll.UpdateByID(context.Background(), id, update)
+	if err != nil {
+		panic(err)
+	}
+	return result.MatchedCount > 0
+}
+
+// UpdateLocalUserPassword sets the password hash of the local user with the
+// given ID.
+func UpdateLocalUserPassword(id primitive.ObjectID, passwordHash string) (ok bool) {
+	coll := mongoDatabase.Collection("local_users")
+	update := bson.D{{"$set", bson.D{
+		{"password_hash", passwordHash},
+		{"password_changed_at", time.Now()},
+	}}}
+	result, err := coll.UpdateByID(context.Background(), id, update)
+	if err != nil {
+		panic(err)
+	}
+	return result.MatchedCount > 0
+}
+
+// DeleteLocalUser deletes the local user with the given ID.
+func DeleteLocalUser(id primitive.ObjectID) (ok bool) {
+	coll := mongoDatabase.Collection("local_users")
+	filter := bson.D{{"_id", id}}
+	result, err := coll.DeleteOne(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+	return result.DeletedCount > 0
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"encoding/xml"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -38,6 +39,19 @@ type Message struct {
 	MessageType    MessageType `bson:"message_type" json:"messageType"`
 	MessageHead    MessageHead `bson:"message_head" json:"messageHead"`
 	MaxRecordDepth int         `bson:"max_record_depth" json:"maxRecordDepth"`
+	// Upload is set if the message was uploaded manually instead of being
+	// read from the transfer directory.
+	Upload *MessageUpload `bson:"upload" json:"upload,omitempty"`
+}
+
+// MessageUpload records who uploaded a message manually.
+type MessageUpload struct {
+	UploadedAt time.Time `bson:"uploaded_at" json:"uploadedAt"`
+	UserID     string    `bson:"user_id" json:"userId"`
+	UserName   string    `bson:"user_name" json:"userName"`
+	// StorePath is the path of the uploaded transfer file in the message
+	// store. The message is reimported from this file.
+	StorePath string `bson:"store_path" json:"-"`
 }
 
 type MessageHead struct {
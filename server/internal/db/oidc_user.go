@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OIDCUser is a user that logged in via OpenID Connect.
+//
+// Since the OpenID Connect provider cannot be queried for users, the
+// information of the last successful login is kept to list users and look up
+// display names and e-mail addresses.
+type OIDCUser struct {
+	// UserID is the value of the claim used as identifier.
+	UserID      string `bson:"user_id"`
+	DisplayName string `bson:"display_name"`
+	Email       string `bson:"email"`
+	// Roles are the roles granted by group memberships at the last login.
+	Roles []string `bson:"roles"`
+	// Groups are the values of the groups claim at the last login.
+	Groups    []string  `bson:"groups"`
+	LastLogin time.Time `bson:"last_login"`
+}
+
+// UpsertOIDCUser saves the given user to the database.
+func UpsertOIDCUser(u OIDCUser) {
+	coll := mongoDatabase.Collection("oidc_users")
+	filter := bson.D{{"user_id", u.UserID}}
+	opts := options.Replace().SetUpsert(true)
+	_, err := coll.ReplaceOne(context.Background(), filter, u, opts)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// FindOIDCUser returns the user with the given ID.
+func FindOIDCUser(ctx context.Context, userID string) (u OIDCUser, ok bool) {
+	coll := mongoDatabase.Collection("oidc_users")
+	filter := bson.D{{"user_id", userID}}
+	err := coll.FindOne(ctx, filter).Decode(&u)
+	return u, handleError(ctx, err)
+}
+
+// FindOIDCUsers returns all users that logged in via OpenID Connect.
+func FindOIDCUsers(ctx context.Context) []OIDCUser {
+	coll := mongoDatabase.Collection("oidc_users")
+	opts := options.Find().SetSort(bson.D{{"display_name", 1}})
+	cursor, err := coll.Find(ctx, bson.D{}, opts)
+	handleError(ctx, err)
+	var users []OIDCUser
+	err = cursor.All(ctx, &users)
+	handleError(ctx, err)
+	return users
+}
+
+// DeleteOIDCUser removes the user with the given ID, e.g., after the user lost
+// access to x-man.
+func DeleteOIDCUser(userID string) {
+	coll := mongoDatabase.Collection("oidc_users")
+	filter := bson.D{{"user_id", userID}}
+	_, err := coll.DeleteOne(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+}
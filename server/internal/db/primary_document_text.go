@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PrimaryDocumentText is the plain text extracted from a primary document.
+type PrimaryDocumentText struct {
+	ProcessID string `bson:"process_id" json:"processId"`
+	RecordID  string `bson:"record_id" json:"recordId"`
+	Filename  string `bson:"filename" json:"filename"`
+	Text      string `bson:"text" json:"-"`
+	// Truncated is true if the extracted text exceeded the maximum length and
+	// only its beginning was saved.
+	Truncated bool `bson:"truncated" json:"truncated"`
+}
+
+// UpsertPrimaryDocumentText saves the extracted text of a primary document,
+// replacing any previously extracted text.
+func UpsertPrimaryDocumentText(t PrimaryDocumentText) {
+	coll := mongoDatabase.Collection("primary_document_texts")
+	filter := bson.D{{"process_id", t.ProcessID}, {"filename", t.Filename}}
+	opts := options.Replace().SetUpsert(true)
+	_, err := coll.ReplaceOne(context.Background(), filter, t, opts)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// SearchPrimaryDocumentTexts performs a full-text search on the extracted
+// texts of primary documents of the given processes.
+//
+// The query uses the syntax of MongoDB text search, i.e., words are combined
+// with OR, phrases can be given in quotes, and words can be excluded with a
+// leading minus. Results are sorted by relevance.
+func SearchPrimaryDocumentTexts(
+	ctx context.Context,
+	processIDs []string,
+	query string,
+	offset, limit int,
+) (results []PrimaryDocumentText, total int) {
+	coll := mongoDatabase.Collection("primary_document_texts")
+	matchStage := bson.D{{"$match", bson.D{
+		{"$text", bson.D{{"$search", query}}},
+		{"process_id", bson.D{{"$in", processIDs}}},
+	}}}
+	sortStage := bson.D{{"$sort", bson.D{
+		{"score", bson.D{{"$meta", "textScore"}}},
+		{"process_id", 1},
+		{"filename", 1},
+	}}}
+	facetStage := bson.D{{"$facet", bson.D{
+		{"results", bson.A{bson.D{{"$skip", offset}}, bson.D{{"$limit", limit}}}},
+		{"total", bson.A{bson.D{{"$count", "count"}}}},
+	}}}
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{matchStage, sortStage, facetStage})
+	handleError(ctx, err)
+	var facets []struct {
+		Results []PrimaryDocumentText `bson:"results"`
+		Total   []struct {
+			Count int `bson:"count"`
+		} `bson:"total"`
+	}
+	err = cursor.All(ctx, &facets)
+	handleError(ctx, err)
+	if len(facets) == 0 {
+		return nil, 0
+	}
+	if len(facets[0].Total) > 0 {
+		total = facets[0].Total[0].Count
+	}
+	return facets[0].Results, total
+}
+
+func DeletePrimaryDocumentTextsForProcess(processID string) {
+	coll := mongoDatabase.Collection("primary_document_texts")
+	filter := bson.D{{"process_id", processID}}
+	_, err := coll.DeleteMany(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+}
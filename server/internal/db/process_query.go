@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ProcessSortField string
+
+const (
+	ProcessSortCreatedAt ProcessSortField = "createdAt"
+	ProcessSortAgency    ProcessSortField = "agency"
+	// ProcessSortState sorts processes by how far they have progressed.
+	ProcessSortState ProcessSortField = "state"
+)
+
+// StepState describes the condition of a process step for filtering.
+type StepState string
+
+const (
+	StepStateComplete   StepState = "complete"
+	StepStateIncomplete StepState = "incomplete"
+	// StepStateRunning matches steps with an active task.
+	StepStateRunning StepState = "running"
+	// StepStateError matches steps with unresolved processing errors.
+	StepStateError StepState = "error"
+)
+
+// ProcessQuery describes a filtered, sorted and paginated query of submission
+// processes. Empty fields are ignored.
+type ProcessQuery struct {
+	// UserIDs restricts results to processes of agencies any of the users is
+	// assigned to.
+	UserIDs []string
+	// AgencyIDs restricts results to processes of the given agencies if not
+	// nil.
+	AgencyIDs []primitive.ObjectID
+	AgencyID  *primitive.ObjectID
+	// Step and StepState filter by the state of the given process step. Both
+	// need to be set to take effect.
+	Step      ProcessStepType
+	StepState StepState
+	// HasUnresolvedErrors filters by whether processes have unresolved
+	// processing errors.
+	HasUnresolvedErrors *bool
+	// CreatedFrom and CreatedTo restrict the creation time to [from, to).
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Text matches all processes of which the process ID or the note contains
+	// the given text, ignoring case.
+	Text       string
+	Sort       ProcessSortField
+	Descending bool
+	Offset     int
+	// Limit is the maximum number of processes to return. 0 means no limit.
+	Limit int
+}
+
+// QueryProcesses returns the submission processes matching the given query
+// together with the total number of matching processes.
+func QueryProcesses(ctx context.Context, q ProcessQuery) (processes []SubmissionProcess, total int) {
+	coll := mongoDatabase.Collection("submission_processes")
+	match := bson.D{}
+	if len(q.UserIDs) > 0 {
+		match = append(match, bson.E{"$and", bson.A{assignedUserFilter("agency.", q.UserIDs...)}})
+	}
+	if q.AgencyIDs != nil {
+		match = append(match, bson.E{"agency._id", bson.D{{"$in", q.AgencyIDs}}})
+	}
+	if q.AgencyID != nil {
+		match = append(match, bson.E{"agency._id", *q.AgencyID})
+	}
+	if q.Step != "" && q.StepState != "" {
+		prefix := "process_state." + string(q.Step) + "."
+		switch q.StepState {
+		case StepStateComplete:
+			match = append(match, bson.E{prefix + "complete", true})
+		case StepStateIncomplete:
+			match = append(match, bson.E{prefix + "complete", bson.D{{"$ne", true}}})
+		case StepStateRunning:
+			match = append(match, bson.E{prefix + "task_state", bson.D{{"$in", bson.A{
+				TaskStatePending, TaskStateRunning, TaskStatePausing,
+			}}}})
+		case StepStateError:
+			match = append(match, bson.E{prefix + "has_error", true})
+		}
+	}
+	if q.HasUnresolvedErrors != nil {
+		if *q.HasUnresolvedErrors {
+			match = append(match, bson.E{"unresolved_errors", bson.D{{"$gt", 0}}})
+		} else {
+			match = append(match, bson.E{"unresolved_errors", bson.D{{"$not", bson.D{{"$gt", 0}}}}})
+		}
+	}
+	createdAt := bson.D{}
+	if !q.CreatedFrom.IsZero() {
+		createdAt = append(createdAt, bson.E{"$gte", q.CreatedFrom})
+	}
+	if !q.CreatedTo.IsZero() {
+		createdAt = append(createdAt, bson.E{"$lt", q.CreatedTo})
+	}
+	if len(createdAt) > 0 {
+		match = append(match, bson.E{"created_at", createdAt})
+	}
+	if q.Text != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q.Text), Options: "i"}
+		match = append(match, bson.E{"$or", bson.A{
+			bson.D{{"process_id", pattern}},
+			bson.D{{"note", pattern}},
+		}})
+	}
+	direction := 1
+	if q.Descending {
+		direction = -1
+	}
+	pipeline := mongo.Pipeline{{{"$match", match}}}
+	var sort bson.D
+	switch q.Sort {
+	case ProcessSortAgency:
+		sort = bson.D{{"agency.name", direction}, {"created_at", direction}}
+	case ProcessSortState:
+		pipeline = append(pipeline, bson.D{{"$addFields", bson.D{{"state_rank", stateRank()}}}})
+		sort = bson.D{{"state_rank", direction}, {"created_at", direction}}
+	default:
+		sort = bson.D{{"created_at", direction}}
+	}
+	// Sort by _id last to make pagination stable.
+	sort = append(sort, bson.E{"_id", direction})
+	pipeline = append(pipeline, bson.D{{"$sort", sort}})
+	if q.Offset > 0 {
+		pipeline = append(pipeline, bson.D{{"$skip", q.Offset}})
+	}
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{"$limit", q.Limit}})
+	}
+	if q.Sort == ProcessSortState {
+		pipeline = append(pipeline, bson.D{{"$project", bson.D{{"state_rank", 0}}}})
+	}
+	// The total is counted separately since combining it with the results in
+	// a single document could exceed the maximum document size.
+	n, err := coll.CountDocuments(ctx, match)
+	if !handleError(ctx, err) {
+		return nil, 0
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if !handleError(ctx, err) {
+		return nil, 0
+	}
+	err = cursor.All(ctx, &processes)
+	handleError(ctx, err)
+	return processes, int(n)
+}
+
+// stateRank returns an aggregation expression that evaluates to the number of
+// the last completed main process step.
+func stateRank() bson.D {
+	steps := []ProcessStepType{
+		ProcessStepArchiving,
+		ProcessStepFormatVerification,
+		ProcessStepReceive0503,
+		ProcessStepAppraisal,
+		ProcessStepReceive0501,
+	}
+	var branches bson.A
+	for i, step := range steps {
+		branches = append(branches, bson.D{
+			{"case", bson.D{{"$eq", bson.A{"$process_state." + string(step) + ".complete", true}}}},
+			{"then", len(steps) - i},
+		})
+	}
+	return bson.D{{"$switch", bson.D{
+		{"branches", branches},
+		{"default", 0},
+	}}}
+}
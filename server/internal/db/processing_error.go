@@ -47,6 +47,9 @@ type ProcessingError struct {
 	ProcessStep  ProcessStepType           `bson:"process_step" json:"processStep"`
 	TransferPath string                    `bson:"transfer_path" json:"transferPath"`
 	TaskID       *primitive.ObjectID       `bson:"task_id" json:"taskId"`
+	// QuarantineID references the quarantined file if the transfer file was
+	// moved to quarantine because of this error.
+	QuarantineID *primitive.ObjectID `bson:"quarantine_id" json:"quarantineId"`
 }
 
 func (e *ProcessingError) Error() string {
@@ -98,6 +101,13 @@ func FindProcessingErrors(ctx context.Context) []ProcessingError {
 	return findProcessingErrors(ctx, filter)
 }
 
+// FindProcessingErrorsForAgencies returns all processing errors that refer to
+// one of the given agencies.
+func FindProcessingErrorsForAgencies(ctx context.Context, agencyIDs []primitive.ObjectID) []ProcessingError {
+	filter := bson.D{{"agency._id", bson.D{{"$in", agencyIDs}}}}
+	return findProcessingErrors(ctx, filter)
+}
+
 func FindProcessingErrorsForProcess(ctx context.Context, processID string) []ProcessingError {
 	filter := bson.D{{"process_id", processID}}
 	return findProcessingErrors(ctx, filter)
@@ -111,6 +121,17 @@ func FindUnresolvedProcessingErrorsByType(ctx context.Context, errorType string)
 	return findProcessingErrors(ctx, filter)
 }
 
+func FindUnresolvedProcessingErrorsForQuarantinedFile(
+	ctx context.Context,
+	quarantineID primitive.ObjectID,
+) []ProcessingError {
+	filter := bson.D{
+		{"resolved", false},
+		{"quarantine_id", quarantineID},
+	}
+	return findProcessingErrors(ctx, filter)
+}
+
 func FindResolvedProcessingErrorsOlderThan(ctx context.Context, t time.Time) []ProcessingError {
 	filter := bson.D{
 		{"resolved", true},
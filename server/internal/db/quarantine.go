@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// QuarantinedFile is a transfer file that was rejected during import and moved
+// to the quarantine store.
+type QuarantinedFile struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	CreatedAt time.Time          `bson:"created_at" json:"createdAt"`
+	AgencyID  primitive.ObjectID `bson:"agency_id" json:"agencyId"`
+	// TransferPath is the path of the file in the agency's transfer directory
+	// before it was quarantined.
+	TransferPath string `bson:"transfer_path" json:"transferPath"`
+	// StorePath is the path of the file in the quarantine store.
+	StorePath   string `bson:"store_path" json:"-"`
+	Size        int64  `bson:"size" json:"size"`
+	ContentHash string `bson:"content_hash" json:"contentHash"`
+	// Reason is the error type of the processing error that caused the file to
+	// be rejected.
+	Reason string `bson:"reason" json:"reason"`
+	// Title and Info describe why the file was rejected.
+	Title string `bson:"title" json:"title"`
+	Info  string `bson:"info" json:"info"`
+	// Upload is set if the file was uploaded manually. Such files are imported
+	// again from the message store instead of the transfer directory.
+	Upload *MessageUpload `bson:"upload" json:"upload,omitempty"`
+}
+
+// InsertQuarantinedFile saves a quarantined file to the database.
+func InsertQuarantinedFile(f QuarantinedFile) {
+	coll := mongoDatabase.Collection("quarantined_files")
+	_, err := coll.InsertOne(context.Background(), f)
+	if err != nil {
+		panic(err)
+	}
+	broadcastUpdate(Update{
+		Collection: "quarantined_files",
+		Operation:  UpdateOperationInsert,
+	})
+}
+
+// FindQuarantinedFile returns the quarantined file with the given ID.
+func FindQuarantinedFile(ctx context.Context, id primitive.ObjectID) (f QuarantinedFile, ok bool) {
+	coll := mongoDatabase.Collection("quarantined_files")
+	filter := bson.D{{"_id", id}}
+	err := coll.FindOne(ctx, filter).Decode(&f)
+	return f, handleError(ctx, err)
+}
+
+// FindQuarantinedFiles returns a page of quarantined files, newest first,
+// together with the total number of matching files.
+//
+// If agencyIDs is not nil, only files of the given agencies are returned.
+// Without limit, all matching files are returned.
+func FindQuarantinedFiles(
+	ctx context.Context,
+	agencyIDs []primitive.ObjectID,
+	offset, limit int,
+) (files []QuarantinedFile, total int) {
+	coll := mongoDatabase.Collection("quarantined_files")
+	match := bson.D{}
+	if agencyIDs != nil {
+		match = append(match, bson.E{"agency_id", bson.D{{"$in", agencyIDs}}})
+	}
+	// The total is counted separately since combining it with the results in
+	// a single document could exceed the maximum document size.
+	n, err := coll.CountDocuments(ctx, match)
+	if !handleError(ctx, err) {
+		return nil, 0
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"created_at", -1}, {"_id", -1}}).
+		SetSkip(int64(offset))
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := coll.Find(ctx, match, opts)
+	if !handleError(ctx, err) {
+		return nil, 0
+	}
+	err = cursor.All(ctx, &files)
+	handleError(ctx, err)
+	return files, int(n)
+}
+
+// DeleteQuarantinedFile deletes the database entry of the quarantined file
+// with the given ID.
+func DeleteQuarantinedFile(id primitive.ObjectID) (ok bool) {
+	coll := mongoDatabase.Collection("quarantined_files")
+	filter := bson.D{{"_id", id}}
+	result, err := coll.DeleteOne(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+	ok = result.DeletedCount > 0
+	if ok {
+		broadcastUpdate(Update{
+			Collection: "quarantined_files",
+			Operation:  UpdateOperationDelete,
+		})
+	}
+	return
+}
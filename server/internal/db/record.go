@@ -253,7 +253,22 @@ func (archiveMetadata *ArchiveMetadata) UnmarshalXML(d *xml.Decoder, start xml.S
 // InsertRootRecords inserts records for files, processes, and documents for a
 // given message into the database.
 func InsertRootRecords(processID string, messageType MessageType, records RootRecords) {
-	// Check for existing records
+	NewRootRecordInserter(processID, messageType).Insert(records)
+}
+
+// RootRecordInserter inserts the root records of a message in multiple steps,
+// so records can be saved while the message is still being parsed.
+type RootRecordInserter struct {
+	processID   string
+	messageType MessageType
+	// nextPosition is the position of the next record index entry.
+	nextPosition int
+}
+
+// NewRootRecordInserter returns a RootRecordInserter for the given message.
+//
+// It panics if the database already contains root records for the message.
+func NewRootRecordInserter(processID string, messageType MessageType) *RootRecordInserter {
 	coll := mongoDatabase.Collection("root_records")
 	filter := bson.D{
 		{"process_id", processID},
@@ -264,6 +279,14 @@ func InsertRootRecords(processID string, messageType MessageType, records RootRe
 		panic(fmt.Errorf("existing root records for %s message of submission process %s",
 			messageType, processID))
 	}
+	return &RootRecordInserter{processID: processID, messageType: messageType}
+}
+
+// Insert inserts the given root records after all records that have been
+// inserted before.
+func (i *RootRecordInserter) Insert(records RootRecords) {
+	processID, messageType := i.processID, i.messageType
+	coll := mongoDatabase.Collection("root_records")
 	// Create root record objects
 	rootRecords := make([]interface{}, len(records.Files)+len(records.Processes)+len(records.Documents))
 	for i, f := range records.Files {
@@ -301,11 +324,15 @@ func InsertRootRecords(processID string, messageType MessageType, records RootRe
 			DocumentRecord: d,
 		}
 	}
+	if len(rootRecords) == 0 {
+		return
+	}
 	// Insert root records
 	_, err := coll.InsertMany(context.Background(), rootRecords)
 	if err != nil {
 		panic(err)
 	}
+	i.nextPosition += insertRecordIndex(processID, messageType, records, i.nextPosition)
 }
 
 // FindAllRootRecords queries all root records of a given message and returns them
@@ -428,6 +455,7 @@ func DeleteRecordsForProcess(processID string) {
 	if err != nil {
 		panic(err)
 	}
+	deleteRecordIndex(filter)
 }
 
 func DeleteRecordsForMessage(processID string, messageType MessageType) {
@@ -440,6 +468,7 @@ func DeleteRecordsForMessage(processID string, messageType MessageType) {
 	if err != nil {
 		panic(err)
 	}
+	deleteRecordIndex(filter)
 }
 
 // containedRecordIDs returns the IDs of all nested records contained in the
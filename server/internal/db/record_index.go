@@ -0,0 +1,379 @@
+package db
+
+import (
+	"context"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RecordIndexEntry holds the searchable metadata of a single record.
+//
+// Root records are saved as nested documents, which makes it impractical to
+// query sub-records. The record index contains one flat entry for every root
+// record and every nested record. It is kept in sync with the root records
+// collection.
+type RecordIndexEntry struct {
+	ProcessID   string      `bson:"process_id" json:"processId"`
+	MessageType MessageType `bson:"message_type" json:"messageType"`
+	RecordID    string      `bson:"record_id" json:"recordId"`
+	// RootRecordID is the ID of the root record that contains the record. It
+	// equals RecordID for root records.
+	RootRecordID string `bson:"root_record_id" json:"rootRecordId"`
+	// ParentRecordID is empty for root records.
+	ParentRecordID string     `bson:"parent_record_id" json:"parentRecordId"`
+	RecordType     RecordType `bson:"record_type" json:"recordType"`
+	IsSubRecord    bool       `bson:"is_sub_record" json:"isSubRecord"`
+	// ChildCount is the number of direct sub-records, including documents
+	// and attachments.
+	ChildCount int `bson:"child_count" json:"childCount"`
+	// Position is the position of the record in the message, used for
+	// sorting.
+	Position             int                  `bson:"position" json:"-"`
+	Subject              string               `bson:"subject" json:"subject"`
+	RecordNumber         string               `bson:"record_number" json:"recordNumber"`
+	FilePlanNumber       string               `bson:"file_plan_number" json:"filePlanNumber"`
+	FilePlanSubject      string               `bson:"file_plan_subject" json:"filePlanSubject"`
+	LifetimeStart        string               `bson:"lifetime_start" json:"lifetimeStart"`
+	LifetimeEnd          string               `bson:"lifetime_end" json:"lifetimeEnd"`
+	ConfidentialityLevel ConfidentialityLevel `bson:"confidentiality_level" json:"confidentialityLevel"`
+}
+
+// RecordSearchResult is a record index entry together with the appraisal
+// decision of the record.
+type RecordSearchResult struct {
+	RecordIndexEntry  `bson:"inline"`
+	AppraisalDecision AppraisalDecisionOption `bson:"appraisal_decision" json:"appraisalDecision"`
+}
+
+// RecordSearchFilter restricts the results of SearchRecords. Empty fields are
+// ignored.
+type RecordSearchFilter struct {
+	// ProcessIDs are the submission processes to search. This field is always
+	// applied, so an empty list yields no results.
+	ProcessIDs  []string
+	MessageType MessageType
+	RecordType  RecordType
+	// Subject matches all records of which the subject contains the given
+	// text, ignoring case.
+	Subject string
+	// RecordNumber matches all records of which the record number starts with
+	// the given text, ignoring case.
+	RecordNumber string
+	// FilePlanNumber matches all records of which the file-plan number starts
+	// with the given text, ignoring case.
+	FilePlanNumber string
+	// LifetimeFrom and LifetimeTo match all records of which the lifetime
+	// overlaps the given range. Dates are given as YYYY-MM-DD. Records without
+	// lifetime never match, records without lifetime end are considered
+	// ongoing.
+	LifetimeFrom         string
+	LifetimeTo           string
+	ConfidentialityLevel ConfidentialityLevel
+	AppraisalDecision    AppraisalDecisionOption
+	// ParentRecordID restricts results to the direct children of the given
+	// record if not nil. Use an empty string to query root records.
+	ParentRecordID *string
+}
+
+// insertRecordIndex creates record index entries for the given records,
+// starting at the given position. It returns the number of created entries.
+func insertRecordIndex(
+	processID string,
+	messageType MessageType,
+	records RootRecords,
+	firstPosition int,
+) int {
+	var entries []RecordIndexEntry
+	var rootRecordID string
+	appendEntry := func(
+		recordID string,
+		recordType RecordType,
+		parentRecordID string,
+		generalMetadata *GeneralMetadata,
+		lifetime *Lifetime,
+	) {
+		if parentRecordID == "" {
+			rootRecordID = recordID
+		}
+		e := RecordIndexEntry{
+			ProcessID:      processID,
+			MessageType:    messageType,
+			RecordID:       recordID,
+			RootRecordID:   rootRecordID,
+			ParentRecordID: parentRecordID,
+			RecordType:     recordType,
+			IsSubRecord:    parentRecordID != "",
+			Position:       firstPosition + len(entries),
+		}
+		if generalMetadata != nil {
+			e.Subject = generalMetadata.Subject
+			e.RecordNumber = generalMetadata.RecordNumber
+			if generalMetadata.FilePlan != nil {
+				e.FilePlanNumber = generalMetadata.FilePlan.FilePlanNumber
+				e.FilePlanSubject = generalMetadata.FilePlan.Subject
+			}
+			if generalMetadata.ConfidentialityLevel != nil {
+				e.ConfidentialityLevel = *generalMetadata.ConfidentialityLevel
+			}
+		}
+		if lifetime != nil {
+			e.LifetimeStart = lifetime.Start
+			e.LifetimeEnd = lifetime.End
+		}
+		entries = append(entries, e)
+	}
+	var appendDocuments func(documents []DocumentRecord, parentRecordID string)
+	var appendProcesses func(processes []ProcessRecord, parentRecordID string)
+	var appendFiles func(files []FileRecord, parentRecordID string)
+	appendDocuments = func(documents []DocumentRecord, parentRecordID string) {
+		for _, d := range documents {
+			appendEntry(d.RecordID, RecordTypeDocument, parentRecordID, d.GeneralMetadata, nil)
+			appendDocuments(d.Attachments, d.RecordID)
+		}
+	}
+	appendProcesses = func(processes []ProcessRecord, parentRecordID string) {
+		for _, p := range processes {
+			appendEntry(p.RecordID, RecordTypeProcess, parentRecordID, p.GeneralMetadata, p.Lifetime)
+			appendProcesses(p.Subprocesses, p.RecordID)
+			appendDocuments(p.Documents, p.RecordID)
+		}
+	}
+	appendFiles = func(files []FileRecord, parentRecordID string) {
+		for _, f := range files {
+			appendEntry(f.RecordID, RecordTypeFile, parentRecordID, f.GeneralMetadata, f.Lifetime)
+			appendFiles(f.Subfiles, f.RecordID)
+			appendProcesses(f.Processes, f.RecordID)
+			appendDocuments(f.Documents, f.RecordID)
+		}
+	}
+	appendFiles(records.Files, "")
+	appendProcesses(records.Processes, "")
+	appendDocuments(records.Documents, "")
+	if len(entries) == 0 {
+		return 0
+	}
+	childCount := make(map[string]int)
+	for _, e := range entries {
+		childCount[e.ParentRecordID]++
+	}
+	docs := make([]interface{}, len(entries))
+	for i, e := range entries {
+		e.ChildCount = childCount[e.RecordID]
+		docs[i] = e
+	}
+	coll := mongoDatabase.Collection("record_index")
+	_, err := coll.InsertMany(context.Background(), docs)
+	if err != nil {
+		panic(err)
+	}
+	return len(entries)
+}
+
+func deleteRecordIndex(filter bson.D) {
+	coll := mongoDatabase.Collection("record_index")
+	_, err := coll.DeleteMany(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// FindRecordIndexEntry returns the record index entry of the given record.
+func FindRecordIndexEntry(
+	ctx context.Context,
+	processID string,
+	messageType MessageType,
+	recordID string,
+) (e RecordIndexEntry, ok bool) {
+	coll := mongoDatabase.Collection("record_index")
+	filter := bson.D{
+		{"process_id", processID},
+		{"message_type", messageType},
+		{"record_id", recordID},
+	}
+	err := coll.FindOne(ctx, filter).Decode(&e)
+	return e, handleError(ctx, err)
+}
+
+// FindRecordStructure returns the record index entries of all records of the
+// given message in the order they appear in the message.
+//
+// Only the fields needed to reconstruct the record tree are populated: record
+// ID, parent record ID, record type, and position.
+func FindRecordStructure(
+	ctx context.Context,
+	processID string,
+	messageType MessageType,
+) []RecordIndexEntry {
+	coll := mongoDatabase.Collection("record_index")
+	filter := bson.D{
+		{"process_id", processID},
+		{"message_type", messageType},
+	}
+	opts := options.Find().
+		SetProjection(bson.D{
+			{"record_id", 1},
+			{"parent_record_id", 1},
+			{"record_type", 1},
+			{"position", 1},
+		}).
+		SetSort(bson.D{{"position", 1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	handleError(ctx, err)
+	var entries []RecordIndexEntry
+	err = cursor.All(ctx, &entries)
+	handleError(ctx, err)
+	return entries
+}
+
+// SearchRecords returns the record index entries matching the given filter,
+// sorted by process and position in the message, together with the total
+// number of matching entries.
+func SearchRecords(
+	ctx context.Context,
+	filter RecordSearchFilter,
+	offset, limit int,
+) (results []RecordSearchResult, total int) {
+	coll := mongoDatabase.Collection("record_index")
+	match := bson.D{{"process_id", bson.D{{"$in", filter.ProcessIDs}}}}
+	if filter.MessageType != "" {
+		match = append(match, bson.E{"message_type", filter.MessageType})
+	}
+	if filter.ParentRecordID != nil {
+		match = append(match, bson.E{"parent_record_id", *filter.ParentRecordID})
+	}
+	if filter.RecordType != "" {
+		match = append(match, bson.E{"record_type", filter.RecordType})
+	}
+	if filter.Subject != "" {
+		match = append(match, bson.E{"subject", primitive.Regex{
+			Pattern: regexp.QuoteMeta(filter.Subject), Options: "i",
+		}})
+	}
+	if filter.RecordNumber != "" {
+		match = append(match, bson.E{"record_number", primitive.Regex{
+			Pattern: "^" + regexp.QuoteMeta(filter.RecordNumber), Options: "i",
+		}})
+	}
+	if filter.FilePlanNumber != "" {
+		match = append(match, bson.E{"file_plan_number", primitive.Regex{
+			Pattern: "^" + regexp.QuoteMeta(filter.FilePlanNumber), Options: "i",
+		}})
+	}
+	if filter.LifetimeFrom != "" || filter.LifetimeTo != "" {
+		lifetimeStart := bson.D{{"$gt", ""}}
+		if filter.LifetimeTo != "" {
+			lifetimeStart = append(lifetimeStart, bson.E{"$lte", filter.LifetimeTo})
+		}
+		match = append(match, bson.E{"lifetime_start", lifetimeStart})
+	}
+	if filter.LifetimeFrom != "" {
+		match = append(match, bson.E{"$or", bson.A{
+			bson.D{{"lifetime_end", bson.D{{"$gte", filter.LifetimeFrom}}}},
+			bson.D{{"lifetime_end", ""}},
+		}})
+	}
+	if filter.ConfidentialityLevel != "" {
+		match = append(match, bson.E{"confidentiality_level", filter.ConfidentialityLevel})
+	}
+	lookupAppraisal := mongo.Pipeline{
+		{{"$lookup", bson.D{
+			{"from", "appraisals"},
+			{"let", bson.D{{"process_id", "$process_id"}, {"record_id", "$record_id"}}},
+			{"pipeline", bson.A{bson.D{{"$match", bson.D{{"$expr", bson.D{{"$and", bson.A{
+				bson.D{{"$eq", bson.A{"$process_id", "$$process_id"}}},
+				bson.D{{"$eq", bson.A{"$record_id", "$$record_id"}}},
+			}}}}}}}}},
+			{"as", "appraisal"},
+		}}},
+		{{"$addFields", bson.D{{"appraisal_decision", bson.D{{"$ifNull", bson.A{
+			bson.D{{"$arrayElemAt", bson.A{"$appraisal.decision", 0}}}, "",
+		}}}}}}},
+		{{"$project", bson.D{{"appraisal", 0}}}},
+	}
+	pipeline := mongo.Pipeline{{{"$match", match}}}
+	// Only look up appraisals for all entries if we need to filter by them.
+	// Otherwise, look up appraisals for the requested page only.
+	pageStages := bson.A{bson.D{{"$skip", offset}}, bson.D{{"$limit", limit}}}
+	if filter.AppraisalDecision != "" {
+		pipeline = append(pipeline, lookupAppraisal...)
+		pipeline = append(pipeline, bson.D{{"$match", bson.D{
+			{"appraisal_decision", filter.AppraisalDecision},
+		}}})
+	} else {
+		for _, stage := range lookupAppraisal {
+			pageStages = append(pageStages, stage)
+		}
+	}
+	pipeline = append(pipeline,
+		bson.D{{"$sort", bson.D{{"process_id", 1}, {"message_type", 1}, {"position", 1}}}},
+		bson.D{{"$facet", bson.D{
+			{"results", pageStages},
+			{"total", bson.A{bson.D{{"$count", "count"}}}},
+		}}},
+	)
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	handleError(ctx, err)
+	var facets []struct {
+		Results []RecordSearchResult `bson:"results"`
+		Total   []struct {
+			Count int `bson:"count"`
+		} `bson:"total"`
+	}
+	err = cursor.All(ctx, &facets)
+	handleError(ctx, err)
+	if len(facets) == 0 {
+		return nil, 0
+	}
+	if len(facets[0].Total) > 0 {
+		total = facets[0].Total[0].Count
+	}
+	return facets[0].Results, total
+}
+
+// MigrateRecordIndex creates record index entries for all messages of which
+// root records were saved before the record index was introduced.
+func MigrateRecordIndex(ctx context.Context) error {
+	type message struct {
+		ProcessID   string      `bson:"process_id"`
+		MessageType MessageType `bson:"message_type"`
+	}
+	distinctMessages := func(collection string) ([]message, error) {
+		coll := mongoDatabase.Collection(collection)
+		cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+			{{"$group", bson.D{{"_id", bson.D{
+				{"process_id", "$process_id"},
+				{"message_type", "$message_type"},
+			}}}}},
+			{{"$replaceRoot", bson.D{{"newRoot", "$_id"}}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var messages []message
+		err = cursor.All(ctx, &messages)
+		return messages, err
+	}
+	withRecords, err := distinctMessages("root_records")
+	if err != nil {
+		return err
+	}
+	indexed, err := distinctMessages("record_index")
+	if err != nil {
+		return err
+	}
+	isIndexed := make(map[message]bool)
+	for _, m := range indexed {
+		isIndexed[m] = true
+	}
+	for _, m := range withRecords {
+		if isIndexed[m] {
+			continue
+		}
+		insertRecordIndex(m.ProcessID, m.MessageType, FindAllRootRecords(ctx, m.ProcessID, m.MessageType), 0)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Session is a login session of a user.
+//
+// Short-lived access tokens are issued for a session as long as it is neither
+// expired nor revoked. Only hashes of the refresh tokens are stored.
+type Session struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	UserID      string             `bson:"user_id" json:"userId"`
+	LoginMethod string             `bson:"login_method" json:"loginMethod"`
+	// RefreshTokenHash is the hex-encoded SHA-256 hash of the current refresh
+	// token.
+	RefreshTokenHash string `bson:"refresh_token_hash" json:"-"`
+	// PreviousRefreshTokenHash is the hash of the refresh token that was
+	// replaced by the current one. It is kept to detect reused refresh tokens.
+	PreviousRefreshTokenHash string     `bson:"previous_refresh_token_hash" json:"-"`
+	CreatedAt                time.Time  `bson:"created_at" json:"createdAt"`
+	RefreshedAt              time.Time  `bson:"refreshed_at" json:"refreshedAt"`
+	ExpiresAt                time.Time  `bson:"expires_at" json:"expiresAt"`
+	RevokedAt                *time.Time `bson:"revoked_at" json:"revokedAt"`
+	RevokedBy                string     `bson:"revoked_by" json:"revokedBy,omitempty"`
+	ClientIP                 string     `bson:"client_ip" json:"clientIp"`
+	UserAgent                string     `bson:"user_agent" json:"userAgent"`
+}
+
+// InsertSession saves a new session to the database.
+func InsertSession(s Session) {
+	coll := mongoDatabase.Collection("sessions")
+	_, err := coll.InsertOne(context.Background(), s)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// FindSession returns the session with the given ID.
+func FindSession(ctx context.Context, id primitive.ObjectID) (s Session, ok bool) {
+	coll := mongoDatabase.Collection("sessions")
+	filter := bson.D{{"_id", id}}
+	err := coll.FindOne(ctx, filter).Decode(&s)
+	return s, handleError(ctx, err)
+}
+
+// FindSessionByRefreshTokenHash returns the session whose current or previous
+// refresh token has the given hash.
+func FindSessionByRefreshTokenHash(ctx context.Context, hash string) (s Session, ok bool) {
+	coll := mongoDatabase.Collection("sessions")
+	filter := bson.D{{"$or", bson.A{
+		bson.D{{"refresh_token_hash", hash}},
+		bson.D{{"previous_refresh_token_hash", hash}},
+	}}}
+	err := coll.FindOne(ctx, filter).Decode(&s)
+	return s, handleError(ctx, err)
+}
+
+// FindActiveSessions returns all sessions of the given user that are neither
+// expired nor revoked, newest first.
+//
+// If userID is empty, active sessions of all users are returned.
+func FindActiveSessions(ctx context.Context, userID string) []Session {
+	coll := mongoDatabase.Collection("sessions")
+	filter := bson.D{
+		{"revoked_at", nil},
+		{"expires_at", bson.D{{"$gt", time.Now()}}},
+	}
+	if userID != "" {
+		filter = append(filter, bson.E{"user_id", userID})
+	}
+	opts := options.Find().SetSort(bson.D{{"created_at", -1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	handleError(ctx, err)
+	sessions := make([]Session, 0)
+	err = cursor.All(ctx, &sessions)
+	handleError(ctx, err)
+	return sessions
+}
+
+// FindSessionsRevokedAfter returns all sessions that were revoked after the
+// given time.
+func FindSessionsRevokedAfter(ctx context.Context, t time.Time) []Session {
+	coll := mongoDatabase.Collection("sessions")
+	filter := bson.D{{"revoked_at", bson.D{{"$gt", t}}}}
+	cursor, err := coll.Find(ctx, filter)
+	handleError(ctx, err)
+	var sessions []Session
+	err = cursor.All(ctx, &sessions)
+	handleError(ctx, err)
+	return sessions
+}
+
+// UpdateSessionRefreshed replaces the refresh token of the session if its
+// current refresh token has the given hash and the session was not revoked.
+func UpdateSessionRefreshed(id primitive.ObjectID, oldHash, newHash string, t time.Time) (ok bool) {
+	coll := mongoDatabase.Collection("sessions")
+	filter := bson.D{
+		{"_id", id},
+		{"refresh_token_hash", oldHash},
+		{"revoked_at", nil},
+	}
+	update := bson.D{{"$set", bson.D{
+		{"refresh_token_hash", newHash},
+		{"previous_refresh_token_hash", oldHash},
+		{"refreshed_at", t},
+	}}}
+	result, err := coll.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		panic(err)
+	}
+	return result.MatchedCount > 0
+}
+
+// UpdateSessionRevoked revokes the session with the given ID.
+func UpdateSessionRevoked(id primitive.ObjectID, revokedBy string) (ok bool) {
+	coll := mongoDatabase.Collection("sessions")
+	filter := bson.D{{"_id", id}, {"revoked_at", nil}}
+	update := bson.D{{"$set", bson.D{
+		{"revoked_at", time.Now()},
+		{"revoked_by", revokedBy},
+	}}}
+	result, err := coll.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		panic(err)
+	}
+	return result.MatchedCount > 0
+}
+
+// DeleteSessionsExpiredBefore deletes all sessions that expired before the
+// given time.
+func DeleteSessionsExpiredBefore(t time.Time) {
+	coll := mongoDatabase.Collection("sessions")
+	filter := bson.D{{"expires_at", bson.D{{"$lt", t}}}}
+	_, err := coll.DeleteMany(context.Background(), filter)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -22,6 +22,38 @@ type SubmissionProcess struct {
 	// with the submission process. A number greater than 0 indicates a failed
 	// state.
 	UnresolvedErrors int `bson:"unresolved_errors" json:"unresolvedErrors"`
+	// AppraisalLevel overrides the appraisal level of the agency for this
+	// process if set.
+	AppraisalLevel AppraisalLevel `bson:"appraisal_level" json:"appraisalLevel"`
+	// AppraisalReopenings lists every time the appraisal was reopened after it
+	// had been finalized.
+	AppraisalReopenings []AppraisalReopening `bson:"appraisal_reopenings" json:"appraisalReopenings"`
+}
+
+// Correction0502Mode determines how a corrected 0502 message treats the
+// previously sent 0502 message in the transfer directory.
+type Correction0502Mode string
+
+const (
+	// Correction0502ModeReplace deletes the previous 0502 message.
+	Correction0502ModeReplace Correction0502Mode = "replace"
+	// Correction0502ModeSupersede keeps the previous 0502 message under a new
+	// name that marks it as superseded.
+	Correction0502ModeSupersede Correction0502Mode = "supersede"
+)
+
+type AppraisalReopening struct {
+	ReopenedAt time.Time `bson:"reopened_at" json:"reopenedAt"`
+	// ReopenedBy is the name of the user who reopened the appraisal.
+	ReopenedBy string             `bson:"reopened_by" json:"reopenedBy"`
+	Mode       Correction0502Mode `json:"mode"`
+	// Previous0502Path is the path of the previous 0502 message in the
+	// transfer directory after the corrected message has been sent. It is
+	// empty for replaced messages and messages that had already been removed.
+	Previous0502Path string `bson:"previous_0502_path" json:"previous0502Path"`
+	// ResentAt is the time at which the corrected 0502 message has been sent.
+	// Zero as long as the appraisal has not been finalized again.
+	ResentAt time.Time `bson:"resent_at" json:"resentAt"`
 }
 
 type ProcessState struct {
@@ -31,6 +63,8 @@ type ProcessState struct {
 	Receive0503        ProcessStep `bson:"receive_0503" json:"receive0503"`
 	FormatVerification ProcessStep `bson:"format_verification" json:"formatVerification"`
 	Archiving          ProcessStep `bson:"archiving" json:"archiving"`
+	// TextExtraction is optional and not a precondition for archiving.
+	TextExtraction ProcessStep `bson:"text_extraction" json:"textExtraction"`
 }
 
 type ProcessStepType string
@@ -42,6 +76,7 @@ const (
 	ProcessStepReceive0503        ProcessStepType = "receive_0503"
 	ProcessStepFormatVerification ProcessStepType = "format_verification"
 	ProcessStepArchiving          ProcessStepType = "archiving"
+	ProcessStepTextExtraction     ProcessStepType = "text_extraction"
 )
 
 type ProcessStep struct {
@@ -72,7 +107,12 @@ func FindProcesses(ctx context.Context) []SubmissionProcess {
 }
 
 func FindProcessesForUser(ctx context.Context, userID string) []SubmissionProcess {
-	return findProcesses(ctx, bson.D{{"agency.users", bson.D{{"$all", bson.A{userID}}}}})
+	return findProcesses(ctx, assignedUserFilter("agency.", userID))
+}
+
+// FindProcessesForAgencies returns all processes of the given agencies.
+func FindProcessesForAgencies(ctx context.Context, agencyIDs []primitive.ObjectID) []SubmissionProcess {
+	return findProcesses(ctx, bson.D{{"agency._id", bson.D{{"$in", agencyIDs}}}})
 }
 
 func findProcesses(ctx context.Context, filter interface{}) []SubmissionProcess {
@@ -168,6 +208,34 @@ func UpdateProcessNote(
 	return updateProcess(processID, update)
 }
 
+func UpdateProcessAppraisalLevel(
+	processID string,
+	level AppraisalLevel,
+) (ok bool) {
+	update := bson.D{{"$set", bson.D{{"appraisal_level", level}}}}
+	return updateProcess(processID, update)
+}
+
+func PushAppraisalReopening(processID string, r AppraisalReopening) (ok bool) {
+	update := bson.D{{"$push", bson.D{{"appraisal_reopenings", r}}}}
+	return updateProcess(processID, update)
+}
+
+// UpdateAppraisalReopeningResent marks the reopening with the given index as
+// completed by sending a corrected 0502 message.
+func UpdateAppraisalReopeningResent(
+	processID string,
+	index int,
+	previous0502Path string,
+) (ok bool) {
+	prefix := fmt.Sprintf("appraisal_reopenings.%d.", index)
+	update := bson.D{{"$set", bson.D{
+		{prefix + "resent_at", time.Now()},
+		{prefix + "previous_0502_path", previous0502Path},
+	}}}
+	return updateProcess(processID, update)
+}
+
 func UpdateProcessStepCompletion(
 	processID string,
 	step ProcessStepType,
@@ -285,6 +353,7 @@ func updateUnresolvedErrorsForProcess(processID string, unresolvedErrors []Proce
 		ProcessStepAppraisal,
 		ProcessStepArchiving,
 		ProcessStepFormatVerification,
+		ProcessStepTextExtraction,
 	} {
 		if hasErrorMap[step] != old.Lookup("process_state", string(step), "has_error").Boolean() {
 			set = append(set,
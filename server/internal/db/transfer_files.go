@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,6 +13,15 @@ type TransferFile struct {
 	AgencyID  primitive.ObjectID `bson:"agency_id"`
 	ProcessID *string            `bson:"process_id"`
 	Path      string             `bson:"path"`
+	// ContentHash is the hex-encoded SHA-256 hash of the file's content at the
+	// time it was imported. It is empty for files that are not imported
+	// messages.
+	ContentHash string `bson:"content_hash"`
+	// Size and ModTime are the file's size and modification time as last seen
+	// in the transfer directory. They are used to detect changes to the file
+	// without reading its content.
+	Size    int64     `bson:"size"`
+	ModTime time.Time `bson:"mod_time"`
 }
 
 // FindProcessedTransferDirFile returns a map of processed paths for the given
@@ -48,6 +58,20 @@ func FindTransferDirFilesForProcess(processID string) []TransferFile {
 	return files
 }
 
+// FindTransferFile returns the entry for the given file in the transfer
+// directory of the agency.
+func FindTransferFile(agencyID primitive.ObjectID, path string) (f TransferFile, ok bool) {
+	coll := mongoDatabase.Collection("transfer_files")
+	filter := bson.D{{"agency_id", agencyID}, {"path", path}}
+	err := coll.FindOne(context.Background(), filter).Decode(&f)
+	if err == mongo.ErrNoDocuments {
+		return f, false
+	} else if err != nil {
+		panic(err)
+	}
+	return f, true
+}
+
 // InsertTransferFile marks a file in a transfer directory as already processed.
 // This file will not be processed again until the entry for the file is
 // removed.
@@ -71,6 +95,52 @@ func InsertTransferFile(agencyID primitive.ObjectID, processID *string, path str
 	return true
 }
 
+// UpdateTransferFileContentHash saves the content hash of an imported transfer
+// file.
+func UpdateTransferFileContentHash(agencyID primitive.ObjectID, path string, hash string) {
+	coll := mongoDatabase.Collection("transfer_files")
+	filter := bson.D{{"agency_id", agencyID}, {"path", path}}
+	update := bson.D{{"$set", bson.D{{"content_hash", hash}}}}
+	_, err := coll.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// UpdateTransferFileStat saves the size and modification time of a transfer
+// file as last seen in the transfer directory.
+func UpdateTransferFileStat(agencyID primitive.ObjectID, path string, size int64, modTime time.Time) {
+	coll := mongoDatabase.Collection("transfer_files")
+	filter := bson.D{{"agency_id", agencyID}, {"path", path}}
+	update := bson.D{{"$set", bson.D{{"size", size}, {"mod_time", modTime}}}}
+	_, err := coll.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// FindTransferFileByContentHash returns a transfer file of the given agency
+// with the given content hash, other than the file with the given path.
+func FindTransferFileByContentHash(
+	agencyID primitive.ObjectID,
+	hash string,
+	excludedPath string,
+) (f TransferFile, ok bool) {
+	coll := mongoDatabase.Collection("transfer_files")
+	filter := bson.D{
+		{"agency_id", agencyID},
+		{"content_hash", hash},
+		{"path", bson.D{{"$ne", excludedPath}}},
+	}
+	err := coll.FindOne(context.Background(), filter).Decode(&f)
+	if err == mongo.ErrNoDocuments {
+		return f, false
+	} else if err != nil {
+		panic(err)
+	}
+	return f, true
+}
+
 func DeleteTransferFile(agencyID primitive.ObjectID, path string) (ok bool) {
 	coll := mongoDatabase.Collection("transfer_files")
 	filter := bson.D{{"agency_id", agencyID}, {"path", path}}
@@ -5,15 +5,39 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Warning represents a problem with a submission that is communicated to the
 // archivist.
 type Warning struct {
-	CreatedAt   time.Time   `bson:"created_at" json:"createdAt"`
-	Title       string      `bson:"title" json:"title"`
-	ProcessID   string      `bson:"process_id" json:"processId"`
-	MessageType MessageType `bson:"message_type" json:"-"`
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedAt   time.Time          `bson:"created_at" json:"createdAt"`
+	Title       string             `bson:"title" json:"title"`
+	ProcessID   string             `bson:"process_id" json:"processId"`
+	MessageType MessageType        `bson:"message_type" json:"-"`
+	// SchemaViolations are set for messages that were imported despite
+	// violating the xdomea schema.
+	SchemaViolations []SchemaViolation `bson:"schema_violations" json:"schemaViolations,omitempty"`
+	// SchemaViolationCount is the number of all schema violations, which can
+	// be more than the number of stored violations.
+	SchemaViolationCount int `bson:"schema_violation_count" json:"schemaViolationCount,omitempty"`
+	// AcknowledgedAt and AcknowledgedBy are set when an administrator
+	// acknowledged the schema violations. Submissions with unacknowledged
+	// schema violations cannot be archived.
+	AcknowledgedAt *time.Time `bson:"acknowledged_at" json:"acknowledgedAt,omitempty"`
+	AcknowledgedBy string     `bson:"acknowledged_by" json:"acknowledgedBy,omitempty"`
+}
+
+// SchemaViolation is a single violation of the xdomea schema found in a
+// message.
+type SchemaViolation struct {
+	Message string `bson:"message" json:"message"`
+	// Line is the line number in the message file.
+	Line int `bson:"line" json:"line"`
+	// XPath locates the affected element, e.g., "/Aussonderung[1]/Kopf[1]".
+	// It is empty if the element could not be determined.
+	XPath string `bson:"xpath" json:"xpath"`
 }
 
 // FindWarningsForProcess returns all warnings for the given submission process
@@ -44,6 +68,52 @@ func InsertWarning(w Warning) {
 	})
 }
 
+// FindWarning returns the warning with the given ID.
+func FindWarning(ctx context.Context, id primitive.ObjectID) (w Warning, ok bool) {
+	coll := mongoDatabase.Collection("warnings")
+	filter := bson.D{{"_id", id}}
+	err := coll.FindOne(ctx, filter).Decode(&w)
+	return w, handleError(ctx, err)
+}
+
+// HasUnacknowledgedSchemaViolations returns true if the given submission
+// process has messages with schema violations that have not been acknowledged
+// by an administrator.
+func HasUnacknowledgedSchemaViolations(ctx context.Context, processID string) bool {
+	coll := mongoDatabase.Collection("warnings")
+	filter := bson.D{
+		{"process_id", processID},
+		{"schema_violation_count", bson.D{{"$gt", 0}}},
+		{"acknowledged_at", nil},
+	}
+	n, err := coll.CountDocuments(ctx, filter)
+	handleError(ctx, err)
+	return n > 0
+}
+
+// UpdateWarningAcknowledged marks the schema violations of the given warning
+// as acknowledged by the given user.
+func UpdateWarningAcknowledged(w Warning, user string) (ok bool) {
+	coll := mongoDatabase.Collection("warnings")
+	update := bson.D{{"$set", bson.D{
+		{"acknowledged_at", time.Now()},
+		{"acknowledged_by", user},
+	}}}
+	result, err := coll.UpdateByID(context.Background(), w.ID, update)
+	if err != nil {
+		panic(err)
+	}
+	if result.MatchedCount == 0 {
+		return false
+	}
+	broadcastUpdate(Update{
+		Collection: "warnings",
+		ProcessID:  &w.ProcessID,
+		Operation:  UpdateOperationUpdate,
+	})
+	return true
+}
+
 // DeleteWarningsForProcess deletes all warnings associated with the given
 // submission process.
 func DeleteWarningsForProcess(processID string) {
@@ -20,6 +20,7 @@ import (
 	"lath/xman/internal/mail"
 	"log"
 	"runtime/debug"
+	"slices"
 	"time"
 )
 
@@ -158,6 +159,9 @@ func withData(err error, data db.ProcessingError) db.ProcessingError {
 	if data.TransferPath != "" {
 		e.TransferPath = data.TransferPath
 	}
+	if data.QuarantineID != nil {
+		e.QuarantineID = data.QuarantineID
+	}
 	return *e
 }
 
@@ -190,7 +194,8 @@ func augmentProcessingError(e db.ProcessingError) db.ProcessingError {
 		switch e.ProcessStep {
 		case db.ProcessStepReceive0501, db.ProcessStepAppraisal:
 			e.MessageType = db.MessageType0501
-		case db.ProcessStepReceive0503, db.ProcessStepFormatVerification:
+		case db.ProcessStepReceive0503, db.ProcessStepFormatVerification,
+			db.ProcessStepTextExtraction:
 			e.MessageType = db.MessageType0503
 		case db.ProcessStepReceive0505:
 			e.MessageType = db.MessageType0505
@@ -201,29 +206,54 @@ func augmentProcessingError(e db.ProcessingError) db.ProcessingError {
 
 // sendEmailNotifications sends a notification for the processing error to all
 // administrators that have subscribed for error notifications.
+//
+// Agency-scoped administrators are only notified about errors of their
+// agencies. If they are absent, their deputies are notified instead.
 func sendEmailNotifications(e db.ProcessingError) {
-	users := auth.ListUsers()
-	for _, user := range users {
-		if user.Permissions.Admin {
-			preferences := db.FindUserPreferencesWithDefault(context.Background(), user.ID)
-			if preferences.ErrorEmailNotifications {
-				mailAddr, err := auth.GetMailAddress(user.ID)
-				errorData := db.ProcessingError{
-					Title:     "Fehler beim Versenden einer E-Mail-Benachrichtigung",
-					CreatedAt: time.Now(),
-					Stack:     string(debug.Stack()),
-				}
+	for _, userID := range errorNotificationRecipients(e) {
+		preferences := db.FindUserPreferencesWithDefault(context.Background(), userID)
+		if preferences.ErrorEmailNotifications {
+			mailAddr, err := auth.GetMailAddress(userID)
+			errorData := db.ProcessingError{
+				Title:     "Fehler beim Versenden einer E-Mail-Benachrichtigung",
+				CreatedAt: time.Now(),
+				Stack:     string(debug.Stack()),
+			}
+			if err != nil {
+				errorData.Info = err.Error()
+				db.InsertProcessingErrorFailsafe(errorData)
+			} else {
+				err = mail.SendMailProcessingError(mailAddr, e)
 				if err != nil {
 					errorData.Info = err.Error()
 					db.InsertProcessingErrorFailsafe(errorData)
-				} else {
-					err = mail.SendMailProcessingError(mailAddr, e)
-					if err != nil {
-						errorData.Info = err.Error()
-						db.InsertProcessingErrorFailsafe(errorData)
-					}
 				}
 			}
 		}
 	}
 }
+
+// errorNotificationRecipients returns the users that are notified about the
+// given processing error.
+//
+// The permission is checked for the users assigned to the agency before they
+// are replaced by their deputies, since deputies act for the absent users.
+func errorNotificationRecipients(e db.ProcessingError) []string {
+	var recipients, agencyUsers []string
+	for _, user := range auth.ListUsers() {
+		switch user.Permissions.Scope(auth.PermissionResolveErrors) {
+		case auth.ScopeAll:
+			recipients = append(recipients, user.ID)
+		case auth.ScopeAssignedAgencies:
+			if e.Agency != nil && e.Agency.IsAssigned(user.ID) {
+				agencyUsers = append(agencyUsers, user.ID)
+			}
+		}
+	}
+	for _, userID := range auth.NotificationRecipients(context.Background(), agencyUsers) {
+		if !slices.Contains(recipients, userID) {
+			recipients = append(recipients, userID)
+		}
+	}
+	return recipients
+}
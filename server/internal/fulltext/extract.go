@@ -0,0 +1,430 @@
+package fulltext
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+type extractFunc func(ctx context.Context, filePath string) (string, error)
+
+// extractors maps lower-case file extensions to extraction functions.
+var extractors = map[string]extractFunc{
+	".pdf":  extractPDF,
+	".odt":  extractODF,
+	".ods":  extractODF,
+	".odp":  extractODF,
+	".docx": extractDOCX,
+	".xlsx": extractXLSX,
+	".pptx": extractPPTX,
+	".txt":  extractPlainText,
+	".csv":  extractPlainText,
+	".eml":  extractEmail,
+}
+
+// IsSupported returns true if text can be extracted from files with the given
+// name.
+func IsSupported(filename string) bool {
+	_, ok := extractors[strings.ToLower(path.Ext(filename))]
+	return ok
+}
+
+// Extract returns the plain text of the given file.
+//
+// The format is determined by the file extension.
+func Extract(ctx context.Context, filePath string) (string, error) {
+	extract, ok := extractors[strings.ToLower(path.Ext(filePath))]
+	if !ok {
+		return "", fmt.Errorf("unsupported file format: %s", path.Base(filePath))
+	}
+	text, err := extract(ctx, filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract text from %s: %w", path.Base(filePath), err)
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// maxZipMemberSize is the maximum uncompressed size of a file in an office
+// document archive that is read for text extraction.
+const maxZipMemberSize = 64 << 20
+
+// maxZipCompressionRatio is the maximum ratio of uncompressed to compressed
+// size of a file in an office document archive. Files larger than 1 MiB with
+// a higher ratio are rejected as potential zip bombs.
+const maxZipCompressionRatio = 100
+
+// extractPDF uses pdftotext of the Poppler utilities.
+//
+// At most maxTextLength + 1 bytes of output are read, so the caller can detect
+// truncation. If the output is longer, pdftotext is stopped.
+func extractPDF(ctx context.Context, filePath string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pdftotext", "-q", "-enc", "UTF-8", filePath, "-")
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("pdftotext: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("pdftotext: %w", err)
+	}
+	out, readErr := io.ReadAll(io.LimitReader(stdout, maxTextLength+1))
+	truncated := len(out) > maxTextLength
+	if truncated {
+		cmd.Process.Kill()
+	}
+	err = cmd.Wait()
+	if truncated {
+		return string(out), nil
+	}
+	if err != nil {
+		if stderr.Len() > 0 {
+			return "", fmt.Errorf("pdftotext: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("pdftotext: %w", err)
+	}
+	if readErr != nil {
+		return "", fmt.Errorf("pdftotext: %w", readErr)
+	}
+	return string(out), nil
+}
+
+func extractODF(ctx context.Context, filePath string) (string, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return zipXMLText(&r.Reader, []string{"content.xml"}, "", []string{"p", "h", "line-break", "tab"})
+}
+
+func extractDOCX(ctx context.Context, filePath string) (string, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	files := []string{"word/document.xml", "word/footnotes.xml", "word/endnotes.xml"}
+	return zipXMLText(&r.Reader, files, "t", []string{"p", "br", "tab"})
+}
+
+func extractXLSX(ctx context.Context, filePath string) (string, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	files := []string{"xl/sharedStrings.xml"}
+	files = append(files, numberedFiles(&r.Reader, "xl/worksheets/sheet")...)
+	return zipXMLText(&r.Reader, files, "t", []string{"si", "is"})
+}
+
+func extractPPTX(ctx context.Context, filePath string) (string, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	files := numberedFiles(&r.Reader, "ppt/slides/slide")
+	return zipXMLText(&r.Reader, files, "t", []string{"p", "br"})
+}
+
+func extractPlainText(ctx context.Context, filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(io.LimitReader(file, maxTextLength+1))
+	if err != nil {
+		return "", err
+	}
+	return decodeText(content, ""), nil
+}
+
+func extractEmail(ctx context.Context, filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	m, err := mail.ReadMessage(file)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	decoder := mime.WordDecoder{CharsetReader: charsetReader}
+	for _, key := range []string{"From", "To", "Cc", "Date", "Subject"} {
+		value := m.Header.Get(key)
+		if value == "" {
+			continue
+		}
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		sb.WriteString(key + ": " + value + "\n")
+	}
+	sb.WriteString("\n")
+	body, err := mimePartText(
+		m.Header.Get("Content-Type"),
+		m.Header.Get("Content-Transfer-Encoding"),
+		m.Body,
+	)
+	if err != nil {
+		return "", err
+	}
+	sb.WriteString(body)
+	return sb.String(), nil
+}
+
+// mimePartText returns the text of a MIME part. For multipart/alternative,
+// the plain text alternative is preferred. Attachments are ignored.
+func mimePartText(contentType, transferEncoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+		params = map[string]string{}
+	}
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, newlineSkipper{body})
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		r := multipart.NewReader(body, params["boundary"])
+		var texts []string
+		var html string
+		for {
+			p, err := r.NextRawPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return "", err
+			}
+			disposition, _, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+			if disposition == "attachment" {
+				continue
+			}
+			text, err := mimePartText(
+				p.Header.Get("Content-Type"),
+				p.Header.Get("Content-Transfer-Encoding"),
+				p,
+			)
+			if err != nil {
+				return "", err
+			}
+			partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			if mediaType == "multipart/alternative" && partType == "text/html" {
+				html = text
+			} else if text != "" {
+				texts = append(texts, text)
+			}
+		}
+		if len(texts) == 0 && html != "" {
+			texts = append(texts, html)
+		}
+		return strings.Join(texts, "\n\n"), nil
+	case mediaType == "text/plain":
+		content, err := io.ReadAll(io.LimitReader(body, maxTextLength+1))
+		if err != nil {
+			return "", err
+		}
+		return decodeText(content, params["charset"]), nil
+	case mediaType == "text/html":
+		content, err := io.ReadAll(io.LimitReader(body, maxTextLength+1))
+		if err != nil {
+			return "", err
+		}
+		return htmlToText(decodeText(content, params["charset"])), nil
+	default:
+		return "", nil
+	}
+}
+
+// newlineSkipper removes line breaks from base64 encoded content.
+type newlineSkipper struct {
+	r io.Reader
+}
+
+func (s newlineSkipper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	j := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[j] = b
+			j++
+		}
+	}
+	return j, err
+}
+
+var htmlTagRegex = regexp.MustCompile(`(?s)<(script|style).*?</(script|style)>|<[^>]*>`)
+var htmlBlockRegex = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/li|/h[1-6])\b[^>]*>`)
+
+// htmlToText removes all tags from the given HTML.
+func htmlToText(html string) string {
+	html = htmlBlockRegex.ReplaceAllString(html, "\n$0")
+	text := htmlTagRegex.ReplaceAllString(html, "")
+	replacer := strings.NewReplacer(
+		"&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", "\"", "&#39;", "'",
+	)
+	return replacer.Replace(text)
+}
+
+// decodeText converts the given content to UTF-8.
+//
+// If no charset is given, the content is assumed to be UTF-8 if valid and
+// Windows-1252 otherwise.
+func decodeText(content []byte, charset string) string {
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	if charset != "" {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			if decoded, err := enc.NewDecoder().Bytes(content); err == nil {
+				return string(decoded)
+			}
+		}
+	}
+	if utf8.Valid(content) {
+		return string(content)
+	}
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(content)
+	if err != nil {
+		return strings.ToValidUTF8(string(content), "")
+	}
+	return string(decoded)
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// numberedFiles returns the names of all files in the archive with the given
+// prefix followed by a number and ".xml", sorted by number.
+func numberedFiles(r *zip.Reader, prefix string) []string {
+	type numberedFile struct {
+		name   string
+		number int
+	}
+	var files []numberedFile
+	for _, f := range r.File {
+		if !strings.HasPrefix(f.Name, prefix) || filepath.Ext(f.Name) != ".xml" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(f.Name, prefix), ".xml"))
+		if err == nil {
+			files = append(files, numberedFile{f.Name, n})
+		}
+	}
+	slices.SortFunc(files, func(a, b numberedFile) int { return a.number - b.number })
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.name
+	}
+	return names
+}
+
+// zipXMLText returns the text of the given XML files in the archive. Missing
+// files are skipped.
+//
+// If textElement is not empty, only character data of elements with the given
+// local name is included. A line break is inserted after each element listed
+// in breakElements.
+//
+// Files that exceed maxZipMemberSize or maxZipCompressionRatio are rejected
+// before they are decompressed. Reading stops once more than maxTextLength
+// bytes of text were extracted.
+func zipXMLText(
+	r *zip.Reader,
+	files []string,
+	textElement string,
+	breakElements []string,
+) (string, error) {
+	var sb strings.Builder
+	for _, name := range files {
+		i := slices.IndexFunc(r.File, func(f *zip.File) bool { return f.Name == name })
+		if i < 0 {
+			continue
+		}
+		f := r.File[i]
+		if f.UncompressedSize64 > maxZipMemberSize {
+			return "", fmt.Errorf("%s: uncompressed size exceeds %d bytes", name, maxZipMemberSize)
+		}
+		if f.UncompressedSize64 > 1<<20 &&
+			f.UncompressedSize64 > f.CompressedSize64*maxZipCompressionRatio {
+			return "", fmt.Errorf("%s: compression ratio exceeds %d", name, maxZipCompressionRatio)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		err = xmlText(&sb, io.LimitReader(rc, maxZipMemberSize), textElement, breakElements)
+		rc.Close()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		sb.WriteString("\n")
+		if sb.Len() > maxTextLength {
+			break
+		}
+	}
+	return sb.String(), nil
+}
+
+func xmlText(sb *strings.Builder, r io.Reader, textElement string, breakElements []string) error {
+	d := xml.NewDecoder(r)
+	depth := 0
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == textElement {
+				depth++
+			}
+			if t.Name.Local == "tab" {
+				sb.WriteString("\t")
+			}
+		case xml.EndElement:
+			if t.Name.Local == textElement {
+				depth--
+			}
+			if t.Name.Local != "tab" && slices.Contains(breakElements, t.Name.Local) {
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			if textElement == "" || depth > 0 {
+				sb.Write(t)
+			}
+		}
+	}
+}
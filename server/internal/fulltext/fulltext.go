@@ -0,0 +1,108 @@
+// Package fulltext extracts plain text from primary documents to make their
+// contents searchable.
+package fulltext
+
+import (
+	"context"
+	"fmt"
+	"lath/xman/internal/db"
+	"lath/xman/internal/tasks"
+	"os"
+	"path"
+	"unicode/utf8"
+)
+
+func init() {
+	tasks.RegisterTaskHandler(
+		db.ProcessStepTextExtraction,
+		initExtractionHandler,
+		tasks.Options{ConcurrentItems: 4, SafeRepeat: true},
+	)
+}
+
+// maxTextLength is the maximum number of bytes of text that is saved per
+// primary document.
+const maxTextLength = 4 << 20
+
+// IsEnabled returns true if text extraction is enabled by configuration.
+func IsEnabled() bool {
+	return os.Getenv("TEXT_EXTRACTION") == "true"
+}
+
+// ExtractTexts starts a task that extracts plain text from all primary
+// documents of the given process that have a supported format.
+func ExtractTexts(process db.SubmissionProcess, primaryDocuments []db.PrimaryDocumentData) {
+	var items []db.TaskItem
+	for _, d := range primaryDocuments {
+		if !IsSupported(d.Filename) {
+			continue
+		}
+		items = append(items, db.TaskItem{
+			Label: d.Filename,
+			State: db.TaskStatePending,
+			Data:  d.Filename,
+		})
+	}
+	if len(items) == 0 {
+		return
+	}
+	task := db.Task{
+		ProcessID: process.ProcessID,
+		Type:      db.ProcessStepTextExtraction,
+		Items:     items,
+	}
+	task = db.InsertTask(task)
+	tasks.Run(&task)
+}
+
+type ExtractionHandler struct {
+	message db.Message
+}
+
+// HandleItem extracts the text of the given primary document and saves it to
+// the database.
+func (h *ExtractionHandler) HandleItem(
+	ctx context.Context,
+	itemData interface{},
+	updateItemData func(data interface{}),
+) error {
+	filename := itemData.(string)
+	processID := h.message.MessageHead.ProcessID
+	data, ok := db.FindPrimaryDocumentData(ctx, processID, filename)
+	if !ok {
+		return fmt.Errorf("primary document not found: %s", filename)
+	}
+	text, err := Extract(ctx, path.Join(h.message.StoreDir, filename))
+	if err != nil {
+		return err
+	}
+	truncated := false
+	if len(text) > maxTextLength {
+		end := maxTextLength
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		text = text[:end]
+		truncated = true
+	}
+	db.UpsertPrimaryDocumentText(db.PrimaryDocumentText{
+		ProcessID: processID,
+		RecordID:  data.RecordID,
+		Filename:  filename,
+		Text:      text,
+		Truncated: truncated,
+	})
+	return nil
+}
+
+func (h *ExtractionHandler) Finish() {}
+
+func (h *ExtractionHandler) AfterDone() {}
+
+func initExtractionHandler(t *db.Task) (tasks.ItemHandler, error) {
+	message, ok := db.FindMessage(context.Background(), t.ProcessID, db.MessageType0503)
+	if !ok {
+		return nil, fmt.Errorf("failed to find 0503 message for process %v", t.ProcessID)
+	}
+	return &ExtractionHandler{message: message}, nil
+}
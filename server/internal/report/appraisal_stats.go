@@ -10,6 +10,7 @@ type objectAppraisalStats struct {
 	Offered           int
 	Archived          int
 	PartiallyArchived int
+	Sampled           int
 	Discarded         int
 	Missing           int
 	Surplus           int
@@ -23,27 +24,38 @@ type appraisalStats struct {
 
 type appraisalMap = map[string]db.Appraisal
 
+// documentsHaveDiscarded returns true if any of the given documents has been
+// appraised "V" which is only possible with appraisal level "document".
+func documentsHaveDiscarded(documents []db.DocumentRecord, m appraisalMap) bool {
+	for _, d := range documents {
+		if m[d.RecordID].Decision == db.AppraisalDecisionV {
+			return true
+		}
+	}
+	return false
+}
+
 func fileHasDiscardedChildren(file db.FileRecord, m appraisalMap) bool {
 	for _, s := range file.Subfiles {
-		if m[s.RecordID].Decision != db.AppraisalDecisionA || fileHasDiscardedChildren(s, m) {
+		if !m[s.RecordID].Decision.ToBeArchived() || fileHasDiscardedChildren(s, m) {
 			return true
 		}
 	}
 	for _, p := range file.Processes {
-		if m[p.RecordID].Decision != db.AppraisalDecisionA || processHasDiscardedChildren(p, m) {
+		if !m[p.RecordID].Decision.ToBeArchived() || processHasDiscardedChildren(p, m) {
 			return true
 		}
 	}
-	return false
+	return documentsHaveDiscarded(file.Documents, m)
 }
 
 func processHasDiscardedChildren(process db.ProcessRecord, m appraisalMap) bool {
 	for _, s := range process.Subprocesses {
-		if m[s.RecordID].Decision != db.AppraisalDecisionA || processHasDiscardedChildren(s, m) {
+		if !m[s.RecordID].Decision.ToBeArchived() || processHasDiscardedChildren(s, m) {
 			return true
 		}
 	}
-	return false
+	return documentsHaveDiscarded(process.Documents, m)
 }
 
 // processFiles adds the given files to the appraisal stats.
@@ -65,7 +77,7 @@ func (a *appraisalStats) processFiles(
 	}
 	for _, f := range offeredFiles {
 		switch d := m[f.RecordID].Decision; d {
-		case db.AppraisalDecisionA:
+		case db.AppraisalDecisionA, db.AppraisalDecisionB:
 			if submittedFiles != nil && !submittedFilesMap[f.RecordID] {
 				a.Files.Missing++
 			} else if fileHasDiscardedChildren(f, m) {
@@ -73,6 +85,9 @@ func (a *appraisalStats) processFiles(
 			} else {
 				a.Files.Archived++
 			}
+			if d == db.AppraisalDecisionB {
+				a.Files.Sampled++
+			}
 		case db.AppraisalDecisionV:
 			if submittedFilesMap[f.RecordID] {
 				a.Files.Surplus++
@@ -112,7 +127,7 @@ func (a *appraisalStats) processProcesses(
 	}
 	for _, p := range offeredProcesses {
 		switch d := m[p.RecordID].Decision; d {
-		case db.AppraisalDecisionA:
+		case db.AppraisalDecisionA, db.AppraisalDecisionB:
 			if submittedProcesses != nil && !submittedProcessesMap[p.RecordID] {
 				a.Processes.Missing++
 			} else if processHasDiscardedChildren(p, m) {
@@ -120,6 +135,9 @@ func (a *appraisalStats) processProcesses(
 			} else {
 				a.Processes.Archived++
 			}
+			if d == db.AppraisalDecisionB {
+				a.Processes.Sampled++
+			}
 		case db.AppraisalDecisionV:
 			if submittedProcessesMap[p.RecordID] {
 				a.Processes.Surplus++
@@ -143,8 +161,12 @@ func (a *appraisalStats) processProcesses(
 // processDocuments adds the given documents to the appraisal stats.
 //
 // Documents have to be on the root level of submission message.
+//
+// If submittedDocuments is nil, stats to surplus and missing documents are
+// omitted.
 func (a *appraisalStats) processDocuments(
 	offeredDocuments, submittedDocuments []db.DocumentRecord,
+	m appraisalMap,
 ) {
 	offeredDocumentsMap := make(map[string]bool)
 	for _, d := range offeredDocuments {
@@ -154,13 +176,21 @@ func (a *appraisalStats) processDocuments(
 	for _, d := range submittedDocuments {
 		submittedDocumentsMap[d.RecordID] = true
 	}
-	// Documents on the root level cannot be appraised and are therefore
-	// automatically archived.
+	// Documents on the root level can only be appraised with appraisal level
+	// "document". Otherwise, they are automatically archived.
 	for _, d := range offeredDocuments {
-		if submittedDocuments == nil && submittedDocumentsMap[d.RecordID] {
-			a.Documents.Archived++
-		} else {
+		switch decision := m[d.RecordID].Decision; {
+		case decision == db.AppraisalDecisionV && submittedDocumentsMap[d.RecordID]:
+			a.Documents.Surplus++
+		case decision == db.AppraisalDecisionV:
+			a.Documents.Discarded++
+		case submittedDocuments != nil && !submittedDocumentsMap[d.RecordID]:
 			a.Documents.Missing++
+		default:
+			a.Documents.Archived++
+			if decision == db.AppraisalDecisionB {
+				a.Documents.Sampled++
+			}
 		}
 		a.Documents.Offered++
 		a.Documents.Total++
@@ -189,11 +219,11 @@ func getAppraisalStats(ctx context.Context, message0501 db.Message, message0503
 		submittedRootRecords := db.FindAllRootRecords(ctx, message0503.MessageHead.ProcessID, message0503.MessageType)
 		a.processFiles(offeredRootRecords.Files, submittedRootRecords.Files, m)
 		a.processProcesses(offeredRootRecords.Processes, submittedRootRecords.Processes, m)
-		a.processDocuments(offeredRootRecords.Documents, submittedRootRecords.Documents)
+		a.processDocuments(offeredRootRecords.Documents, submittedRootRecords.Documents, m)
 	} else {
 		a.processFiles(offeredRootRecords.Files, nil, m)
 		a.processProcesses(offeredRootRecords.Processes, nil, m)
-		a.processDocuments(offeredRootRecords.Documents, nil)
+		a.processDocuments(offeredRootRecords.Documents, nil, m)
 	}
 	return
 }
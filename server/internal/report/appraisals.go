@@ -11,6 +11,9 @@ type AppraisalStructure struct {
 	Children          []AppraisalStructure
 	AppraisalDecision db.AppraisalDecisionOption
 	AppraisalNote     string
+	// AppraisalPublicNote is the note sent to the agency with the 0502
+	// message.
+	AppraisalPublicNote string
 }
 
 func appraisalInfo(
@@ -18,21 +21,28 @@ func appraisalInfo(
 	process db.SubmissionProcess,
 ) []AppraisalStructure {
 	rootRecords := db.FindAllRootRecords(ctx, process.ProcessID, db.MessageType0501)
-	records := core.AppraisableRecords(&rootRecords)
+	records := core.AppraisableRecords(&rootRecords, core.AppraisalLevel(process))
 	appraisals := getAppraisalsMap(process.ProcessID)
-	return appraisalInfoNodes("", rootRecords.Files, rootRecords.Processes, records, appraisals)
+	return appraisalInfoNodes(
+		"", rootRecords.Files, rootRecords.Processes, rootRecords.Documents,
+		records, appraisals,
+	)
 }
 
 func appraisalInfoNodes(
 	parentType db.RecordType,
 	files []db.FileRecord,
 	processes []db.ProcessRecord,
+	documents []db.DocumentRecord,
 	records core.AppraisableRecordsMap,
 	appraisals appraisalMap,
 ) []AppraisalStructure {
 	var result []AppraisalStructure
 	for _, file := range files {
-		children := appraisalInfoNodes(db.RecordTypeFile, file.Subfiles, file.Processes, records, appraisals)
+		children := appraisalInfoNodes(
+			db.RecordTypeFile, file.Subfiles, file.Processes, file.Documents,
+			records, appraisals,
+		)
 		// If there are any children of the node which have a different
 		// appraisal decision than the node itself, we include all children in
 		// the report.
@@ -43,22 +53,41 @@ func appraisalInfoNodes(
 			children = filterHasChildren(children)
 		}
 		result = append(result, AppraisalStructure{
-			Title:             core.FileRecordTitle(file, parentType == db.RecordTypeFile),
-			AppraisalDecision: appraisals[file.RecordID].Decision,
-			AppraisalNote:     appraisals[file.RecordID].Note,
-			Children:          children,
+			Title:               core.FileRecordTitle(file, parentType == db.RecordTypeFile),
+			AppraisalDecision:   appraisals[file.RecordID].Decision,
+			AppraisalNote:       appraisals[file.RecordID].Note,
+			AppraisalPublicNote: appraisals[file.RecordID].PublicNote,
+			Children:            children,
 		})
 	}
 	for _, process := range processes {
-		children := appraisalInfoNodes(db.RecordTypeProcess, []db.FileRecord{}, process.Subprocesses, records, appraisals)
+		children := appraisalInfoNodes(
+			db.RecordTypeProcess, []db.FileRecord{}, process.Subprocesses, process.Documents,
+			records, appraisals,
+		)
 		if !hasDivergentAppraisals(process.RecordID, records, appraisals) {
 			children = filterHasChildren(children)
 		}
 		result = append(result, AppraisalStructure{
-			Title:             core.ProcessRecordTitle(process, parentType == db.RecordTypeProcess),
-			AppraisalDecision: appraisals[process.RecordID].Decision,
-			AppraisalNote:     appraisals[process.RecordID].Note,
-			Children:          children,
+			Title:               core.ProcessRecordTitle(process, parentType == db.RecordTypeProcess),
+			AppraisalDecision:   appraisals[process.RecordID].Decision,
+			AppraisalNote:       appraisals[process.RecordID].Note,
+			AppraisalPublicNote: appraisals[process.RecordID].PublicNote,
+			Children:            children,
+		})
+	}
+	// Documents are only included if they are appraisable themselves.
+	for _, document := range documents {
+		if _, ok := records[document.RecordID]; !ok {
+			continue
+		}
+		// Documents have no appraisable children, so they are only included
+		// in the report if their parent has divergent appraisals.
+		result = append(result, AppraisalStructure{
+			Title:               core.DocumentRecordTitle(document, false),
+			AppraisalDecision:   appraisals[document.RecordID].Decision,
+			AppraisalNote:       appraisals[document.RecordID].Note,
+			AppraisalPublicNote: appraisals[document.RecordID].PublicNote,
 		})
 	}
 	return result
@@ -75,19 +104,21 @@ func filterHasChildren(nodes []AppraisalStructure) []AppraisalStructure {
 	return result
 }
 
-// hasDivergentAppraisals returns true if the given record has any direct
-// children with a different appraisal decision than itself.
+// hasDivergentAppraisals returns true if the given record is archived and has
+// any direct children that are discarded.
+//
+// The decisions "A" and "B" are treated alike since both archive the record.
 func hasDivergentAppraisals(
 	recordID string,
 	records core.AppraisableRecordsMap,
 	appraisals appraisalMap,
 ) bool {
-	if appraisals[recordID].Decision != db.AppraisalDecisionA {
+	if !appraisals[recordID].Decision.ToBeArchived() {
 		// All sub records of discarded records are discarded as well.
 		return false
 	}
 	for _, childID := range records[recordID].Children {
-		if appraisals[childID].Decision != db.AppraisalDecisionA {
+		if !appraisals[childID].Decision.ToBeArchived() {
 			return true
 		}
 	}
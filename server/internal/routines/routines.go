@@ -4,13 +4,18 @@ package routines
 import (
 	"context"
 	"fmt"
+	"lath/xman/internal/audit"
+	"lath/xman/internal/auth"
 	"lath/xman/internal/core"
 	"lath/xman/internal/db"
 	"lath/xman/internal/errors"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // interval is the time interval between scheduled routine runs.
@@ -24,7 +29,11 @@ func Init() {
 			log.Println("Starting cleanup routines...")
 			cleanupArchivedProcesses()
 			cleanupErrors()
+			cleanupSessions()
+			cleanupAuditLog()
+			cleanupAbsences()
 			log.Println("Cleanup routines done")
+			SyncUserDirectory()
 			time.Sleep(interval)
 		}
 	}()
@@ -80,9 +89,93 @@ func cleanupErrors() {
 	}
 }
 
+// cleanupSessions deletes expired login sessions.
+func cleanupSessions() {
+	defer errors.HandlePanic("cleanupSessions", nil)
+	db.DeleteSessionsExpiredBefore(time.Now())
+}
+
+// cleanupAbsences deletes absences that have ended.
+func cleanupAbsences() {
+	defer errors.HandlePanic("cleanupAbsences", nil)
+	db.DeleteAbsencesEndedBefore(time.Now())
+}
+
+// cleanupAuditLog deletes audit log entries after the retention period.
+//
+// The retention period can be configured in days with the environment variable
+// `AUDIT_LOG_RETENTION_DAYS`. If it is not set, entries are kept forever.
+func cleanupAuditLog() {
+	defer errors.HandlePanic("cleanupAuditLog", nil)
+	retention := os.Getenv("AUDIT_LOG_RETENTION_DAYS")
+	if retention == "" {
+		return
+	}
+	retentionDays, err := strconv.Atoi(retention)
+	if err != nil || retentionDays < 1 {
+		panic("improper env variable AUDIT_LOG_RETENTION_DAYS")
+	}
+	deleteBeforeTime := time.Now().Add(-1 * time.Hour * 24 * time.Duration(retentionDays))
+	db.DeleteAuditEntriesBefore(deleteBeforeTime)
+}
+
+// SyncUserDirectory refreshes the cached users of the LDAP directory and the
+// members of the groups of agencies and flags agencies with orphaned user
+// assignments.
+func SyncUserDirectory() {
+	defer errors.HandlePanic("SyncUserDirectory", nil)
+	_, left := auth.SyncDirectory()
+	if left > 0 {
+		log.Printf("%d users left the LDAP directory\n", left)
+	}
+	FlagOrphanedAssignments()
+}
+
+// FlagOrphanedAssignments adds a processing error for each agency with assigned
+// users that are not listed by any user directory anymore, unless there is an
+// unresolved one for the agency already.
+func FlagOrphanedAssignments() {
+	flagged := make(map[primitive.ObjectID]bool)
+	for _, e := range db.FindUnresolvedProcessingErrorsByType(context.Background(), "orphaned-users") {
+		if e.Agency != nil {
+			flagged[e.Agency.ID] = true
+		}
+	}
+	for _, o := range auth.FindOrphanedAssignments(context.Background()) {
+		if flagged[o.Agency.ID] {
+			continue
+		}
+		var names []string
+		for _, u := range o.Users {
+			name := u.DisplayName
+			if name == "" {
+				name = u.ID
+			}
+			if u.LeftAt != nil {
+				name += fmt.Sprintf(" (nicht mehr im Verzeichnis seit %s)", u.LeftAt.Format("02.01.2006"))
+			}
+			names = append(names, name)
+		}
+		agency := o.Agency
+		errors.AddProcessingError(db.ProcessingError{
+			Title:     "Abgebende Stelle hat zugeordnete Nutzer ohne Zugang",
+			ErrorType: "orphaned-users",
+			Agency:    &agency,
+			Info: "Die folgenden zugeordneten Nutzer sind in keinem Nutzerverzeichnis mehr " +
+				"vorhanden oder haben keine Berechtigung mehr:\n\n" + strings.Join(names, "\n"),
+		})
+	}
+}
+
 // deleteProcess deletes the given process and all associated data from the
 // database and removes all associated message files from the message store.
 func deleteProcess(process db.SubmissionProcess) {
+	audit.Record(db.AuditEntry{
+		UserID:    audit.SystemUserID,
+		Action:    string(audit.ActionDeleteProcess),
+		ProcessID: process.ProcessID,
+		AgencyID:  &process.Agency.ID,
+	})
 	found := core.DeleteProcess(process.ProcessID)
 	if !found {
 		panic(fmt.Sprintf("failed to delete process %v: not found", process.ProcessID))
@@ -292,6 +292,9 @@ ItemLoop:
 		var userName string
 		if t.UserID != "" {
 			userName = auth.GetDisplayName(t.UserID)
+			if process, ok := db.FindProcess(context.Background(), t.ProcessID); ok {
+				userName = auth.ActingUserName(context.Background(), t.UserID, process.Agency)
+			}
 		}
 		markDone(t, userName)
 		h.AfterDone()
@@ -400,6 +403,8 @@ func getDisplayName(taskType db.ProcessStepType) string {
 		return "Archivierung"
 	case db.ProcessStepFormatVerification:
 		return "Formatverifikation"
+	case db.ProcessStepTextExtraction:
+		return "Textextraktion"
 	default:
 		return string(taskType)
 	}